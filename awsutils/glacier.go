@@ -5,6 +5,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/glacier"
 	"github.com/aws/aws-sdk-go/service/glacier/glacieriface"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"rsg/outputs"
 	"rsg/utils"
 )
 
@@ -25,4 +26,39 @@ func GetVaults(glacierClient glacieriface.GlacierAPI, marker *string) (*glacier.
 		Marker:    marker,
 	}
 	return glacierClient.ListVaults(params)
-}
\ No newline at end of file
+}
+
+// Inventory information of a vault, updated by aws about once a day
+type VaultState struct {
+	LastInventoryDate string
+	NumberOfArchives  int64
+	SizeInBytes       int64
+}
+
+func GetVaultState(glacierClient glacieriface.GlacierAPI, vault string) (*VaultState, error) {
+	params := &glacier.DescribeVaultInput{
+		AccountId: aws.String(AccountId),
+		VaultName: aws.String(vault),
+	}
+	outputs.Printfln(outputs.Verbose, "Aws call: glacier.DescribeVault(%v)", params)
+	resp, err := glacierClient.DescribeVault(params)
+	outputs.Printfln(outputs.Verbose, "Aws response: %v (error %v)\n", resp, err)
+	if err != nil {
+		return nil, err
+	}
+	return &VaultState{LastInventoryDate: aws.StringValue(resp.LastInventoryDate),
+		NumberOfArchives: aws.Int64Value(resp.NumberOfArchives),
+		SizeInBytes: aws.Int64Value(resp.SizeInBytes)}, nil
+}
+
+func DeleteArchive(glacierClient glacieriface.GlacierAPI, vault, archiveId string) error {
+	params := &glacier.DeleteArchiveInput{
+		AccountId: aws.String(AccountId),
+		ArchiveId: aws.String(archiveId),
+		VaultName: aws.String(vault),
+	}
+	outputs.Printfln(outputs.Verbose, "Aws call: glacier.DeleteArchive(%v)", params)
+	resp, err := glacierClient.DeleteArchive(params)
+	outputs.Printfln(outputs.Verbose, "Aws response: %v (error %v)\n", resp, err)
+	return err
+}
@@ -1,6 +1,7 @@
 package awsutils
 
 import (
+	"bytes"
 	"time"
 	"github.com/aws/aws-sdk-go/aws"
 	"rsg/outputs"
@@ -15,12 +16,16 @@ import (
 	"code.cloudfoundry.org/bytefmt"
 	"github.com/aws/aws-sdk-go/service/glacier/glacieriface"
 	"strings"
+	"sync"
+	"fmt"
 )
 
 type jobIdsAtStartupStruct struct {
-	fileRetrievalJobIdByRangeByArchiveId map[string]map[string]string
+	fileRetrievalJobsByArchiveId         map[string][]retrievalJob
 	MappingInventoryJobId                string
-	MappingRetrievalJobId                string
+	MappingInventoryJobCreationDate      string // ISO 8601, ordered as string
+	MappingRetrievalJobIdByArchiveId     map[string]string // the mapping vault can contain several archives
+	InventoryJobId                       string
 }
 
 type Archive struct {
@@ -29,16 +34,52 @@ type Archive struct {
 }
 
 var WaitTime = 5 * time.Minute
-var JobIdsAtStartup = &jobIdsAtStartupStruct{fileRetrievalJobIdByRangeByArchiveId: make(map[string]map[string]string)}
+
+// Output of a completed job can be downloaded for about 24 hours
+const JobOutputLifetime = 24 * time.Hour
+var JobIdsAtStartup = &jobIdsAtStartupStruct{fileRetrievalJobsByArchiveId: make(map[string][]retrievalJob), MappingRetrievalJobIdByArchiveId: make(map[string]string)}
+
+// Succeeded or in progress job retrieving bytes from start to end (included) of an archive
+type retrievalJob struct {
+	jobId          string
+	start          uint64
+	end            uint64
+	completionDate time.Time // zero if the job is in progress
+}
+
+// for test
+func AddRetrievalJobAtStartup(archiveId, retrievalByteRange, jobId string, completionDate time.Time) {
+	JobIdsAtStartup.addFileRetrievalJob(archiveId, retrievalByteRange, jobId, completionDate)
+}
 
 // for test
-func AddRetrievalJobAtStartup(archiveId, retrievalByteRange, jobId string) {
-	fileRetrievalJobIdByRange, ok := JobIdsAtStartup.fileRetrievalJobIdByRangeByArchiveId[archiveId]
-	if !ok {
-		fileRetrievalJobIdByRange = make(map[string]string)
-		JobIdsAtStartup.fileRetrievalJobIdByRangeByArchiveId[archiveId] = fileRetrievalJobIdByRange
+func ClearRetrievalJobsAtStartup() {
+	JobIdsAtStartup.fileRetrievalJobsByArchiveId = make(map[string][]retrievalJob)
+}
+
+func (jobIdsAtStartup *jobIdsAtStartupStruct) addFileRetrievalJob(archiveId, retrievalByteRange, jobId string, completionDate time.Time) bool {
+	bounds := strings.Split(retrievalByteRange, "-")
+	if len(bounds) != 2 {
+		return false
 	}
-	fileRetrievalJobIdByRange[retrievalByteRange] = jobId
+	start, startErr := strconv.ParseUint(bounds[0], 10, 64)
+	end, endErr := strconv.ParseUint(bounds[1], 10, 64)
+	if startErr != nil || endErr != nil || end < start {
+		return false
+	}
+	jobIdsAtStartup.fileRetrievalJobsByArchiveId[archiveId] = append(jobIdsAtStartup.fileRetrievalJobsByArchiveId[archiveId], retrievalJob{jobId, start, end, completionDate})
+	return true
+}
+
+// Job is no more reused, its output has expired
+func (jobIdsAtStartup *jobIdsAtStartupStruct) RemoveFileRetrievalJob(archiveId, jobId string) {
+	jobs := []retrievalJob{}
+	for _, job := range jobIdsAtStartup.fileRetrievalJobsByArchiveId[archiveId] {
+		if job.jobId != jobId {
+			jobs = append(jobs, job)
+		}
+	}
+	jobIdsAtStartup.fileRetrievalJobsByArchiveId[archiveId] = jobs
 }
 
 func LoadJobIdsAtStartup(glacierClient glacieriface.GlacierAPI, mappingVault, vault string) {
@@ -48,21 +89,24 @@ func LoadJobIdsAtStartup(glacierClient glacieriface.GlacierAPI, mappingVault, va
 			if *desc.StatusCode == "InProgress" || *desc.StatusCode == "Succeeded" {
 				if *desc.Action == "ArchiveRetrieval" {
 					if strings.HasSuffix(*desc.VaultARN, "_mapping") {
-						JobIdsAtStartup.MappingRetrievalJobId = *desc.JobId
+						JobIdsAtStartup.MappingRetrievalJobIdByArchiveId[aws.StringValue(desc.ArchiveId)] = *desc.JobId
 					} else {
-						fileRetrievalJobIdByRange, ok := JobIdsAtStartup.fileRetrievalJobIdByRangeByArchiveId[*desc.ArchiveId]
-						if !ok {
-							fileRetrievalJobIdByRange = make(map[string]string)
-							JobIdsAtStartup.fileRetrievalJobIdByRangeByArchiveId[*desc.ArchiveId] = fileRetrievalJobIdByRange
+						retrievalByteRange := aws.StringValue(desc.RetrievalByteRange)
+						if retrievalByteRange == "" && aws.Int64Value(desc.ArchiveSizeInBytes) > 0 {
+							retrievalByteRange = "0-" + strconv.FormatInt(*desc.ArchiveSizeInBytes - 1, 10)
 						}
-						if desc.RetrievalByteRange != nil {
-							fileRetrievalJobIdByRange[*desc.RetrievalByteRange] = *desc.JobId
+						if JobIdsAtStartup.addFileRetrievalJob(*desc.ArchiveId, retrievalByteRange, *desc.JobId, parseCompletionDate(desc)) {
 							fileRetrievalJobCounter++
 						}
 					}
 				} else {
 					if strings.HasSuffix(*desc.VaultARN, "_mapping") {
-						JobIdsAtStartup.MappingInventoryJobId = *desc.JobId
+						if aws.StringValue(desc.CreationDate) >= JobIdsAtStartup.MappingInventoryJobCreationDate {
+							JobIdsAtStartup.MappingInventoryJobId = *desc.JobId
+							JobIdsAtStartup.MappingInventoryJobCreationDate = aws.StringValue(desc.CreationDate)
+						}
+					} else {
+						JobIdsAtStartup.InventoryJobId = *desc.JobId
 					}
 				}
 			}
@@ -72,23 +116,73 @@ func LoadJobIdsAtStartup(glacierClient glacieriface.GlacierAPI, mappingVault, va
 	DoOnJobPages(glacierClient, mappingVault, recordJobsFn)
 	DoOnJobPages(glacierClient, vault, recordJobsFn)
 	if JobIdsAtStartup.MappingInventoryJobId != "" {
-		outputs.Printfln(outputs.Verbose, "Mapping inventory job found : %s", JobIdsAtStartup.MappingInventoryJobId)
+		outputs.Printfln(outputs.Verbose, "Mapping inventory job found : %s (created at %s)", JobIdsAtStartup.MappingInventoryJobId, JobIdsAtStartup.MappingInventoryJobCreationDate)
 	}
-	if JobIdsAtStartup.MappingRetrievalJobId != "" {
-		outputs.Printfln(outputs.Verbose, "Mapping retrivial job found : %s", JobIdsAtStartup.MappingRetrievalJobId)
+	if JobIdsAtStartup.InventoryJobId != "" {
+		outputs.Printfln(outputs.Verbose, "Inventory job found : %s", JobIdsAtStartup.InventoryJobId)
+	}
+	for archiveId, jobId := range JobIdsAtStartup.MappingRetrievalJobIdByArchiveId {
+		outputs.Printfln(outputs.Verbose, "Mapping retrivial job found for archive %s: %s", archiveId, jobId)
 	}
 	if fileRetrievalJobCounter > 0 {
 		outputs.Printfln(outputs.Verbose, "%v file retrivial job found", fileRetrievalJobCounter)
 	}
 }
 
-func (jobIdsAtStartup *jobIdsAtStartupStruct) GetJobIdForFileRetrieval(archiveId, retrievalByteRange string) string {
-	if fileRetrievalJobIdByRange, ok := jobIdsAtStartup.fileRetrievalJobIdByRangeByArchiveId[archiveId]; ok {
-		if fileRetrievalJobId, ok := fileRetrievalJobIdByRange[retrievalByteRange]; ok {
-			return fileRetrievalJobId
+// Job retrieving the byte fromByte of the archive (the one retrieving the most bytes after it), nil if there is none
+func (jobIdsAtStartup *jobIdsAtStartupStruct) getJobForFileRetrieval(archiveId string, fromByte uint64) *retrievalJob {
+	var coveringJob *retrievalJob
+	for i, job := range jobIdsAtStartup.fileRetrievalJobsByArchiveId[archiveId] {
+		if job.start <= fromByte && fromByte <= job.end && (coveringJob == nil || job.end > coveringJob.end) {
+			coveringJob = &jobIdsAtStartup.fileRetrievalJobsByArchiveId[archiveId][i]
+		}
+	}
+	return coveringJob
+}
+
+func (jobIdsAtStartup *jobIdsAtStartupStruct) HasJobForFileRetrieval(archiveId string, fromByte uint64) bool {
+	return jobIdsAtStartup.getJobForFileRetrieval(archiveId, fromByte) != nil
+}
+
+// First byte after fromByte retrieved by a job of the archive, 0 if there is none
+func (jobIdsAtStartup *jobIdsAtStartupStruct) GetNextRetrievedByte(archiveId string, fromByte uint64) uint64 {
+	nextByte := uint64(0)
+	for _, job := range jobIdsAtStartup.fileRetrievalJobsByArchiveId[archiveId] {
+		if job.start > fromByte && (nextByte == 0 || job.start < nextByte) {
+			nextByte = job.start
+		}
+	}
+	return nextByte
+}
+
+// Completion date of a succeeded job, zero if it is unknown
+func parseCompletionDate(jobDescription *glacier.JobDescription) time.Time {
+	completionDate, err := time.Parse(time.RFC3339, aws.StringValue(jobDescription.CompletionDate))
+	if err != nil || aws.StringValue(jobDescription.StatusCode) != "Succeeded" {
+		return time.Time{}
+	}
+	return completionDate
+}
+
+// Job is unknown by aws, its output has expired
+func IsJobNotFoundError(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "ResourceNotFoundException") || strings.Contains(err.Error(), "The job ID was not found"))
+}
+
+// Waits the retrieval job is completed and returns its completion date (zero if unknown), or returns an error if the
+// job is not found
+func WaitRetrievalJobIsCompleted(glacierClient glacieriface.GlacierAPI, vault, jobId string) (time.Time, error) {
+	for {
+		jobDescription, err := DescribeJob(glacierClient, vault, jobId)
+		if IsJobNotFoundError(err) {
+			return time.Time{}, err
+		}
+		utils.ExitIfError(err)
+		if aws.BoolValue(jobDescription.Completed) {
+			return parseCompletionDate(jobDescription), nil
 		}
+		time.Sleep(1 * WaitTime)
 	}
-	return ""
 }
 
 func WaitJobIsCompleted(glacierClient glacieriface.GlacierAPI, vault, jobId string) {
@@ -124,14 +218,201 @@ func DescribeJob(glacierClient glacieriface.GlacierAPI, vault, jobId string) (*g
 }
 
 func DownloadArchiveTo(glacierClient glacieriface.GlacierAPI, vault, jobId string, filename string) uint64 {
-	return DownloadPartialArchiveTo(glacierClient, vault, jobId, filename, 0, 0, 0)
+	written, _, err := DownloadPartialArchiveTo(glacierClient, vault, jobId, filename, 0, 0, 0, nil, 1)
+	utils.ExitIfError(err)
+	return written
 }
 
-func DownloadPartialArchiveTo(glacierClient glacieriface.GlacierAPI, vault, jobId, destPath string, fromByteToDownload, sizeToDownload, fromByteToWrite uint64) uint64 {
+// Segments of a range downloaded concurrently are at least 1MB. When they are hashed they are at most 16MB: a segment
+// downloaded before the previous ones are hashed is kept in memory until it is hashed.
+const minDownloadSegmentSize = utils.S_1MB
+const maxHashedDownloadSegmentSize = 16 * utils.S_1MB
+
+// Bytes of a range, From is the index from the first byte of the range
+type ByteRange struct {
+	From uint64
+	Size uint64
+}
+
+type downloadSegment struct {
+	from    uint64 // index from the first byte to download
+	size    uint64
+	written uint64
+	err     error
+}
+
+// Bytes written into the destination file are also written in order into hashWriter if it is not nil. The range is
+// split into segments downloaded in parallel by concurrency streams, each one written at its offset in the file. A
+// segment interrupted by a transient error is resumed from its last byte written. The hash stops at the first segment
+// not completely downloaded. Returns the number of bytes written, the ranges of the segments not completely written
+// (empty if there is no error) and the error of aws when the job output cannot be downloaded.
+func DownloadPartialArchiveTo(glacierClient glacieriface.GlacierAPI, vault, jobId, destPath string, fromByteToDownload, sizeToDownload, fromByteToWrite uint64, hashWriter io.Writer, concurrency int) (uint64, []ByteRange, error) {
 	var err error;
+	var file *os.File;
+	file, err = os.OpenFile(destPath, os.O_CREATE | os.O_RDWR, 0600)
+	utils.ExitIfError(err)
+	defer utils.CheckingClose(file, &err)
+	outputs.Printfln(outputs.Verbose, "Copy file into: %v", destPath)
+	maxSegmentSize := uint64(0)
+	if hashWriter != nil {
+		maxSegmentSize = maxHashedDownloadSegmentSize
+	}
+	segments := splitDownloadSegments(sizeToDownload, fromByteToWrite, concurrency, maxSegmentSize)
+	var hashErr error
+	if len(segments) == 1 {
+		segment := &segments[0]
+		segment.written, segment.err = downloadJobOutputSegment(glacierClient, vault, jobId, &fileOffsetWriter{file, hashWriter, fromByteToWrite},
+			fromByteToDownload, sizeToDownload, "")
+		outputs.Printfln(outputs.Verbose, "%v copied", bytefmt.ByteSize(segment.written))
+	} else {
+		orderedHash := &orderedHashWriter{hashWriter: hashWriter, cond: sync.NewCond(&sync.Mutex{})}
+		indexes := make(chan int, len(segments))
+		for i := range segments {
+			indexes <- i
+		}
+		close(indexes)
+		var waitGroup sync.WaitGroup
+		for stream := 0; stream < concurrency && stream < len(segments); stream++ {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				for i := range indexes {
+					segment := &segments[i]
+					name := fmt.Sprintf("segment %d/%d", i + 1, len(segments))
+					var buffer *bytes.Buffer
+					var bufferWriter io.Writer
+					if hashWriter != nil {
+						buffer = bytes.NewBuffer(make([]byte, 0, segment.size))
+						bufferWriter = buffer
+					}
+					segment.written, segment.err = downloadJobOutputSegment(glacierClient, vault, jobId, &fileOffsetWriter{file, bufferWriter, fromByteToWrite + segment.from},
+						fromByteToDownload + segment.from, segment.size, name)
+					outputs.Printfln(outputs.Verbose, "%s: %v of %v copied", name, bytefmt.ByteSize(segment.written), bytefmt.ByteSize(segment.size))
+					if hashWriter != nil {
+						orderedHash.write(i, buffer.Bytes(), segment.err == nil)
+					}
+				}
+			}()
+		}
+		waitGroup.Wait()
+		hashErr = orderedHash.err
+	}
+
+	written := uint64(0)
+	missingRanges := []ByteRange{}
+	for _, segment := range segments {
+		written += segment.written
+		if segment.err != nil {
+			err = segment.err
+			missingRanges = append(missingRanges, ByteRange{From: segment.from + segment.written, Size: segment.size - segment.written})
+		}
+	}
+	if err == nil {
+		err = hashErr
+	}
+	return written, missingRanges, err
+}
+
+// One segment if the size is unknown (0) or too small to be split, else at most concurrency segments unless they
+// are larger than maxSegmentSize (no maximum if 0). Segments other than the last end at a 1MB boundary of the file, so
+// that a missing segment can be retrieved again by a job.
+func splitDownloadSegments(size, fromByteToWrite uint64, concurrency int, maxSegmentSize uint64) []downloadSegment {
+	if concurrency <= 1 || size < 2 * minDownloadSegmentSize {
+		return []downloadSegment{{from: 0, size: size}}
+	}
+	segmentSize := (size + uint64(concurrency) - 1) / uint64(concurrency)
+	segmentSize += (minDownloadSegmentSize - segmentSize % minDownloadSegmentSize) % minDownloadSegmentSize
+	if maxSegmentSize != 0 && segmentSize > maxSegmentSize {
+		segmentSize = maxSegmentSize
+	}
+	segments := []downloadSegment{}
+	for from := uint64(0); from < size; {
+		end := (fromByteToWrite + from + segmentSize + minDownloadSegmentSize - 1) / minDownloadSegmentSize * minDownloadSegmentSize - fromByteToWrite
+		if end > size {
+			end = size
+		}
+		segments = append(segments, downloadSegment{from: from, size: end - from})
+		from = end
+	}
+	return segments
+}
+
+// Writes the segments into the hash writer in the order of the range: a segment waits until the previous ones are
+// written. Nothing is written after a segment not completely downloaded or after an error of the hash writer.
+type orderedHashWriter struct {
+	hashWriter io.Writer
+	cond       *sync.Cond
+	next       int // index of the next segment to write
+	stopped    bool
+	err        error
+}
+
+func (orderedHash *orderedHashWriter) write(index int, p []byte, complete bool) {
+	orderedHash.cond.L.Lock()
+	defer orderedHash.cond.L.Unlock()
+	for orderedHash.next != index {
+		orderedHash.cond.Wait()
+	}
+	if !orderedHash.stopped {
+		_, orderedHash.err = orderedHash.hashWriter.Write(p)
+		orderedHash.stopped = orderedHash.err != nil || !complete
+	}
+	orderedHash.next++
+	orderedHash.cond.Broadcast()
+}
+
+// Writes at its offset in the file and in the hash writer if it is not nil, concurrent writers write at different
+// offsets
+type fileOffsetWriter struct {
+	file       *os.File
+	hashWriter io.Writer
+	offset     uint64
+}
+
+func (writer *fileOffsetWriter) Write(p []byte) (int, error) {
+	n, err := writer.file.WriteAt(p, int64(writer.offset))
+	if writer.hashWriter != nil {
+		if _, hashErr := writer.hashWriter.Write(p[:n]); hashErr != nil && err == nil {
+			err = hashErr
+		}
+	}
+	writer.offset += uint64(n)
+	return n, err
+}
+
+// Downloads the segment (up to the end of the output if size is 0) and resumes it after transient errors
+func downloadJobOutputSegment(glacierClient glacieriface.GlacierAPI, vault, jobId string, writer io.Writer, fromByte, size uint64, name string) (uint64, error) {
+	if name != "" {
+		name = " (" + name + ")"
+	}
+	written := uint64(0)
+	for attempt := 1; ; attempt++ {
+		sizeLeft := uint64(0)
+		if size != 0 {
+			sizeLeft = size - written
+		}
+		copied, err := copyJobOutput(glacierClient, vault, jobId, writer, fromByte + written, sizeLeft, size == 0 && written > 0)
+		written += copied
+		if err == nil || !IsTransientError(err) {
+			return written, err
+		}
+		delay, retry := nextRetry(attempt)
+		if !retry {
+			return written, err
+		}
+		outputs.Printfln(outputs.Warning, "Download of job %s%s interrupted after %v (%v), resumed in %v", jobId, name, bytefmt.ByteSize(written), err, delay)
+		time.Sleep(delay)
+	}
+}
+
+// Copies the job output from the byte fromByte, sizeToDownload bytes or up to the end if 0 (whole output unless
+// openRange is true)
+func copyJobOutput(glacierClient glacieriface.GlacierAPI, vault, jobId string, writer io.Writer, fromByte, sizeToDownload uint64, openRange bool) (uint64, error) {
 	var rangeToRetrieve *string = nil
 	if sizeToDownload != 0 {
-		rangeToRetrieve = aws.String(strconv.FormatUint(fromByteToDownload, 10) + "-" + strconv.FormatUint(fromByteToDownload + sizeToDownload - 1, 10))
+		rangeToRetrieve = aws.String(strconv.FormatUint(fromByte, 10) + "-" + strconv.FormatUint(fromByte + sizeToDownload - 1, 10))
+	} else if openRange {
+		rangeToRetrieve = aws.String(strconv.FormatUint(fromByte, 10) + "-")
 	}
 	params := &glacier.GetJobOutputInput{
 		AccountId: aws.String(AccountId),
@@ -142,33 +423,30 @@ func DownloadPartialArchiveTo(glacierClient glacieriface.GlacierAPI, vault, jobI
 	outputs.Printfln(outputs.Verbose, "Aws call: glacier.GetJobOutput(%v)", params)
 	resp, err := glacierClient.GetJobOutput(params)
 	outputs.Printfln(outputs.Verbose, "Aws response: %v (error %v)\n", resp, err)
-	utils.ExitIfError(err)
+	if err != nil {
+		return 0, err
+	}
 	defer resp.Body.Close()
-	var file *os.File;
-	file, err = os.OpenFile(destPath, os.O_CREATE | os.O_RDWR, 0600)
-	defer utils.CheckingClose(file, &err)
-	file.Seek(int64(fromByteToWrite), os.SEEK_SET)
-	utils.ExitIfError(err)
-	outputs.Printfln(outputs.Verbose, "Copy file into: %v", destPath)
-	written, err := io.Copy(file, resp.Body)
-	written64 := uint64(written)
-	outputs.Printfln(outputs.Verbose, "%v copied", bytefmt.ByteSize(written64))
-	utils.ExitIfError(err)
-	return written64
+	written, err := io.Copy(writer, &rateLimitedReader{resp.Body, DownloadRateLimiter})
+	return uint64(written), err
 }
 
 type JobStartStatus struct {
-	JobId         string
-	IsResumed     bool
-	IsSuccess     bool
-	Err           error
-	SizeRetrieved uint64
+	JobId          string
+	IsResumed      bool
+	IsSuccess      bool
+	Err            error
+	SizeRetrieved  uint64
+	OutputOffset   uint64    // index in the job output of the first byte to retrieve, not 0 if a job is reused from the middle
+	CompletionDate time.Time // completion date of a reused job, zero if it is in progress or if the job is new
 }
 
 func StartRetrieveArchiveJob(glacierClient glacieriface.GlacierAPI, vault string, archive Archive) JobStartStatus {
 	return StartRetrievePartialArchiveJob(glacierClient, vault, archive, 0, archive.Size)
 }
 
+// Bytes already retrieved by a job found at startup are not retrieved again, even if the job range is different: the
+// job is reused from fromByte, and a new job stops where the next existing job starts
 func StartRetrievePartialArchiveJob(glacierClient glacieriface.GlacierAPI, vault string, archive Archive, fromByte uint64, sizeToRetrieve uint64) JobStartStatus {
 	rangeToRetrieve := ""
 	if (fromByte) % utils.S_1MB != 0 {
@@ -181,50 +459,49 @@ func StartRetrievePartialArchiveJob(glacierClient glacieriface.GlacierAPI, vault
 	} else {
 		return JobStartStatus{IsSuccess: false, Err: errors.New("Size to retrieve must be divisible by MB")}
 	}
-	rangeToRetrieve = strconv.FormatUint(fromByte, 10) + "-" + strconv.FormatUint(fromByte + sizeToRetrieve - 1, 10)
 
-	if existingJobsId := JobIdsAtStartup.GetJobIdForFileRetrieval(archive.ArchiveId, rangeToRetrieve); existingJobsId != "" {
-		return JobStartStatus{JobId: existingJobsId, IsResumed: true, IsSuccess: true, SizeRetrieved: sizeToRetrieve}
-	} else {
-		params := &glacier.InitiateJobInput{
-			AccountId: aws.String(AccountId),
-			VaultName: aws.String(vault),
-			JobParameters: &glacier.JobParameters{
-				ArchiveId: aws.String(archive.ArchiveId),
-				Type:        aws.String("archive-retrieval"),
-				RetrievalByteRange: aws.String(rangeToRetrieve),
-			},
-		}
-		outputs.Printfln(outputs.Verbose, "Aws call: glacier.InitiateJob(%v)", params)
-		resp, err := glacierClient.InitiateJob(params)
-		outputs.Printfln(outputs.Verbose, "Aws response: %v (error %v)\n", resp, err)
-		if err != nil {
-			return JobStartStatus{IsSuccess: false, Err: err}
+	if existingJob := JobIdsAtStartup.getJobForFileRetrieval(archive.ArchiveId, fromByte); existingJob != nil {
+		sizeRetrieved := existingJob.end + 1 - fromByte
+		if sizeRetrieved > sizeToRetrieve {
+			sizeRetrieved = sizeToRetrieve
 		}
-		return JobStartStatus{JobId: *resp.JobId, IsResumed: false, IsSuccess: true, SizeRetrieved: sizeToRetrieve}
+		return JobStartStatus{JobId: existingJob.jobId, IsResumed: true, IsSuccess: true, SizeRetrieved: sizeRetrieved,
+			OutputOffset: fromByte - existingJob.start, CompletionDate: existingJob.completionDate}
 	}
-}
-
-func GetDataRetrievalStrategy(glacierClient glacieriface.GlacierAPI) string {
-	params := &glacier.GetDataRetrievalPolicyInput{
-		AccountId:  &AccountId,
+	if nextRetrievedByte := JobIdsAtStartup.GetNextRetrievedByte(archive.ArchiveId, fromByte); nextRetrievedByte != 0 && fromByte + sizeToRetrieve > nextRetrievedByte {
+		sizeToRetrieve = nextRetrievedByte - fromByte
 	}
-	outputs.Printfln(outputs.Verbose, "Aws call: glacier.GetDataRetrievalPolicy(%v)", params)
-	resp, err := glacierClient.GetDataRetrievalPolicy(params)
+	rangeToRetrieve = strconv.FormatUint(fromByte, 10) + "-" + strconv.FormatUint(fromByte + sizeToRetrieve - 1, 10)
+	params := &glacier.InitiateJobInput{
+		AccountId: aws.String(AccountId),
+		VaultName: aws.String(vault),
+		JobParameters: &glacier.JobParameters{
+			ArchiveId: aws.String(archive.ArchiveId),
+			Type:        aws.String("archive-retrieval"),
+			RetrievalByteRange: aws.String(rangeToRetrieve),
+		},
+	}
+	outputs.Printfln(outputs.Verbose, "Aws call: glacier.InitiateJob(%v)", params)
+	resp, err := glacierClient.InitiateJob(params)
 	outputs.Printfln(outputs.Verbose, "Aws response: %v (error %v)\n", resp, err)
-	utils.ExitIfError(err)
-	return *resp.Policy.Rules[0].Strategy
+	if err != nil {
+		return JobStartStatus{IsSuccess: false, Err: err}
+	}
+	return JobStartStatus{JobId: *resp.JobId, IsResumed: false, IsSuccess: true, SizeRetrieved: sizeToRetrieve}
 }
 
-func InventoryTowElementsOfVault(glacierClient glacieriface.GlacierAPI, vault string) string {
+// Inventory of whole vault if marker is nil, else of next archives after marker
+func StartInventoryJob(glacierClient glacieriface.GlacierAPI, vault string, marker *string) string {
 	params := &glacier.InitiateJobInput{
 		AccountId: aws.String(AccountId),
 		VaultName: aws.String(vault),
 		JobParameters: &glacier.JobParameters{
 			Type:        aws.String("inventory-retrieval"),
-			InventoryRetrievalParameters: &glacier.InventoryRetrievalJobInput{Limit: aws.String("2")},
 		},
 	}
+	if marker != nil {
+		params.JobParameters.InventoryRetrievalParameters = &glacier.InventoryRetrievalJobInput{Marker: marker}
+	}
 	outputs.Printfln(outputs.Verbose, "Aws call: glacier.InitiateJob(%v)", params)
 	resp, err := glacierClient.InitiateJob(params)
 	outputs.Printfln(outputs.Verbose, "Aws response: %v (error %v)\n", resp, err)
@@ -232,11 +509,31 @@ func InventoryTowElementsOfVault(glacierClient glacieriface.GlacierAPI, vault st
 	return *(resp.JobId)
 }
 
+type InventoryArchive struct {
+	ArchiveId          string
+	ArchiveDescription string
+	CreationDate       string // ISO 8601, ordered as string
+	Size               uint64
+	SHA256TreeHash     string
+}
+
+func (inventoryArchive InventoryArchive) Archive() *Archive {
+	return &Archive{ArchiveId: inventoryArchive.ArchiveId, Size: inventoryArchive.Size}
+}
+
 type VaultInventory struct {
-	ArchiveList []Archive
+	InventoryDate string
+	ArchiveList   []InventoryArchive
 }
 
-func GetArchiveIdFromInventory(glacierClient glacieriface.GlacierAPI, vault, jobId string) *Archive {
+// Archives of a completed inventory job and marker of the next page, nil if it is the last page
+func GetInventoryFromJob(glacierClient glacieriface.GlacierAPI, vault, jobId string) (*VaultInventory, *string) {
+	jobDescription, err := DescribeJob(glacierClient, vault, jobId)
+	utils.ExitIfError(err)
+	var marker *string
+	if jobDescription.InventoryRetrievalParameters != nil && aws.StringValue(jobDescription.InventoryRetrievalParameters.Marker) != "" {
+		marker = jobDescription.InventoryRetrievalParameters.Marker
+	}
 	params := &glacier.GetJobOutputInput{
 		AccountId: aws.String(AccountId),
 		JobId:     aws.String(jobId),
@@ -252,10 +549,7 @@ func GetArchiveIdFromInventory(glacierClient glacieriface.GlacierAPI, vault, job
 	vaultInventory := VaultInventory{}
 	err = json.Unmarshal(jsonContent, &vaultInventory)
 	utils.ExitIfError(err)
-	if (len(vaultInventory.ArchiveList) != 1) {
-		utils.ExitIfError(errors.New("Mapping vault shoud be have only one archive"))
-	}
-	return &vaultInventory.ArchiveList[0]
+	return &vaultInventory, marker
 }
 
 func DoOnJobPages(glacierClient glacieriface.GlacierAPI, vault string, fn func(*glacier.ListJobsOutput, bool) bool) {
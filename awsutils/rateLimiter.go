@@ -0,0 +1,95 @@
+package awsutils
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Token bucket limiting bytes per second, shared by all download streams. Bytes over the allowance are borrowed and the
+// reader waits until they are paid back, a burst lasts at most one second.
+// The rate is fixed or follows a schedule, the schedule is checked at each wait so that a new rate applies at once.
+
+type RateLimiter struct {
+	mutex  sync.Mutex
+	rate   uint64 // bytes per second, unlimited if 0
+	rateAt func(time.Time) uint64 // schedule of the rate, nil if the rate is fixed
+	tokens float64
+	last   time.Time
+}
+
+var DownloadRateLimiter = &RateLimiter{}
+
+func (limiter *RateLimiter) SetRate(rate uint64) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.rateAt = nil
+	limiter.setRate(rate, time.Now())
+}
+
+func (limiter *RateLimiter) SetRateSchedule(rateAt func(time.Time) uint64) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.rateAt = rateAt
+	limiter.setRate(rateAt(time.Now()), time.Now())
+}
+
+func (limiter *RateLimiter) Rate() uint64 {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.applyRateSchedule(time.Now())
+	return limiter.rate
+}
+
+func (limiter *RateLimiter) setRate(rate uint64, now time.Time) {
+	limiter.rate = rate
+	limiter.tokens = 0
+	limiter.last = now
+}
+
+func (limiter *RateLimiter) applyRateSchedule(now time.Time) {
+	if limiter.rateAt == nil {
+		return
+	}
+	if rate := limiter.rateAt(now); rate != limiter.rate {
+		limiter.setRate(rate, now)
+	}
+}
+
+// Waits until n bytes are allowed
+func (limiter *RateLimiter) Wait(n int) {
+	limiter.mutex.Lock()
+	now := time.Now()
+	limiter.applyRateSchedule(now)
+	if limiter.rate == 0 {
+		limiter.mutex.Unlock()
+		return
+	}
+	limiter.tokens += now.Sub(limiter.last).Seconds() * float64(limiter.rate)
+	if limiter.tokens > float64(limiter.rate) {
+		limiter.tokens = float64(limiter.rate)
+	}
+	limiter.last = now
+	limiter.tokens -= float64(n)
+	wait := time.Duration(0)
+	if limiter.tokens < 0 {
+		wait = time.Duration(-limiter.tokens / float64(limiter.rate) * float64(time.Second))
+	}
+	limiter.mutex.Unlock()
+	time.Sleep(wait)
+}
+
+// Reads at most 32KB at once, so that streams share the rate evenly
+type rateLimitedReader struct {
+	reader  io.Reader
+	limiter *RateLimiter
+}
+
+func (reader *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > 32 * 1024 {
+		p = p[:32 * 1024]
+	}
+	n, err := reader.reader.Read(p)
+	reader.limiter.Wait(n)
+	return n, err
+}
@@ -0,0 +1,64 @@
+package awsutils
+
+import (
+	"strings"
+	"time"
+	"rsg/outputs"
+	"rsg/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/glacier"
+	"github.com/aws/aws-sdk-go/service/glacier/glacieriface"
+)
+
+// Data retrieval policy of the account in the region:
+// - FreeTier: retrievals are limited to the free tier, jobs beyond the free allowance of the day are rejected
+// - BytesPerHour: retrievals are limited to a peak rate, they are charged beyond the free tier
+// - None: retrievals are not limited, they are charged beyond the free tier
+// Aws spreads the bytes of a job over 4 hours to compute the retrieval rate, so the bytes of the jobs started during
+// the last 4 hours cannot exceed 4 times the rate of the policy.
+
+const FreeTierStrategy = "FreeTier"
+const BytesPerHourStrategy = "BytesPerHour"
+const NoneStrategy = "None"
+
+const RetrievalRateWindow = 4 * time.Hour
+
+type DataRetrievalPolicy struct {
+	Strategy     string
+	BytesPerHour uint64 // maximum retrieval rate of BytesPerHour strategy
+}
+
+func GetDataRetrievalPolicy(glacierClient glacieriface.GlacierAPI) DataRetrievalPolicy {
+	params := &glacier.GetDataRetrievalPolicyInput{
+		AccountId:  &AccountId,
+	}
+	outputs.Printfln(outputs.Verbose, "Aws call: glacier.GetDataRetrievalPolicy(%v)", params)
+	resp, err := glacierClient.GetDataRetrievalPolicy(params)
+	outputs.Printfln(outputs.Verbose, "Aws response: %v (error %v)\n", resp, err)
+	utils.ExitIfError(err)
+	if resp.Policy == nil || len(resp.Policy.Rules) == 0 {
+		return DataRetrievalPolicy{Strategy: NoneStrategy}
+	}
+	return DataRetrievalPolicy{Strategy: aws.StringValue(resp.Policy.Rules[0].Strategy),
+		BytesPerHour: uint64(aws.Int64Value(resp.Policy.Rules[0].BytesPerHour))}
+}
+
+func SetDataRetrievalPolicy(glacierClient glacieriface.GlacierAPI, policy DataRetrievalPolicy) error {
+	rule := &glacier.DataRetrievalRule{Strategy: aws.String(policy.Strategy)}
+	if policy.Strategy == BytesPerHourStrategy {
+		rule.BytesPerHour = aws.Int64(int64(policy.BytesPerHour))
+	}
+	params := &glacier.SetDataRetrievalPolicyInput{
+		AccountId: aws.String(AccountId),
+		Policy: &glacier.DataRetrievalPolicy{Rules: []*glacier.DataRetrievalRule{rule}},
+	}
+	outputs.Printfln(outputs.Verbose, "Aws call: glacier.SetDataRetrievalPolicy(%v)", params)
+	resp, err := glacierClient.SetDataRetrievalPolicy(params)
+	outputs.Printfln(outputs.Verbose, "Aws response: %v (error %v)\n", resp, err)
+	return err
+}
+
+// Job rejected because it exceeds the data retrieval policy
+func IsPolicyEnforcedError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "PolicyEnforcedException")
+}
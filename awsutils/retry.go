@@ -0,0 +1,76 @@
+package awsutils
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Transient errors of aws (throttling, server errors) and of the network are retried after an exponential delay with
+// jitter. Retries are limited by call (or by range of a job output) and by run.
+
+var MaxRetriesByCall = 5
+var MaxRetriesByRun = 50
+var RetryBaseDelay = 2 * time.Second
+var RetryMaxDelay = 2 * time.Minute
+
+var retriesInRun = 0
+var retriesMutex sync.Mutex
+
+var transientErrorCodes = []string{"RequestError", "RequestTimeout", "RequestTimeoutException", "ThrottlingException",
+	"Throttling", "SlowDown", "ServiceUnavailableException", "ServiceUnavailable", "InternalError", "InternalFailure"}
+
+// Error worth retrying: throttling or server error of aws, interrupted or timed out connection
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if requestFailure, ok := err.(awserr.RequestFailure); ok && requestFailure.StatusCode() >= 500 {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		for _, code := range transientErrorCodes {
+			if awsErr.Code() == code {
+				return true
+			}
+		}
+		return false
+	}
+	if netErr, ok := err.(net.Error); ok {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+	message := err.Error()
+	return err == io.ErrUnexpectedEOF || strings.Contains(message, "connection reset") || strings.Contains(message, "broken pipe")
+}
+
+// Records a retry and returns the delay before it, false if the budget of the call or of the run is spent
+func nextRetry(attempt int) (time.Duration, bool) {
+	retriesMutex.Lock()
+	defer retriesMutex.Unlock()
+	if attempt > MaxRetriesByCall || retriesInRun >= MaxRetriesByRun {
+		return 0, false
+	}
+	retriesInRun++
+	return retryDelay(attempt), true
+}
+
+// Exponential delay of the attempt (from 1), randomized between half and the whole delay
+func retryDelay(attempt int) time.Duration {
+	delay := RetryBaseDelay
+	for i := 1; i < attempt && delay < RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > RetryMaxDelay {
+		delay = RetryMaxDelay
+	}
+	return delay / 2 + time.Duration(rand.Int63n(int64(delay / 2) + 1))
+}
+
+// for test
+func ResetRetriesInRun() {
+	retriesInRun = 0
+}
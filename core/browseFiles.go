@@ -0,0 +1,244 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"rsg/inputs"
+	"rsg/outputs"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Browse shares and directories of the mapping file in the terminal like a file manager and mark files or directories
+// to restore. A directory is queried from the mapping store when it's opened, the mapping is not loaded in memory.
+// Directory sizes are the sum of their file sizes, the selection size is the size of its distinct archives (the
+// bytes really retrieved).
+
+// Standard retrieval and data transfer out prices by GB, used for estimates only
+var RetrievalCostByGB = 0.01
+var TransferCostByGB = 0.09
+
+const browsePageSize = 20
+const clearScreen = "\033[H\033[2J"
+const browseHelp = "up/down: move, space: mark/unmark, enter/right: open, left/backspace: parent, r: restore, q: quit"
+
+type browser struct {
+	mappingStore       MappingStore
+	restorationContext *RestorationContext
+	path               string           // current directory (share name + '/' + base path), empty for root
+	entries            []DirectoryEntry // files and directories of the current directory
+	cursor             int
+	offset             int              // first entry displayed
+	marked             map[string]bool  // paths of marked files and directories
+	message            string           // displayed once under the entries
+}
+
+func newBrowser(mappingStore MappingStore, restorationContext *RestorationContext) *browser {
+	browser := &browser{mappingStore: mappingStore, restorationContext: restorationContext, marked: make(map[string]bool)}
+	browser.open("", "")
+	return browser
+}
+
+// Returns the paths of marked files and directories if the restoration is confirmed, nil if the user quits
+func BrowseFiles(restorationContext *RestorationContext) []string {
+	mappingStore := InitMappingStore(restorationContext.GetMappingFilePath())
+	defer mappingStore.Close()
+	browser := newBrowser(mappingStore, restorationContext)
+
+	restoreTerminal := inputs.StartRawMode()
+	defer restoreTerminal()
+	for {
+		browser.display()
+		key, character := inputs.ReadKey()
+		switch key {
+		case inputs.KeyUp:
+			browser.move(-1)
+		case inputs.KeyDown:
+			browser.move(1)
+		case inputs.KeyEnter, inputs.KeyRight:
+			browser.openCursor()
+		case inputs.KeyLeft, inputs.KeyBackspace:
+			browser.openParent()
+		case inputs.KeyRune:
+			switch character {
+			case ' ':
+				browser.toggleMark()
+			case 'r':
+				if browser.confirmRestoration() {
+					return browser.selection()
+				}
+			case 'q':
+				return nil
+			}
+		}
+	}
+}
+
+func (browser *browser) open(path, cursorName string) {
+	entries, err := browser.mappingStore.Directory(path)
+	utils.ExitIfError(err)
+	browser.path, browser.entries, browser.cursor, browser.offset = path, entries, 0, 0
+	for i, entry := range entries {
+		if entry.Name == cursorName {
+			browser.move(i)
+		}
+	}
+}
+
+func (browser *browser) openCursor() {
+	if len(browser.entries) > 0 && browser.entries[browser.cursor].Dir {
+		browser.open(browser.entryPath(browser.entries[browser.cursor]), "")
+	}
+}
+
+// The cursor is on the directory left
+func (browser *browser) openParent() {
+	if browser.path == "" {
+		return
+	}
+	parent, name := "", browser.path
+	if index := strings.LastIndex(browser.path, "/"); index >= 0 {
+		parent, name = browser.path[:index], browser.path[index + 1:]
+	}
+	browser.open(parent, name)
+}
+
+func (browser *browser) move(delta int) {
+	browser.cursor += delta
+	if browser.cursor >= len(browser.entries) {
+		browser.cursor = len(browser.entries) - 1
+	}
+	if browser.cursor < 0 {
+		browser.cursor = 0
+	}
+	if browser.cursor < browser.offset {
+		browser.offset = browser.cursor
+	}
+	if browser.cursor >= browser.offset + browsePageSize {
+		browser.offset = browser.cursor - browsePageSize + 1
+	}
+}
+
+func (browser *browser) entryPath(entry DirectoryEntry) string {
+	if browser.path == "" {
+		return entry.Name
+	}
+	return browser.path + "/" + entry.Name
+}
+
+func (browser *browser) hasMarkedAncestor(path string) bool {
+	for markedPath := range browser.marked {
+		if strings.HasPrefix(path, markedPath + "/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (browser *browser) markedDescendants(path string) []string {
+	descendants := []string{}
+	for markedPath := range browser.marked {
+		if strings.HasPrefix(markedPath, path + "/") {
+			descendants = append(descendants, markedPath)
+		}
+	}
+	return descendants
+}
+
+// Marking a directory unmarks its files and directories, they are restored with it
+func (browser *browser) toggleMark() {
+	if len(browser.entries) == 0 {
+		return
+	}
+	entry := browser.entries[browser.cursor]
+	path := browser.entryPath(entry)
+	switch {
+	case browser.hasMarkedAncestor(path):
+		browser.message = fmt.Sprintf("A parent directory of %s is marked, unmark it first", entry.Name)
+	case browser.marked[path]:
+		delete(browser.marked, path)
+	default:
+		for _, descendant := range browser.markedDescendants(path) {
+			delete(browser.marked, descendant)
+		}
+		browser.marked[path] = true
+	}
+}
+
+// Marked paths in path order
+func (browser *browser) selection() []string {
+	selection := []string{}
+	for path := range browser.marked {
+		selection = append(selection, path)
+	}
+	sort.Strings(selection)
+	return selection
+}
+
+// Files restored from the marked files (same filter and version selection as the restoration), size of their distinct
+// archives and its cost
+func (browser *browser) estimate() string {
+	nbFiles, size := 0, uint64(0)
+	if selection := browser.selection(); len(selection) > 0 {
+		filter := newRestorationFilter(browser.restorationContext)
+		filter.Paths = selection
+		fileIterator, err := browser.mappingStore.Files(filter)
+		utils.ExitIfError(err)
+		for fileIterator.Next() {
+			nbFiles++
+		}
+		utils.ExitIfError(fileIterator.Err())
+		fileIterator.Close()
+		size, err = browser.mappingStore.TotalSize(filter)
+		utils.ExitIfError(err)
+	}
+	cost := float64(size) / float64(utils.S_1GB) * (RetrievalCostByGB + TransferCostByGB)
+	return fmt.Sprintf("%d file(s) marked, %s, ~$%.2f", nbFiles, bytefmt.ByteSize(size), cost)
+}
+
+func (browser *browser) display() {
+	outputs.Print(outputs.Info, clearScreen)
+	outputs.Printfln(outputs.Info, "/%s - %s", browser.path, browser.estimate())
+	for i := browser.offset; i < len(browser.entries) && i < browser.offset + browsePageSize; i++ {
+		entry := browser.entries[i]
+		cursor, mark, name := " ", " ", entry.Name
+		if i == browser.cursor {
+			cursor = ">"
+		}
+		path := browser.entryPath(entry)
+		if browser.marked[path] || browser.hasMarkedAncestor(path) {
+			mark = "x"
+		} else if len(browser.markedDescendants(path)) > 0 {
+			mark = "-"
+		}
+		if entry.Dir {
+			name += "/"
+		}
+		outputs.Printfln(outputs.Info, "%s [%s] %s\t%s", cursor, mark, name, bytefmt.ByteSize(entry.Size))
+	}
+	if len(browser.entries) > browsePageSize {
+		outputs.Printfln(outputs.Info, "(%d/%d)", browser.cursor + 1, len(browser.entries))
+	}
+	outputs.Println(outputs.Info, browser.message)
+	outputs.Println(outputs.Info, browseHelp)
+	browser.message = ""
+}
+
+func (browser *browser) confirmRestoration() bool {
+	if len(browser.marked) == 0 {
+		browser.message = "Nothing is marked"
+		return false
+	}
+	outputs.Printf(outputs.Info, "Restore %s ?[Y/n] ", browser.estimate())
+	for {
+		key, character := inputs.ReadKey()
+		switch {
+		case key == inputs.KeyEnter, key == inputs.KeyRune && (character == 'y' || character == 'Y'):
+			outputs.Println(outputs.Info)
+			return true
+		case key == inputs.KeyRune && (character == 'n' || character == 'N'):
+			return false
+		}
+	}
+}
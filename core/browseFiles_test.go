@@ -0,0 +1,151 @@
+package core
+
+import (
+	"testing"
+	"bufio"
+	"bytes"
+	"database/sql"
+	"strings"
+	"rsg/inputs"
+	"rsg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Keys typed in the terminal
+const (
+	keyUp = "\033[A"
+	keyDown = "\033[B"
+	keyRight = "\033[C"
+	keyLeft = "\033[D"
+	keyEnter = "\n"
+	keyBackspace = "\177"
+)
+
+func initBrowseFilesTest(t *testing.T, keys ...string) *RestorationContext {
+	restorationContext := DefaultRestorationContext(nil)
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", restorationContext.GetMappingFilePath())
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/copy/file1.txt', 'archiveId1', 5);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('photos', '2016/beach.jpg', 'archiveId3', 1073741824);")
+	db.Close()
+	inputs.StdinReader = bufio.NewReader(bytes.NewReader([]byte(strings.Join(keys, ""))))
+	return restorationContext
+}
+
+func TestBrowseFiles_open_directories_with_aggregated_sizes(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initBrowseFilesTest(t, keyDown, keyEnter, keyRight, keyBackspace, keyLeft, keyLeft, "q")
+
+	// When
+	selection := BrowseFiles(restorationContext)
+	outputs := string(buffer.Bytes())
+
+	// Then
+	assert.Nil(t, selection)
+	assert.Contains(t, outputs, clearScreen + "/ - 0 file(s) marked, 0B, ~$0.00\n> [ ] photos/\t1G\n  [ ] share/\t22B\n\n" + browseHelp + "\n")
+	assert.Contains(t, outputs, clearScreen + "/share - 0 file(s) marked, 0B, ~$0.00\n> [ ] data/\t22B\n\n")
+	assert.Contains(t, outputs, clearScreen + "/share/data - 0 file(s) marked, 0B, ~$0.00\n> [ ] copy/\t5B\n  [ ] file1.txt\t5B\n  [ ] file2.txt\t12B\n\n")
+	// back to parent directories, the cursor is on the directory left
+	assert.True(t, strings.HasSuffix(outputs, clearScreen + "/ - 0 file(s) marked, 0B, ~$0.00\n  [ ] photos/\t1G\n> [ ] share/\t22B\n\n" + browseHelp + "\n"))
+}
+
+func TestBrowseFiles_mark_and_restore(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initBrowseFilesTest(t, keyDown, keyEnter, keyEnter,
+		" ", keyDown, " ", keyDown, " ", keyUp, " ", keyEnter, keyEnter,
+		keyLeft, keyLeft, keyLeft, keyUp, " ", "r", "y")
+
+	// When
+	selection := BrowseFiles(restorationContext)
+	outputs := string(buffer.Bytes())
+
+	// Then
+	assert.Equal(t, []string{"photos", "share/data/copy", "share/data/file2.txt"}, selection)
+	// file1.txt and copy/file1.txt are in the same archive
+	assert.Contains(t, outputs, "/share/data - 3 file(s) marked, 17B, ~$0.00\n  [x] copy/\t5B\n  [x] file1.txt\t5B\n> [x] file2.txt\t12B\n")
+	assert.Contains(t, outputs, "/share/data - 2 file(s) marked, 17B, ~$0.00\n  [x] copy/\t5B\n> [ ] file1.txt\t5B\n  [x] file2.txt\t12B\n")
+	assert.Contains(t, outputs, "/ - 3 file(s) marked, 1G, ~$0.10\n> [x] photos/\t1G\n  [-] share/\t22B\n")
+	assert.True(t, strings.HasSuffix(outputs, "Restore 3 file(s) marked, 1G, ~$0.10 ?[Y/n] \n"))
+}
+
+func TestBrowseFiles_mark_directory_unmarks_its_files(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initBrowseFilesTest(t, keyDown, keyEnter, keyEnter, keyDown, " ", keyLeft, " ", keyEnter, keyDown, " ",
+		keyLeft, keyLeft, "r", "n", "q")
+
+	// When
+	selection := BrowseFiles(restorationContext)
+	outputs := string(buffer.Bytes())
+
+	// Then
+	assert.Nil(t, selection)
+	assert.Contains(t, outputs, "/share - 1 file(s) marked, 5B, ~$0.00\n> [-] data/\t22B\n")
+	assert.Contains(t, outputs, "/share - 3 file(s) marked, 17B, ~$0.00\n> [x] data/\t22B\n")
+	assert.Contains(t, outputs, "/share/data - 3 file(s) marked, 17B, ~$0.00\n  [x] copy/\t5B\n> [x] file1.txt\t5B\n  [x] file2.txt\t12B\n" +
+		"A parent directory of file1.txt is marked, unmark it first\n")
+	assert.Contains(t, outputs, "Restore 3 file(s) marked, 17B, ~$0.00 ?[Y/n] " + clearScreen)
+}
+
+func TestBrowseFiles_restore_nothing_marked(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initBrowseFilesTest(t, "r", "q")
+
+	// When
+	selection := BrowseFiles(restorationContext)
+
+	// Then
+	assert.Nil(t, selection)
+	assert.Contains(t, string(buffer.Bytes()), "Nothing is marked\n" + browseHelp + "\n")
+}
+
+func TestBrowseFiles_estimate_selected_version(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initBrowseFilesTest(t, keyDown, keyEnter, keyEnter, keyDown, " ", "q")
+	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId5', 7);")
+	db.Close()
+
+	// When
+	BrowseFiles(restorationContext)
+
+	// Then
+	assert.Contains(t, string(buffer.Bytes()), "/share/data - 1 file(s) marked, 7B, ~$0.00\n  [ ] copy/\t5B\n> [x] file1.txt\t12B\n")
+}
+
+func TestDownloadArchives_with_selection(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	restorationContext.Options.Selection = []string{"share/data/file2.txt"}
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: utils.S_1MB,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 1,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: newMemoryMappingStore(
+			&MappingEntry{ShareName: "share", BasePath: "data/file1.txt", ArchiveId: "archiveId1", FileSize: 5},
+			&MappingEntry{ShareName: "share", BasePath: "data/file2.txt", ArchiveId: "archiveId2", FileSize: 5},
+			&MappingEntry{ShareName: "other", BasePath: "data/file2.txt", ArchiveId: "archiveId3", FileSize: 5}),
+		archiveIterator: nil,
+	}
+
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId2", "0-4", "jobId2")
+	mockDescribeJob(glacierMock, "jobId2", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId2", restorationContext.Vault, "0-4", []byte("hello"))
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	assertFileContent(t, "../../testtmp/dest/share/data/file2.txt", "hello")
+	assert.False(t, utils.Exists("../../testtmp/dest/share/data/file1.txt"))
+	assert.False(t, utils.Exists("../../testtmp/dest/other/data/file2.txt"))
+}
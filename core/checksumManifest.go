@@ -0,0 +1,204 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"rsg/outputs"
+	"rsg/utils"
+)
+
+// Compute sha256 of restored files while archives are written and write manifests of restored files:
+// one compatible with sha256sum and one in json.
+// Manifests are written into the destination directory and merged with the ones of previous restorations. An entry is
+// appended to both manifests as soon as its file is restored, manifests are sorted at the end of the restoration.
+
+const checksumManifestFileName = "rsg-manifest.sha256"
+const checksumManifestJsonFileName = "rsg-manifest.json"
+
+type ChecksumManifestEntry struct {
+	Share     string `json:"share"`
+	Path      string `json:"path"`
+	Size      uint64 `json:"size"`
+	ArchiveId string `json:"archiveId"`
+	Sha256    string `json:"sha256"`
+}
+
+type checksumManifest struct {
+	destinationDirPath string
+	entryByPath        map[string]*ChecksumManifestEntry
+	hashByArchiveId    map[string]*archiveHash
+	written            bool // manifest files contain the entries loaded, entries can be appended
+}
+
+// sha256 of an archive computed while it is written, size is the number of bytes already hashed
+type archiveHash struct {
+	hash hash.Hash
+	size uint64
+}
+
+func (archiveHash *archiveHash) Write(p []byte) (int, error) {
+	n, err := archiveHash.hash.Write(p)
+	archiveHash.size += uint64(n)
+	return n, err
+}
+
+func newChecksumManifest(destinationDirPath string) *checksumManifest {
+	checksumManifest := &checksumManifest{destinationDirPath: destinationDirPath,
+		entryByPath: make(map[string]*ChecksumManifestEntry),
+		hashByArchiveId: make(map[string]*archiveHash)}
+	if jsonContent, err := ioutil.ReadFile(checksumManifest.jsonFilePath()); err == nil {
+		entries := []*ChecksumManifestEntry{}
+		err = json.Unmarshal(jsonContent, &entries)
+		utils.ExitIfError(err)
+		for _, entry := range entries {
+			checksumManifest.entryByPath[entry.Share + "/" + entry.Path] = entry
+		}
+	}
+	return checksumManifest
+}
+
+func (checksumManifest *checksumManifest) filePath() string {
+	return checksumManifest.destinationDirPath + "/" + checksumManifestFileName
+}
+
+func (checksumManifest *checksumManifest) jsonFilePath() string {
+	return checksumManifest.destinationDirPath + "/" + checksumManifestJsonFileName
+}
+
+// Returns the writer to hash bytes of the archive written in order from fromByteToWrite index.
+// If the bytes before this index have not been hashed (download resumed from a previous run), they are read from
+// the local archive file. Bytes already hashed (part retrieved again from a MB boundary) are not hashed again.
+func (checksumManifest *checksumManifest) hashWriter(archiveId string, fromByteToWrite uint64) io.Writer {
+	archiveHashValue, ok := checksumManifest.hashByArchiveId[archiveId]
+	if !ok {
+		archiveHashValue = &archiveHash{hash: sha256.New()}
+		checksumManifest.hashByArchiveId[archiveId] = archiveHashValue
+	}
+	if archiveHashValue.size < fromByteToWrite {
+		checksumManifest.hashLocalBytes(archiveId, archiveHashValue, fromByteToWrite)
+	}
+	return &skippingWriter{archiveHashValue, archiveHashValue.size - fromByteToWrite}
+}
+
+// Hashes the bytes of the local archive file from the bytes already hashed to the index end
+func (checksumManifest *checksumManifest) hashLocalBytes(archiveId string, archiveHashValue *archiveHash, end uint64) {
+	outputs.Printfln(outputs.Verbose, "Hash local bytes of archive %v", archiveId)
+	file, err := os.Open(checksumManifest.destinationDirPath + "/" + archiveId)
+	utils.ExitIfError(err)
+	defer file.Close()
+	_, err = io.CopyN(archiveHashValue, io.NewSectionReader(file, int64(archiveHashValue.size), int64(end - archiveHashValue.size)), int64(end - archiveHashValue.size))
+	utils.ExitIfError(err)
+}
+
+// Discards the first skip bytes written
+type skippingWriter struct {
+	writer io.Writer
+	skip   uint64
+}
+
+func (skippingWriter *skippingWriter) Write(p []byte) (int, error) {
+	if skippingWriter.skip >= uint64(len(p)) {
+		skippingWriter.skip -= uint64(len(p))
+		return len(p), nil
+	}
+	n, err := skippingWriter.writer.Write(p[skippingWriter.skip:])
+	n += int(skippingWriter.skip)
+	skippingWriter.skip = 0
+	return n, err
+}
+
+// Returns the sha256 of the local archive file, computed while downloading. Bytes not hashed while downloading (after a
+// segment that failed to download) are read from the file.
+func (checksumManifest *checksumManifest) archiveSha256(archiveId string, size uint64) string {
+	archiveHashValue, ok := checksumManifest.hashByArchiveId[archiveId]
+	delete(checksumManifest.hashByArchiveId, archiveId)
+	if !ok || archiveHashValue.size > size {
+		archiveHashValue = &archiveHash{hash: sha256.New()}
+	}
+	if archiveHashValue.size < size {
+		checksumManifest.hashLocalBytes(archiveId, archiveHashValue, size)
+	}
+	return hex.EncodeToString(archiveHashValue.hash.Sum(nil))
+}
+
+func emptyFileSha256() string {
+	sum := sha256.Sum256(nil)
+	return hex.EncodeToString(sum[:])
+}
+
+// path is the path of the restored file in the destination directory (share name + '/' + base path). The entry is
+// appended to the manifest files.
+func (checksumManifest *checksumManifest) addFile(path string, size uint64, archiveId, sha256Sum string) {
+	share, basePath := path, ""
+	if index := strings.Index(path, "/"); index >= 0 {
+		share, basePath = path[:index], path[index + 1:]
+	}
+	entry := &ChecksumManifestEntry{Share: share,
+		Path: basePath,
+		Size: size,
+		ArchiveId: archiveId,
+		Sha256: sha256Sum}
+	if !checksumManifest.written {
+		checksumManifest.write()
+	}
+	checksumManifest.entryByPath[path] = entry
+	checksumManifest.appendEntry(path, entry)
+}
+
+func (checksumManifest *checksumManifest) appendEntry(path string, entry *ChecksumManifestEntry) {
+	file, err := os.OpenFile(checksumManifest.filePath(), os.O_WRONLY | os.O_APPEND, 0600)
+	utils.ExitIfError(err)
+	_, err = fmt.Fprintf(file, "%s  %s\n", entry.Sha256, path)
+	utils.ExitIfError(err)
+	err = file.Close()
+	utils.ExitIfError(err)
+
+	// the json array written by write ends with "]", or with "\n]" if it is not empty
+	jsonEntry, err := json.MarshalIndent(entry, "  ", "  ")
+	utils.ExitIfError(err)
+	file, err = os.OpenFile(checksumManifest.jsonFilePath(), os.O_RDWR, 0600)
+	utils.ExitIfError(err)
+	stat, err := file.Stat()
+	utils.ExitIfError(err)
+	if stat.Size() <= 2 {
+		_, err = file.WriteAt([]byte("[\n  " + string(jsonEntry) + "\n]"), 0)
+	} else {
+		_, err = file.WriteAt([]byte(",\n  " + string(jsonEntry) + "\n]"), stat.Size() - 2)
+	}
+	utils.ExitIfError(err)
+	err = file.Close()
+	utils.ExitIfError(err)
+}
+
+// Writes all entries sorted by path
+func (checksumManifest *checksumManifest) write() {
+	paths := make([]string, 0, len(checksumManifest.entryByPath))
+	for path := range checksumManifest.entryByPath {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	entries := make([]*ChecksumManifestEntry, 0, len(paths))
+	sha256sumContent := new(bytes.Buffer)
+	for _, path := range paths {
+		entry := checksumManifest.entryByPath[path]
+		entries = append(entries, entry)
+		fmt.Fprintf(sha256sumContent, "%s  %s\n", entry.Sha256, path)
+	}
+	jsonContent, err := json.MarshalIndent(entries, "", "  ")
+	utils.ExitIfError(err)
+	err = ioutil.WriteFile(checksumManifest.jsonFilePath(), jsonContent, 0600)
+	utils.ExitIfError(err)
+	err = ioutil.WriteFile(checksumManifest.filePath(), sha256sumContent.Bytes(), 0600)
+	utils.ExitIfError(err)
+	checksumManifest.written = true
+}
@@ -23,9 +23,17 @@ func CommonInitTest() *bytes.Buffer {
 	os.MkdirAll("../../testtmp/cache", 0700)
 	outputs.InitOutputs(os.Stdout, buffer, buffer, buffer, os.Stderr)
 	awsutils.WaitTime = 1 * time.Nanosecond
+	awsutils.RetryBaseDelay = 1 * time.Nanosecond
+	awsutils.ResetRetriesInRun()
+	awsutils.DownloadRateLimiter.SetRate(0)
+	retrievalLedgerFilePath = "../../testtmp/retrieval-ledger.jsonl"
+	throughputHistoryFilePath = "../../testtmp/throughput-history.jsonl"
 	awsutils.AccountId = "accountId"
 	awsutils.JobIdsAtStartup.MappingInventoryJobId = ""
-	awsutils.JobIdsAtStartup.MappingRetrievalJobId = ""
+	awsutils.JobIdsAtStartup.MappingInventoryJobCreationDate = ""
+	awsutils.JobIdsAtStartup.MappingRetrievalJobIdByArchiveId = make(map[string]string)
+	awsutils.JobIdsAtStartup.InventoryJobId = ""
+	awsutils.ClearRetrievalJobsAtStartup()
 	return buffer
 }
 
@@ -83,13 +91,16 @@ func (m *GlacierMock) DescribeJob(input *glacier.DescribeJobInput) (*glacier.Job
 
 func (m *GlacierMock) GetJobOutput(input *glacier.GetJobOutputInput) (*glacier.GetJobOutputOutput, error) {
 	args := m.Called(input)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
 	getJobOutputOutput := args.Get(0).(*glacier.GetJobOutputOutput)
-	content, _ := ioutil.ReadAll(getJobOutputOutput.Body)
-	getJobOutputOutput.Body = newReaderClosable(bytes.NewReader(content))
+	content, readErr := ioutil.ReadAll(getJobOutputOutput.Body)
+	getJobOutputOutput.Body = newReaderClosable(newInterruptedReader(content, readErr))
 	getJobOutputOutputCopy := &glacier.GetJobOutputOutput{
 		AcceptRanges: getJobOutputOutput.AcceptRanges,
 		ArchiveDescription: getJobOutputOutput.ArchiveDescription,
-		Body: newReaderClosable(bytes.NewReader(content)),
+		Body: newReaderClosable(newInterruptedReader(content, readErr)),
 		Checksum: getJobOutputOutput.Checksum,
 		ContentRange: getJobOutputOutput.ContentRange,
 		ContentType: getJobOutputOutput.ContentType,
@@ -97,6 +108,22 @@ func (m *GlacierMock) GetJobOutput(input *glacier.GetJobOutputInput) (*glacier.G
 	return getJobOutputOutputCopy, args.Error(1)
 }
 
+func (m *GlacierMock) DescribeVault(input *glacier.DescribeVaultInput) (*glacier.DescribeVaultOutput, error) {
+	args := m.Called(input)
+	if args.Get(0) != nil {
+		return args.Get(0).(*glacier.DescribeVaultOutput), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *GlacierMock) DeleteArchive(input *glacier.DeleteArchiveInput) (*glacier.DeleteArchiveOutput, error) {
+	args := m.Called(input)
+	if args.Get(0) != nil {
+		return args.Get(0).(*glacier.DeleteArchiveOutput), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 func (m *GlacierMock) GetDataRetrievalPolicy(input *glacier.GetDataRetrievalPolicyInput) (*glacier.GetDataRetrievalPolicyOutput, error) {
 	args := m.Called(input)
 	if args.Get(0) != nil {
@@ -105,10 +132,34 @@ func (m *GlacierMock) GetDataRetrievalPolicy(input *glacier.GetDataRetrievalPoli
 	return nil, args.Error(1)
 }
 
+func (m *GlacierMock) SetDataRetrievalPolicy(input *glacier.SetDataRetrievalPolicyInput) (*glacier.SetDataRetrievalPolicyOutput, error) {
+	args := m.Called(input)
+	if args.Get(0) != nil {
+		return args.Get(0).(*glacier.SetDataRetrievalPolicyOutput), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 func newReaderClosable(reader io.Reader) ReaderClosable {
 	return ReaderClosable{reader}
 }
 
+type errorReader struct {
+	err error
+}
+
+func (reader errorReader) Read(p []byte) (int, error) {
+	return 0, reader.err
+}
+
+// Reads the content then fails with err if it is not nil
+func newInterruptedReader(content []byte, err error) io.Reader {
+	if err == nil {
+		return bytes.NewReader(content)
+	}
+	return io.MultiReader(bytes.NewReader(content), errorReader{err})
+}
+
 func mockGetDataRetrievalPolicy(glacierMock *GlacierMock, accountId, strategy string) *mock.Call {
 	input := &glacier.GetDataRetrievalPolicyInput{
 		AccountId:  &accountId,
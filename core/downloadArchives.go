@@ -1,7 +1,6 @@
 package core
 
 import (
-	"database/sql"
 	"os"
 	"rsg/utils"
 	"rsg/awsutils"
@@ -12,30 +11,40 @@ import (
 	"time"
 	"code.cloudfoundry.org/bytefmt"
 	"strings"
-	"rsg/inputs"
-	"rsg/speedtest"
+	"io"
 )
 
-// Test connection speed then computes how many bytes to retrieve with aws jobs for a duration of 4 hours.
+// Estimate download speed (see downloadSpeed.go) then computes how many bytes to retrieve with aws jobs for a duration
+// of 4 hours.
 // When first jobs has been completed, we download bytes corresponding to 5 min (based on connection speed).
 // After these 5 minutes, we update connection speed and start new retrieval jobs to maintain a buffer of 4
 // hours of bytes to download.
 // Finally, we wait next completed jobs, and over and over...
 //
-// If rate limit is reached, and nothing to download we wait 5 minutes before to retry 
+// Output of a job can be downloaded for about 24 hours after its completion: completed jobs found at startup are
+// downloaded first, oldest first. When the output of a job has expired, the bytes not yet written are retrieved again
+// by a new job.
+//
+// Downloads are limited by --max-rate and the rules of --schedule, the speed used to size the retrieval buffer is
+// bounded by the current rate. No job is started in windows where the schedule pauses them.
+//
+// Jobs are started within the retrieval budgets left (see retrievalBudget.go) and the rate of a BytesPerHour data
+// retrieval policy (see retrievalPolicy.go), when they are spent we wait until more bytes are allowed. A job rejected
+// by the data retrieval policy is retried with half its size, down to 1MB, then we wait until the policy allows more.
 
 type archiveRetrieve struct {
 	archiveId               string
 	size                    uint64
 	nextByteIndexToRetrieve uint64
+	endByteIndex            uint64 // index after the last byte to retrieve, size of the archive unless bytes of an expired job are retrieved again
 }
 
 func (archiveRetrieve *archiveRetrieve) sizeToRetrieveLeft() uint64 {
-	return archiveRetrieve.size - archiveRetrieve.nextByteIndexToRetrieve
+	return archiveRetrieve.endByteIndex - archiveRetrieve.nextByteIndexToRetrieve
 }
 
 func (archiveRetrieve *archiveRetrieve) retrieveIsComplete() bool {
-	return archiveRetrieve.nextByteIndexToRetrieve >= archiveRetrieve.size
+	return archiveRetrieve.nextByteIndexToRetrieve >= archiveRetrieve.endByteIndex
 }
 
 type archivePartRetrieve struct {
@@ -44,6 +53,10 @@ type archivePartRetrieve struct {
 	retrievedSize        uint64
 	archiveSize          uint64
 	nextByteIndexToWrite uint64
+	outputOffset         uint64 // index in the job output of the first byte retrieved for this part
+	completionDate       time.Time // zero if the job was not completed when it was found or started
+	measuredSize         uint64 // bytes downloaded without rate limit, measure of the download speed
+	measuredDuration     time.Duration
 }
 
 // + 10 is safety margin
@@ -51,6 +64,9 @@ const archiveRetrieveStructSize = 92 + 138 + 8 + 8 + 8 + 10
 const _4hoursInSeconds = 60 * 60 * 4
 const _5minInSeconds = 60 * 5
 
+// minimum wait when an allowance is spent, its reset date can be passed or close
+const minAllowanceWait = time.Minute
+
 type ArchiveRetrieveResult int
 
 const (
@@ -58,6 +74,7 @@ const (
 	IN_PROGRESS
 	SKIPPED
 	RETRY
+	ALLOWANCE_SPENT
 )
 
 type DownloadContext struct {
@@ -71,11 +88,21 @@ type DownloadContext struct {
 	archivePartRetrievalListMaxSize int // max number of elements in the list
 	archivePartRetrieveList         *list.List
 	hasArchiveRows                  bool
-	db                              *sql.DB
-	archiveRows                     *sql.Rows
+	mappingStore                    MappingStore // opened from mapping file if nil
+	archiveIterator                 ArchiveIterator
 	uncompletedRetrieve             *archiveRetrieve
+	retrievesToRestart              []*archiveRetrieve // bytes of expired jobs to retrieve again
+	unwrittenSizeByArchiveId        map[string]uint64 // an archive file is complete when all its bytes are written
 	uncompletedDownload             *archivePartRetrieve
 	nextByteIndexToDownload         uint64
+	checksumManifest                *checksumManifest // nil if checksum manifests are not requested
+	versionsByPath                  map[string][]*MappingEntry // paths with several versions
+	schedule                        *downloadSchedule // nil if downloads are not limited
+	rate                            uint64 // current download rate by second, unlimited if 0
+	budget                          *retrievalBudget // nil if retrievals have no budget
+	policy                          awsutils.DataRetrievalPolicy
+	allowanceResetDate              time.Time // date at which the spent allowance of retrieval grows again
+	allowanceLimit                  string // budget or policy which allowance is spent
 }
 
 func (downloadContext *DownloadContext) archivesRetrievingSizeLeft() uint64 {
@@ -85,67 +112,123 @@ func (downloadContext *DownloadContext) archivesRetrievingSizeLeft() uint64 {
 func DownloadArchives(restorationContext *RestorationContext) {
 	downloadContext := new(DownloadContext)
 	downloadContext.restorationContext = restorationContext
-	downloadContext.speedAutoUpdate = true
+	downloadContext.speedAutoUpdate = restorationContext.Options.Speed == 0
 	downloadContext.archivePartRetrievalListMaxSize = utils.S_1GB / archiveRetrieveStructSize
+	schedule, err := parseDownloadSchedule(restorationContext.Options.MaxRate, restorationContext.Options.Schedule)
+	utils.ExitIfError(err)
+	downloadContext.schedule = schedule
+	downloadContext.rate = schedule.rateAt(time.Now())
+	downloadContext.budget = newRetrievalBudget(restorationContext)
+	compactThroughputHistory()
 	if downloadContext.speedInBytesBySec == 0 {
-		downloadContext.speedInBytesBySec = detectOrSelectDownloadSpeed()
+		downloadContext.speedInBytesBySec = estimateOrSelectDownloadSpeed(speedEstimators(restorationContext))
 	}
-	downloadContext.archivesRetrievalMaxSize = downloadContext.speedInBytesBySec * uint64(_4hoursInSeconds)
-	downloadContext.downloadArchives()
-}
-
-func detectOrSelectDownloadSpeed() uint64 {
-	downloadSpeed, err := speedtest.SpeedTest()
-	if err != nil {
-		outputs.Printfln(outputs.Error, "Cannot test download speed : %v", err)
-		for downloadSpeed == 0 || err != nil {
-			downloadSpeed, err = bytefmt.ToBytes(inputs.QueryString("Select your download speed by second (ex 10K, 256K, 1M, 10M):"))
-			if err != nil {
-				outputs.Printfln(outputs.Error, "%v", err)
-			}
-		}
+	downloadContext.archivesRetrievalMaxSize = downloadContext.effectiveSpeed() * uint64(_4hoursInSeconds)
+	if restorationContext.Options.ChecksumManifest {
+		downloadContext.checksumManifest = newChecksumManifest(restorationContext.DestinationDirPath)
 	}
-	outputs.Printfln(outputs.OptionalInfo, "Download speed used : %v", bytefmt.ByteSize(downloadSpeed))
-	return downloadSpeed
+	downloadContext.downloadArchives()
 }
 
 func (downloadContext *DownloadContext) downloadArchives() {
-	DisplayWarnIfNotFreeTier(downloadContext.restorationContext)
+	downloadContext.policy = awsutils.GetDataRetrievalPolicy(downloadContext.restorationContext.GlacierClient)
+	if downloadContext.restorationContext.Options.InfoMessage {
+		displayWarnIfNotFreeTier(downloadContext.policy)
+	}
 	if (downloadContext.archivesRetrievalMaxSize < utils.S_1MB) {
 		utils.ExitIfError(errors.New("Max archives retrieving size cannot be less than 1MB"))
 	}
 
-	db := InitDb(downloadContext.restorationContext.GetMappingFilePath())
-	downloadContext.db = db
-	defer db.Close()
+	if downloadContext.mappingStore == nil {
+		downloadContext.mappingStore = InitMappingStore(downloadContext.restorationContext.GetMappingFilePath())
+		defer downloadContext.mappingStore.Close()
+	}
+	filter := newRestorationFilter(downloadContext.restorationContext)
 
-	archiveRows := GetArchives(db, downloadContext.restorationContext.Options.Filters)
-	downloadContext.archiveRows = archiveRows
-	defer archiveRows.Close()
+	// all paths are checked, files are restored at every path of their archive
+	versionsByPath, err := downloadContext.mappingStore.Versions(MappingFilter{})
+	utils.ExitIfError(err)
+	downloadContext.versionsByPath = versionsByPath
+	utils.ExitIfError(checkVersionKey(versionsByPath, filter.Version))
+	downloadContext.displayVersionsWarning(filter)
+
+	archiveIterator, err := downloadContext.mappingStore.Archives(filter)
+	utils.ExitIfError(err)
+	downloadContext.archiveIterator = archiveIterator
+	defer archiveIterator.Close()
 
-	downloadContext.nbBytesToDownload = GetTotalSize(db, downloadContext.restorationContext.Options.Filters)
+	downloadContext.nbBytesToDownload, err = downloadContext.mappingStore.TotalSize(filter)
+	utils.ExitIfError(err)
 	outputs.Printfln(outputs.OptionalInfo, "%v to restore", bytefmt.ByteSize(downloadContext.nbBytesToDownload))
 
 	downloadContext.archivePartRetrieveList = list.New()
+	downloadContext.unwrittenSizeByArchiveId = make(map[string]uint64)
 	downloadContext.archivesRetrievalSize = 0
 	downloadContext.hasArchiveRows = true
 
 	lastArchiveRetrieveResult := STARTED
 
+	awsutils.DownloadRateLimiter.SetRateSchedule(downloadContext.schedule.rateAt)
 	for !downloadContext.allFilesHasBeenProcessed() {
-		if (lastArchiveRetrieveResult == RETRY && downloadContext.uncompletedDownload == nil) {
-			downloadContext.displayStatus("rate limit reached, waiting")
+		if (lastArchiveRetrieveResult == ALLOWANCE_SPENT && downloadContext.uncompletedDownload == nil && downloadContext.archivePartRetrieveList.Len() == 0) {
+			downloadContext.waitAllowance()
+		}
+		downloadContext.applySchedule(time.Now())
+		if downloadContext.schedule.jobsAllowedAt(time.Now()) {
+			lastArchiveRetrieveResult = downloadContext.startArchiveRetrievingJobs()
+		} else if downloadContext.archivePartRetrieveList.Len() == 0 && downloadContext.uncompletedDownload == nil {
+			downloadContext.displayStatus("retrieval jobs paused by schedule")
 			time.Sleep(5 * time.Minute)
 		}
-		lastArchiveRetrieveResult = downloadContext.startArchiveRetrievingJobs()
 		downloadContext.downloadArchivesPartWhenReady()
 	}
+	awsutils.DownloadRateLimiter.SetRate(0)
+	if downloadContext.checksumManifest != nil {
+		downloadContext.checksumManifest.write()
+		outputs.Printfln(outputs.OptionalInfo, "Checksum manifests written: %v, %v", downloadContext.checksumManifest.filePath(), downloadContext.checksumManifest.jsonFilePath())
+	}
+}
+
+// Waits the reset date of the spent allowance, an allowance without reset date never allows 1MB
+func (downloadContext *DownloadContext) waitAllowance() {
+	if downloadContext.allowanceResetDate.IsZero() {
+		utils.ExitIfError(errors.New("The " + downloadContext.allowanceLimit + " never allows to retrieve 1MB, no retrieval job can be started"))
+	}
+	downloadContext.displayStatus(downloadContext.allowanceLimit + " spent, waiting until " + downloadContext.allowanceResetDate.Local().Format("2006-01-02 15:04"))
+	wait := downloadContext.allowanceResetDate.Sub(time.Now())
+	if wait < minAllowanceWait {
+		wait = minAllowanceWait
+	}
+	time.Sleep(wait)
+}
+
+// Download rate of the schedule at the date, the retrieval buffer is sized by the rate when it changes. The rate
+// limiter of downloads follows the schedule by itself.
+func (downloadContext *DownloadContext) applySchedule(date time.Time) {
+	rate := downloadContext.schedule.rateAt(date)
+	if downloadContext.schedule != nil && rate != downloadContext.rate {
+		outputs.Printfln(outputs.Verbose, "Download rate: %s", describeRate(rate))
+		downloadContext.rate = rate
+		downloadContext.archivesRetrievalMaxSize = downloadContext.effectiveSpeed() * uint64(_4hoursInSeconds)
+		if downloadContext.archivesRetrievalMaxSize < utils.S_1MB {
+			downloadContext.archivesRetrievalMaxSize = utils.S_1MB
+		}
+	}
+}
+
+// Download speed bounded by the rate of the schedule
+func (downloadContext *DownloadContext) effectiveSpeed() uint64 {
+	if downloadContext.rate != 0 && downloadContext.rate < downloadContext.speedInBytesBySec {
+		return downloadContext.rate
+	}
+	return downloadContext.speedInBytesBySec
 }
 
 func (downloadContext *DownloadContext) allFilesHasBeenProcessed() bool {
 	return !downloadContext.hasArchiveRows &&
 		downloadContext.archivePartRetrieveList.Len() == 0 &&
 		downloadContext.uncompletedRetrieve == nil &&
+		len(downloadContext.retrievesToRestart) == 0 &&
 		downloadContext.uncompletedDownload == nil
 }
 
@@ -153,14 +236,17 @@ func (downloadContext *DownloadContext) startArchiveRetrievingJobs() ArchiveRetr
 	lastArchiveRetrieveResult := STARTED
 	for downloadContext.archivesRetrievalSize < downloadContext.archivesRetrievalMaxSize &&
 		downloadContext.archivePartRetrieveList.Len() < downloadContext.archivePartRetrievalListMaxSize &&
-		(downloadContext.hasArchiveRows || downloadContext.uncompletedRetrieve != nil) {
+		(downloadContext.hasArchiveRows || downloadContext.uncompletedRetrieve != nil || len(downloadContext.retrievesToRestart) > 0) {
 		downloadContext.displayStatus("start retrieve jobs")
-		if downloadContext.uncompletedRetrieve == nil {
+		if downloadContext.uncompletedRetrieve == nil && len(downloadContext.retrievesToRestart) > 0 {
+			downloadContext.uncompletedRetrieve = downloadContext.retrievesToRestart[0]
+			downloadContext.retrievesToRestart = downloadContext.retrievesToRestart[1:]
+		} else if downloadContext.uncompletedRetrieve == nil {
 			downloadContext.uncompletedRetrieve = downloadContext.findNextArchiveToRetrieve()
 		}
 		if downloadContext.uncompletedRetrieve != nil {
 			lastArchiveRetrieveResult = downloadContext.startArchivePartRetrieveJob(downloadContext.uncompletedRetrieve)
-			if lastArchiveRetrieveResult == RETRY {
+			if lastArchiveRetrieveResult == RETRY || lastArchiveRetrieveResult == ALLOWANCE_SPENT {
 				break
 			}
 		}
@@ -171,12 +257,12 @@ func (downloadContext *DownloadContext) startArchiveRetrievingJobs() ArchiveRetr
 func (downloadContext *DownloadContext) findNextArchiveToRetrieve() *archiveRetrieve {
 	var archiveToRetrieve *archiveRetrieve;
 	for archiveToRetrieve == nil && downloadContext.hasArchiveRows {
-		downloadContext.hasArchiveRows = downloadContext.archiveRows.Next()
-		if downloadContext.hasArchiveRows {
-			var archiveId string
-			var fileSize uint64
-			err := downloadContext.archiveRows.Scan(&archiveId, &fileSize)
-			utils.ExitIfError(err)
+		downloadContext.hasArchiveRows = downloadContext.archiveIterator.Next()
+		if !downloadContext.hasArchiveRows {
+			utils.ExitIfError(downloadContext.archiveIterator.Err())
+		} else {
+			archiveId := downloadContext.archiveIterator.Archive().ArchiveId
+			fileSize := downloadContext.archiveIterator.Archive().Size
 
 			if !downloadContext.checkAllFilesOfArchiveExists(archiveId) {
 				if stat, err := os.Stat(downloadContext.restorationContext.DestinationDirPath + "/" + archiveId); !os.IsNotExist(err) {
@@ -184,42 +270,39 @@ func (downloadContext *DownloadContext) findNextArchiveToRetrieve() *archiveRetr
 					if !downloadContext.handleArchiveFileDownloadCompletion(archiveId, fileSize) {
 						archiveToRetrieve = &archiveRetrieve{archiveId: archiveId,
 							size: fileSize,
-							nextByteIndexToRetrieve: uint64(stat.Size()) - (uint64(stat.Size()) % utils.S_1MB)}
+							nextByteIndexToRetrieve: uint64(stat.Size()) - (uint64(stat.Size()) % utils.S_1MB),
+							endByteIndex: fileSize}
 					}
 				} else if fileSize == 0 {
 					downloadContext.createFilesForEmptyArchive(archiveId)
 				} else {
-					archiveToRetrieve = &archiveRetrieve{archiveId: archiveId, size: fileSize, nextByteIndexToRetrieve: 0}
+					archiveToRetrieve = &archiveRetrieve{archiveId: archiveId, size: fileSize, nextByteIndexToRetrieve: 0, endByteIndex: fileSize}
 				}
 			}
 		}
 	}
+	if archiveToRetrieve != nil {
+		downloadContext.unwrittenSizeByArchiveId[archiveToRetrieve.archiveId] = archiveToRetrieve.sizeToRetrieveLeft()
+	}
 	return archiveToRetrieve
 }
 
 func (downloadContext *DownloadContext) checkAllFilesOfArchiveExists(archiveId string) bool {
-	pathRows := GetPaths(downloadContext.db, archiveId)
-	for pathRows.Next() {
-		var path string
-		pathRows.Scan(&path)
-
+	paths, _ := downloadContext.destinationPaths(archiveId)
+	for _, path := range paths {
 		if utils.Exists(downloadContext.restorationContext.DestinationDirPath + "/" + path) {
 			outputs.Printfln(outputs.Verbose, "Skip existing file %s", downloadContext.restorationContext.DestinationDirPath + "/" + path)
 		} else {
 			outputs.Printfln(outputs.Verbose, "File not found: %v/%v", downloadContext.restorationContext.DestinationDirPath, path)
 			return false
-
 		}
 	}
-	pathRows.Close()
 	return true
 }
 
 func (downloadContext *DownloadContext) createFilesForEmptyArchive(archiveId string) {
-	pathRows := GetPaths(downloadContext.db, archiveId)
-	for pathRows.Next() {
-		var path string
-		pathRows.Scan(&path)
+	paths, entryByPath := downloadContext.destinationPaths(archiveId)
+	for _, path := range paths {
 		if !utils.Exists(downloadContext.restorationContext.DestinationDirPath + "/"+ path) {
 			err := os.MkdirAll(filepath.Dir(downloadContext.restorationContext.DestinationDirPath + "/"+ path), 0700)
 			utils.ExitIfError(err)
@@ -227,9 +310,10 @@ func (downloadContext *DownloadContext) createFilesForEmptyArchive(archiveId str
 			utils.ExitIfError(err)
 			err = file.Close()
 			utils.ExitIfError(err)
+			downloadContext.applyFileMetadata(entryByPath, path)
+			downloadContext.addFileToChecksumManifest(path, 0, archiveId, emptyFileSha256())
 		}
 	}
-	pathRows.Close()
 }
 
 func (downloadContext *DownloadContext) computeSizeToRetrieve(archiveToRetrieve *archiveRetrieve) (uint64, bool) {
@@ -242,10 +326,50 @@ func (downloadContext *DownloadContext) computeSizeToRetrieve(archiveToRetrieve
 	return sizeToRetrieve, true;
 }
 
+// Bytes which can be retrieved at the date within the budgets and the data retrieval policy, date at which more bytes
+// are allowed and name of the limit
+func (downloadContext *DownloadContext) retrievalAllowance(date time.Time) (uint64, time.Time, string) {
+	if downloadContext.budget == nil && downloadContext.policy.Strategy != awsutils.BytesPerHourStrategy {
+		return budgetUnlimited, time.Time{}, ""
+	}
+	entries := readRetrievalLedger()
+	allowance, resetDate := downloadContext.budget.allowance(entries, date)
+	limit := "retrieval budget"
+	if rateAllowance, leaveDate := policyAllowance(downloadContext.policy, entries, downloadContext.restorationContext.Region, date); rateAllowance < allowance {
+		allowance, resetDate, limit = rateAllowance, leaveDate, "rate of data retrieval policy"
+	}
+	return allowance, resetDate, limit
+}
+
 func (downloadContext *DownloadContext) startArchivePartRetrieveJob(archiveToRetrieve *archiveRetrieve) ArchiveRetrieveResult {
 	sizeToRetrieve, isEndOfFile := downloadContext.computeSizeToRetrieve(downloadContext.uncompletedRetrieve)
+	reservation := ""
+	if !awsutils.JobIdsAtStartup.HasJobForFileRetrieval(archiveToRetrieve.archiveId, archiveToRetrieve.nextByteIndexToRetrieve) {
+		// the allowance must not be spent by another run between its check and the reservation of the bytes
+		unlockRetrievalLedger := lockRetrievalLedger()
+		allowance, resetDate, limit := downloadContext.retrievalAllowance(time.Now())
+		if allowance < sizeToRetrieve && allowance < utils.S_1MB {
+			unlockRetrievalLedger()
+			outputs.Printfln(outputs.Verbose, "%s spent until %v", limit, resetDate)
+			downloadContext.allowanceResetDate = resetDate
+			downloadContext.allowanceLimit = limit
+			return ALLOWANCE_SPENT
+		} else if allowance < sizeToRetrieve {
+			sizeToRetrieve, isEndOfFile = allowance, false
+		}
+		if allowance != budgetUnlimited && (isEndOfFile || sizeToRetrieve / utils.S_1MB > 0) {
+			reservation = newRetrievalReservation()
+			downloadContext.appendRetrievalLedgerEntry(reservation, "", sizeToRetrieve)
+		}
+		unlockRetrievalLedger()
+	}
 	if (isEndOfFile || sizeToRetrieve / utils.S_1MB > 0) {
-		startStatus, jobId, sizeRetrieved := downloadContext.retryArchivePartRetrieveJob(archiveToRetrieve, sizeToRetrieve)
+		startStatus, jobStartStatus := downloadContext.retryArchivePartRetrieveJob(archiveToRetrieve, sizeToRetrieve, reservation)
+		if startStatus == STARTED {
+			downloadContext.appendRetrievalLedgerEntry(reservation, jobStartStatus.JobId, jobStartStatus.SizeRetrieved)
+		} else if reservation != "" {
+			downloadContext.appendRetrievalLedgerEntry(reservation, "", 0)
+		}
 		if startStatus == STARTED || startStatus == IN_PROGRESS {
 			statusStr := ""
 			if startStatus == STARTED {
@@ -256,15 +380,17 @@ func (downloadContext *DownloadContext) startArchivePartRetrieveJob(archiveToRet
 			outputs.Printfln(outputs.Verbose, "Job %s for archive id %s to retrieve %v from %v byte index",
 				statusStr,
 				archiveToRetrieve.archiveId,
-				bytefmt.ByteSize(sizeRetrieved),
+				bytefmt.ByteSize(jobStartStatus.SizeRetrieved),
 				archiveToRetrieve.nextByteIndexToRetrieve)
-			archivePartRetrieve := &archivePartRetrieve{jobId: jobId,
+			archivePartRetrieve := &archivePartRetrieve{jobId: jobStartStatus.JobId,
 				archiveId: archiveToRetrieve.archiveId,
-				retrievedSize: sizeRetrieved,
+				retrievedSize: jobStartStatus.SizeRetrieved,
 				archiveSize: archiveToRetrieve.size,
-				nextByteIndexToWrite: archiveToRetrieve.nextByteIndexToRetrieve}
-			archiveToRetrieve.nextByteIndexToRetrieve += sizeRetrieved
-			downloadContext.archivesRetrievalSize += sizeRetrieved
+				nextByteIndexToWrite: archiveToRetrieve.nextByteIndexToRetrieve,
+				outputOffset: jobStartStatus.OutputOffset,
+				completionDate: jobStartStatus.CompletionDate}
+			archiveToRetrieve.nextByteIndexToRetrieve += jobStartStatus.SizeRetrieved
+			downloadContext.archivesRetrievalSize += jobStartStatus.SizeRetrieved
 			downloadContext.archivePartRetrieveList.PushFront(archivePartRetrieve)
 			downloadContext.handleArchiveRetrieveCompletion(archiveToRetrieve)
 		}
@@ -273,26 +399,48 @@ func (downloadContext *DownloadContext) startArchivePartRetrieveJob(archiveToRet
 	return RETRY
 }
 
-func (downloadContext *DownloadContext) retryArchivePartRetrieveJob(archiveToRetrieve *archiveRetrieve, sizeToRetrieve uint64) (ArchiveRetrieveResult, string, uint64) {
+// Entry of a job started or of a reservation of bytes, a reservation without job is replaced by an entry without size
+func (downloadContext *DownloadContext) appendRetrievalLedgerEntry(reservation, jobId string, size uint64) {
+	appendRetrievalLedgerEntry(retrievalLedgerEntry{Date: time.Now().UTC(),
+		Region: downloadContext.restorationContext.Region,
+		Vault: downloadContext.restorationContext.Vault,
+		JobId: jobId,
+		Size: size,
+		Cost: float64(size) / float64(utils.S_1GB) * retrievalCostByGB(downloadContext.restorationContext.Region),
+		Reservation: reservation})
+}
+
+// The reservation of the bytes to retrieve isn't counted by the explanation of a rejection by the data retrieval policy
+func (downloadContext *DownloadContext) retryArchivePartRetrieveJob(archiveToRetrieve *archiveRetrieve, sizeToRetrieve uint64, reservation string) (ArchiveRetrieveResult, awsutils.JobStartStatus) {
 
 	for {
 		jobStartStatus := awsutils.StartRetrievePartialArchiveJob(downloadContext.restorationContext.GlacierClient,
 			downloadContext.restorationContext.Vault,
-			awsutils.Archive{archiveToRetrieve.archiveId, archiveToRetrieve.size},
+			awsutils.Archive{ArchiveId: archiveToRetrieve.archiveId, Size: archiveToRetrieve.size},
 			archiveToRetrieve.nextByteIndexToRetrieve,
 			sizeToRetrieve)
 		if jobStartStatus.Err == nil {
 			if jobStartStatus.IsResumed {
-				return IN_PROGRESS, jobStartStatus.JobId, jobStartStatus.SizeRetrieved
+				return IN_PROGRESS, jobStartStatus
 			}
-			return STARTED, jobStartStatus.JobId, jobStartStatus.SizeRetrieved
+			return STARTED, jobStartStatus
 		}
-		if strings.Contains(jobStartStatus.Err.Error(), "PolicyEnforcedException") {
-				return RETRY, "", 0
+		if awsutils.IsPolicyEnforcedError(jobStartStatus.Err) {
+			downloadContext.policy = awsutils.GetDataRetrievalPolicy(downloadContext.restorationContext.GlacierClient)
+			entries := withoutReservation(readRetrievalLedger(), reservation)
+			outputs.Println(outputs.Warning, explainPolicyRejection(downloadContext.policy, entries,
+				downloadContext.restorationContext.Region, sizeToRetrieve, time.Now()))
+			if sizeToRetrieve < 2 * utils.S_1MB {
+				downloadContext.allowanceResetDate = policyRejectionRetryDate(downloadContext.policy, entries,
+					downloadContext.restorationContext.Region, time.Now())
+				downloadContext.allowanceLimit = "data retrieval policy"
+				return ALLOWANCE_SPENT, jobStartStatus
+			}
+			sizeToRetrieve = sizeToRetrieve / 2 / utils.S_1MB * utils.S_1MB
 		} else if strings.Contains(jobStartStatus.Err.Error(), "ResourceNotFoundException") {
 			outputs.Printfln(outputs.Warning, "Archive not found %s, skipped...", archiveToRetrieve.archiveId)
 			downloadContext.uncompletedRetrieve = nil
-			return SKIPPED, "", 0
+			return SKIPPED, jobStartStatus
 		} else {
 			utils.ExitIfError(jobStartStatus.Err)
 		}
@@ -307,7 +455,7 @@ func (downloadContext *DownloadContext) handleArchiveRetrieveCompletion(archiveT
 }
 
 func (downloadContext *DownloadContext) downloadArchivesPartWhenReady() {
-	maxArchivesDownloadingSize := downloadContext.speedInBytesBySec * uint64(_5minInSeconds)
+	maxArchivesDownloadingSize := downloadContext.effectiveSpeed() * uint64(_5minInSeconds)
 	var archivesDownloadingSize uint64 = 0
 	totalDuration := time.Duration(0)
 
@@ -315,21 +463,62 @@ func (downloadContext *DownloadContext) downloadArchivesPartWhenReady() {
 		if (downloadContext.uncompletedDownload == nil && downloadContext.archivePartRetrieveList.Len() > 0) {
 			downloadContext.displayStatus("wait archive retrieve job")
 			downloadContext.uncompletedDownload = downloadContext.waitNextArchivePartIsRetrieved()
+			if downloadContext.uncompletedDownload == nil {
+				continue
+			}
 		}
 		downloadContext.displayStatus("downloading")
 		archivesDownloadingSizeLeft := maxArchivesDownloadingSize - archivesDownloadingSize
-		sizeDownloaded, duration := downloadArchivePart(downloadContext.restorationContext, downloadContext.uncompletedDownload, downloadContext.nextByteIndexToDownload, archivesDownloadingSizeLeft)
+		sizeDownloaded, missingRanges, duration, err := downloadArchivePart(downloadContext.restorationContext, downloadContext.uncompletedDownload, downloadContext.nextByteIndexToDownload, archivesDownloadingSizeLeft, downloadContext.hashWriter(downloadContext.uncompletedDownload))
 		totalDuration += duration
 		archivesDownloadingSize += sizeDownloaded
+		if awsutils.DownloadRateLimiter.Rate() == 0 {
+			downloadContext.uncompletedDownload.measuredSize += sizeDownloaded
+			downloadContext.uncompletedDownload.measuredDuration += duration
+		}
 		downloadContext.nbBytesDownloaded += sizeDownloaded
 		downloadContext.nextByteIndexToDownload += sizeDownloaded
 		downloadContext.archivesRetrievalSize -= sizeDownloaded
+		downloadContext.unwrittenSizeByArchiveId[downloadContext.uncompletedDownload.archiveId] -= sizeDownloaded
+		if awsutils.IsJobNotFoundError(err) {
+			downloadContext.restartExpiredRetrieve(downloadContext.uncompletedDownload, missingRanges)
+			downloadContext.uncompletedDownload = nil
+			downloadContext.nextByteIndexToDownload = 0
+			continue
+		}
+		if err != nil && len(missingRanges) > 0 {
+			// the next run resumes from the size of the file, it must not contain bytes after a missing range
+			truncateErr := os.Truncate(downloadContext.restorationContext.DestinationDirPath + "/" + downloadContext.uncompletedDownload.archiveId, int64(missingRanges[0].From))
+			utils.ExitIfError(truncateErr)
+		}
+		utils.ExitIfError(err)
 
 		downloadContext.handleArchivePartDownloadCompletion(downloadContext.restorationContext)
 	}
 	downloadContext.updateDownloadSpeed(archivesDownloadingSize, totalDuration)
 }
 
+// Bytes of the part not downloaded yet (ranges of byte indexes of the archive) are retrieved again by new jobs. A job
+// starts at the MB boundary before the first byte of its range, the bytes before it are written again.
+func (downloadContext *DownloadContext) restartExpiredRetrieve(archivePartRetrieve *archivePartRetrieve, missingRanges []awsutils.ByteRange) {
+	awsutils.JobIdsAtStartup.RemoveFileRetrievalJob(archivePartRetrieve.archiveId, archivePartRetrieve.jobId)
+	sizeLeft := uint64(0)
+	for _, missingRange := range missingRanges {
+		sizeLeft += missingRange.Size
+	}
+	outputs.Printfln(outputs.Warning, "Output of job %s has expired, %v of archive %s will be retrieved again",
+		archivePartRetrieve.jobId, bytefmt.ByteSize(sizeLeft), archivePartRetrieve.archiveId)
+	downloadContext.archivesRetrievalSize -= sizeLeft
+	for _, missingRange := range missingRanges {
+		fromByte := missingRange.From - missingRange.From % utils.S_1MB
+		downloadContext.unwrittenSizeByArchiveId[archivePartRetrieve.archiveId] += missingRange.From - fromByte
+		downloadContext.retrievesToRestart = append(downloadContext.retrievesToRestart, &archiveRetrieve{archiveId: archivePartRetrieve.archiveId,
+			size: archivePartRetrieve.archiveSize,
+			nextByteIndexToRetrieve: fromByte,
+			endByteIndex: missingRange.From + missingRange.Size})
+	}
+}
+
 func (downloadContext *DownloadContext) displayStatus(phase string) {
 	restored := uint64(0)
 	if downloadContext.nbBytesToDownload != 0 {
@@ -354,7 +543,10 @@ func (downloadContext *DownloadContext) updateDownloadSpeed(downloadedSize uint6
 
 func (downloadContext *DownloadContext) handleArchivePartDownloadCompletion(restorationContext *RestorationContext) {
 	if (downloadContext.nextByteIndexToDownload >= downloadContext.uncompletedDownload.retrievedSize) {
-		downloadContext.handleArchiveFileDownloadCompletion(downloadContext.uncompletedDownload.archiveId, downloadContext.uncompletedDownload.archiveSize)
+		recordThroughput(restorationContext.Region, downloadContext.uncompletedDownload.measuredSize, downloadContext.uncompletedDownload.measuredDuration)
+		if downloadContext.unwrittenSizeByArchiveId[downloadContext.uncompletedDownload.archiveId] == 0 {
+			downloadContext.handleArchiveFileDownloadCompletion(downloadContext.uncompletedDownload.archiveId, downloadContext.uncompletedDownload.archiveSize)
+		}
 		downloadContext.uncompletedDownload = nil
 		downloadContext.nextByteIndexToDownload = 0
 	}
@@ -370,21 +562,24 @@ func (downloadContext *DownloadContext) handleArchiveFileDownloadCompletion(arch
 	utils.ExitIfError(err)
 	if uint64(stat.Size()) >= size {
 		outputs.Printfln(outputs.Verbose, "Archive %v downloaded", archiveId)
-
-		pathRows := GetPaths(downloadContext.db, archiveId)
-		defer pathRows.Close()
-		var previousPath string
-		if pathRows.Next() {
-			pathRows.Scan(&previousPath)
+		sha256Sum := ""
+		if downloadContext.checksumManifest != nil {
+			sha256Sum = downloadContext.checksumManifest.archiveSha256(archiveId, uint64(stat.Size()))
 		}
-		for pathRows.Next() {
-			var path string
-			pathRows.Scan(&path)
+		paths, entryByPath := downloadContext.destinationPaths(archiveId)
+		var previousPath string
+		for i, path := range paths {
+			if i == 0 {
+				previousPath = path
+				continue
+			}
 			if !utils.Exists(destinationDirPath + "/" + previousPath) {
 				err = os.MkdirAll(filepath.Dir(destinationDirPath + "/" + previousPath), 0700)
 				utils.ExitIfError(err)
 				utils.CopyFile(destinationDirPath + "/" + previousPath, destinationDirPath + "/" + archiveId)
 				outputs.Printfln(outputs.Verbose, "File %v restored (copy from %v)", destinationDirPath + "/" + previousPath, archiveId)
+				downloadContext.applyFileMetadata(entryByPath, previousPath)
+				downloadContext.addFileToChecksumManifest(previousPath, uint64(stat.Size()), archiveId, sha256Sum)
 			}
 			previousPath = path;
 		}
@@ -393,33 +588,129 @@ func (downloadContext *DownloadContext) handleArchiveFileDownloadCompletion(arch
 			utils.ExitIfError(err)
 			os.Rename(destinationDirPath + "/" + archiveId, destinationDirPath + "/" + previousPath)
 			outputs.Printfln(outputs.Verbose, "File %v restored (rename from %v)", destinationDirPath + "/" + previousPath, archiveId)
+			downloadContext.applyFileMetadata(entryByPath, previousPath)
+			downloadContext.addFileToChecksumManifest(previousPath, uint64(stat.Size()), archiveId, sha256Sum)
 		}
 		return true
 	}
 	return false
 }
 
+// Paths of files stored in the archive and their entries by path. Only the selected version of paths with several
+// versions is restored, or every version with the key as suffix if all versions are restored.
+func (downloadContext *DownloadContext) destinationPaths(archiveId string) ([]string, map[string]*MappingEntry) {
+	entries, err := downloadContext.mappingStore.EntriesForArchive(archiveId)
+	utils.ExitIfError(err)
+	paths := []string{}
+	entryByPath := make(map[string]*MappingEntry)
+	for _, mappingEntry := range entries {
+		path := mappingEntry.Path()
+		if versions, ok := downloadContext.versionsByPath[path]; ok {
+			if downloadContext.restorationContext.Options.AllVersions {
+				path = versionPath(path, mappingEntry.Key)
+			} else if selectVersionKey(versions, downloadContext.restorationContext.Options.FileVersion) != mappingEntry.Key {
+				outputs.Printfln(outputs.Verbose, "Skip version %d of %s", mappingEntry.Key, path)
+				continue
+			}
+		}
+		if _, ok := entryByPath[path]; !ok {
+			paths = append(paths, path)
+			entryByPath[path] = mappingEntry
+		}
+	}
+	return paths, entryByPath
+}
+
+func (downloadContext *DownloadContext) displayVersionsWarning(filter MappingFilter) {
+	if len(downloadContext.versionsByPath) > 0 {
+		if downloadContext.restorationContext.Options.AllVersions {
+			outputs.Printfln(outputs.OptionalInfo, "%d path(s) have several versions, all versions are restored side by side", len(downloadContext.versionsByPath))
+		} else {
+			outputs.Printfln(outputs.Warning, "%d path(s) have several versions, version restored: %s (use --file-version or --all-versions to change it)", len(downloadContext.versionsByPath), filter.Version)
+		}
+	}
+}
+
+func (downloadContext *DownloadContext) applyFileMetadata(entryByPath map[string]*MappingEntry, path string) {
+	if mappingEntry, ok := entryByPath[path]; ok && downloadContext.mappingStore.HasFileMetadata() {
+		applyFileMetadata(downloadContext.restorationContext.DestinationDirPath + "/" + path, mappingEntry)
+	}
+}
+
+func (downloadContext *DownloadContext) addFileToChecksumManifest(path string, size uint64, archiveId, sha256Sum string) {
+	if downloadContext.checksumManifest != nil {
+		downloadContext.checksumManifest.addFile(path, size, archiveId, sha256Sum)
+	}
+}
+
+func (downloadContext *DownloadContext) hashWriter(archivePartRetrieve *archivePartRetrieve) io.Writer {
+	if downloadContext.checksumManifest == nil {
+		return nil
+	}
+	return downloadContext.checksumManifest.hashWriter(archivePartRetrieve.archiveId, archivePartRetrieve.nextByteIndexToWrite)
+}
+
+// Completed part whose job output expires first, else oldest part once its job is completed. Returns nil if the output
+// of the job has expired, its bytes are retrieved again.
 func (downloadContext *DownloadContext) waitNextArchivePartIsRetrieved() *archivePartRetrieve {
 	element := downloadContext.archivePartRetrieveList.Back()
-	archivePartRetrieve := element.Value.(*archivePartRetrieve)
-	awsutils.WaitJobIsCompleted(downloadContext.restorationContext.GlacierClient, downloadContext.restorationContext.Vault, archivePartRetrieve.jobId)
+	for candidate := downloadContext.archivePartRetrieveList.Front(); candidate != nil; candidate = candidate.Next() {
+		completionDate := candidate.Value.(*archivePartRetrieve).completionDate
+		if !completionDate.IsZero() && (element.Value.(*archivePartRetrieve).completionDate.IsZero() || completionDate.Before(element.Value.(*archivePartRetrieve).completionDate)) {
+			element = candidate
+		}
+	}
 	downloadContext.archivePartRetrieveList.Remove(element)
+	archivePartRetrieve := element.Value.(*archivePartRetrieve)
+	if archivePartRetrieve.completionDate.IsZero() {
+		completionDate, err := awsutils.WaitRetrievalJobIsCompleted(downloadContext.restorationContext.GlacierClient, downloadContext.restorationContext.Vault, archivePartRetrieve.jobId)
+		if err != nil {
+			downloadContext.restartExpiredRetrieve(archivePartRetrieve, []awsutils.ByteRange{{From: archivePartRetrieve.nextByteIndexToWrite, Size: archivePartRetrieve.retrievedSize}})
+			return nil
+		}
+		archivePartRetrieve.completionDate = completionDate
+	} else if time.Since(archivePartRetrieve.completionDate) > awsutils.JobOutputLifetime {
+		downloadContext.restartExpiredRetrieve(archivePartRetrieve, []awsutils.ByteRange{{From: archivePartRetrieve.nextByteIndexToWrite, Size: archivePartRetrieve.retrievedSize}})
+		return nil
+	}
 	return archivePartRetrieve
 }
 
-func downloadArchivePart(restorationContext *RestorationContext, archivePartRetrieve *archivePartRetrieve, fromByteIndex, nbBytesCanDownload uint64) (uint64, time.Duration) {
+// Returns the size downloaded and, if the download fails, the ranges of byte indexes of the archive not downloaded up to
+// the end of the part
+func downloadArchivePart(restorationContext *RestorationContext, archivePartRetrieve *archivePartRetrieve, fromByteIndex, nbBytesCanDownload uint64, hashWriter io.Writer) (uint64, []awsutils.ByteRange, time.Duration, error) {
 	sizeToDownload := archivePartRetrieve.retrievedSize - fromByteIndex
 	if (sizeToDownload > nbBytesCanDownload) {
 		sizeToDownload = nbBytesCanDownload
 	}
 	start := time.Now()
-	sizeDownloaded := awsutils.DownloadPartialArchiveTo(restorationContext.GlacierClient,
+	sizeDownloaded, missingSegments, err := awsutils.DownloadPartialArchiveTo(restorationContext.GlacierClient,
 		restorationContext.Vault,
 		archivePartRetrieve.jobId,
 		restorationContext.DestinationDirPath + "/" + archivePartRetrieve.archiveId,
-		fromByteIndex,
+		archivePartRetrieve.outputOffset + fromByteIndex,
 		sizeToDownload,
-		archivePartRetrieve.nextByteIndexToWrite)
-	archivePartRetrieve.nextByteIndexToWrite += sizeDownloaded
-	return sizeDownloaded, time.Since(start)
+		archivePartRetrieve.nextByteIndexToWrite,
+		hashWriter,
+		restorationContext.Options.DownloadStreams)
+	duration := time.Since(start)
+	if err == nil {
+		archivePartRetrieve.nextByteIndexToWrite += sizeDownloaded
+		return sizeDownloaded, nil, duration, nil
+	}
+	missingRanges := []awsutils.ByteRange{}
+	missingSegments = append(missingSegments, awsutils.ByteRange{From: sizeToDownload, Size: archivePartRetrieve.retrievedSize - fromByteIndex - sizeToDownload})
+	for _, segment := range missingSegments {
+		if segment.Size == 0 {
+			continue
+		}
+		last := len(missingRanges) - 1
+		switch {
+		case last >= 0 && missingRanges[last].From + missingRanges[last].Size == archivePartRetrieve.nextByteIndexToWrite + segment.From:
+			missingRanges[last].Size += segment.Size
+		default:
+			missingRanges = append(missingRanges, awsutils.ByteRange{From: archivePartRetrieve.nextByteIndexToWrite + segment.From, Size: segment.Size})
+		}
+	}
+	return sizeDownloaded, missingRanges, duration, err
 }
@@ -2,6 +2,10 @@ package core
 
 import (
 	"testing"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"database/sql"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/glacier"
@@ -14,6 +18,8 @@ import (
 	"os"
 	"errors"
 	"rsg/awsutils"
+	"encoding/json"
+	"time"
 )
 
 func mockStartPartialRetrieveJob(glacierMock *GlacierMock, vault, archiveId, bytesRange, jobIdToReturn string) *mock.Call {
@@ -106,12 +112,12 @@ func TestDownloadArchives_retrieve_and_download_file_in_one_part(t *testing.T) {
 		archivePartRetrievalListMaxSize: 1,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 5);")
 	db.Close()
 
@@ -139,12 +145,12 @@ func TestDownloadArchives_retrieve_and_download_file_with_multipart(t *testing.T
 		archivePartRetrievalListMaxSize: 10,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 4194304);")
 	db.Close()
 
@@ -184,12 +190,12 @@ func TestDownloadArchives_retrieve_and_download_2_files_with_multipart(t *testin
 		archivePartRetrievalListMaxSize: 10,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 4194304);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file2.txt', 'archiveId2', 2097152);")
 	db.Close()
@@ -241,12 +247,12 @@ func TestDownloadArchives_retrieve_and_download_3_files_with_2_identical(t *test
 		archivePartRetrievalListMaxSize: 10,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 4194304);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file2.txt', 'archiveId2', 2097152);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file3.txt', 'archiveId1', 4194304);")
@@ -301,12 +307,12 @@ func TestDownloadArchives_retrieve_and_download_only_filtered_files(t *testing.T
 		archivePartRetrievalListMaxSize: 10,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file1.txt', 'archiveId1', 2);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file2.bin', 'archiveId2', 2);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folderno/no.bin', 'archiveId3', 2);")
@@ -357,12 +363,12 @@ func TestDownloadArchives_compute_total_size(t *testing.T) {
 		archivePartRetrievalListMaxSize: 10,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file1.txt', 'archiveId1', 2);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file2.bin', 'archiveId2', 2);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file3.txt', 'archiveId1', 2);")
@@ -395,12 +401,12 @@ func TestDownloadArchives_retrieve_and_download_an_archive_already_started(t *te
 		archivePartRetrievalListMaxSize: 10,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file1.txt', 'archiveId1', 1048581);")
 	db.Close()
 
@@ -430,12 +436,12 @@ func TestDownloadArchives_retrieve_and_download_an_archive_already_started_and_c
 		archivePartRetrievalListMaxSize: 10,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file1.txt', 'archiveId1', 1048581);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file2.txt', 'archiveId1', 1048581);")
 	db.Close()
@@ -463,12 +469,12 @@ func TestDownloadArchives_retrieve_when_archive_is_not_found(t *testing.T) {
 		archivePartRetrievalListMaxSize: 10,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file1.txt', 'archiveId1', 1);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file2.txt', 'archiveId2', 1);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file3.txt', 'archiveId3', 1);")
@@ -506,12 +512,12 @@ func TestDownloadArchives_retrieve_empty_file(t *testing.T) {
 		archivePartRetrievalListMaxSize: 10,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file1.txt', 'GlacierZeroSizeFile', 0);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/folder/file2.txt', 'GlacierZeroSizeFile', 0);")
 	db.Close()
@@ -537,16 +543,16 @@ func TestDownloadArchives_retrieve_and_download_when_retrieval_job_exists(t *tes
 		archivePartRetrievalListMaxSize: 1,
 		archivePartRetrieveList: nil,
 		hasArchiveRows: false,
-		db: nil,
-		archiveRows:nil,
+		mappingStore: nil,
+		archiveIterator: nil,
 	}
 
 	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
-	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
 	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 5);")
 	db.Close()
 
-	awsutils.AddRetrievalJobAtStartup("archiveId1", "0-4", "jobId1")
+	awsutils.AddRetrievalJobAtStartup("archiveId1", "0-4", "jobId1", time.Time{})
 	mockDescribeJob(glacierMock, "jobId1", restorationContext.Vault, true)
 	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "0-4", []byte("hello"))
 
@@ -557,11 +563,483 @@ func TestDownloadArchives_retrieve_and_download_when_retrieval_job_exists(t *tes
 	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", "hello")
 }
 
+func TestDownloadArchives_retrieve_only_gaps_of_existing_jobs(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 20000,
+		archivesRetrievalMaxSize: utils.S_1MB * 4,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 10,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: nil,
+		archiveIterator: nil,
+	}
+
+	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 3145728);")
+	db.Close()
+
+	awsutils.AddRetrievalJobAtStartup("archiveId1", "1048576-3145727", "jobId0", time.Time{})
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-1048575", "jobId1").Once()
+	mockDescribeJob(glacierMock, "jobId1", restorationContext.Vault, true).Once()
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "0-1048575", []byte(strings.Repeat("_", 1048576))).Once()
+	mockDescribeJob(glacierMock, "jobId0", restorationContext.Vault, true).Once()
+	mockPartialOutputJob(glacierMock, "jobId0", restorationContext.Vault, "0-2097151", append([]byte(strings.Repeat("_", 2097147)), []byte("hello")...)).Once()
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	glacierMock.AssertNumberOfCalls(t, "InitiateJob", 1)
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", strings.Repeat("_", 3145723) + "hello")
+}
+
+func TestDownloadArchives_reuse_existing_job_from_the_middle(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 20000,
+		archivesRetrievalMaxSize: utils.S_1MB * 4,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 10,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: nil,
+		archiveIterator: nil,
+	}
+
+	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 1048581);")
+	db.Close()
+
+	ioutil.WriteFile("../../testtmp/dest/archiveId1", append([]byte(strings.Repeat("_", 1048576)), []byte("hel")...), 0700)
+
+	awsutils.AddRetrievalJobAtStartup("archiveId1", "0-1048580", "jobId0", time.Time{})
+	mockDescribeJob(glacierMock, "jobId0", restorationContext.Vault, true).Once()
+	mockPartialOutputJob(glacierMock, "jobId0", restorationContext.Vault, "1048576-1048580", []byte("hello")).Once()
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	glacierMock.AssertNotCalled(t, "InitiateJob", mock.Anything)
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", strings.Repeat("_", 1048576) + "hello")
+}
+
+func TestDownloadArchives_retrieve_again_when_job_output_has_expired(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 3496, // 1048800 on 5 min
+		archivesRetrievalMaxSize: utils.S_1MB * 2,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 10,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: nil,
+		archiveIterator: nil,
+	}
+
+	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 2097157);")
+	db.Close()
+
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-2097151", "jobId1").Once()
+	mockDescribeJob(glacierMock, "jobId1", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "0-1048799", []byte(strings.Repeat("_", 1048800))).Once()
+	glacierMock.On("GetJobOutput", &glacier.GetJobOutputInput{
+		AccountId: aws.String(awsutils.AccountId),
+		JobId:     aws.String("jobId1"),
+		VaultName: aws.String(restorationContext.Vault),
+		Range:     aws.String("1048800-2097151"),
+	}).Return(nil, errors.New("ResourceNotFoundException: The job ID was not found: jobId1")).Once()
+
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "2097152-2097156", "jobId2").Once()
+	mockDescribeJob(glacierMock, "jobId2", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId2", restorationContext.Vault, "0-4", []byte("hello")).Once()
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "1048576-2097151", "jobId3").Once()
+	mockDescribeJob(glacierMock, "jobId3", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId3", restorationContext.Vault, "0-1048575", []byte(strings.Repeat("_", 1048576))).Once()
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	assert.Contains(t, string(buffer.Bytes()), "Output of job jobId1 has expired, 1023.8K of archive archiveId1 will be retrieved again")
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", strings.Repeat("_", 2097152) + "hello")
+}
+
+func TestDownloadArchives_completed_job_found_at_startup_has_expired(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: utils.S_1MB,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 1,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: nil,
+		archiveIterator: nil,
+	}
+
+	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 5);")
+	db.Close()
+
+	awsutils.AddRetrievalJobAtStartup("archiveId1", "0-4", "jobId1", time.Now().Add(-25 * time.Hour))
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-4", "jobId2")
+	mockDescribeJob(glacierMock, "jobId2", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId2", restorationContext.Vault, "0-4", []byte("hello"))
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	glacierMock.AssertNumberOfCalls(t, "GetJobOutput", 1)
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", "hello")
+}
+
+func TestDownloadArchives_resume_interrupted_download(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: utils.S_1MB,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 1,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: nil,
+		archiveIterator: nil,
+	}
+
+	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 5);")
+	db.Close()
+
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-4", "jobId1")
+	mockDescribeJob(glacierMock, "jobId1", restorationContext.Vault, true)
+	params := &glacier.GetJobOutputInput{
+		AccountId: aws.String(awsutils.AccountId),
+		JobId:     aws.String("jobId1"),
+		VaultName: aws.String(restorationContext.Vault),
+		Range:     aws.String("0-4"),
+	}
+	glacierMock.On("GetJobOutput", params).Return(nil, awserr.New("ThrottlingException", "Rate exceeded", nil)).Once()
+	glacierMock.On("GetJobOutput", params).Return(&glacier.GetJobOutputOutput{Body: newReaderClosable(newInterruptedReader([]byte("he"), io.ErrUnexpectedEOF))}, nil).Once()
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "2-4", []byte("llo")).Once()
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	glacierMock.AssertNumberOfCalls(t, "GetJobOutput", 3)
+	assert.Contains(t, string(buffer.Bytes()), "Download of job jobId1 interrupted after 2B (unexpected EOF), resumed in")
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", "hello")
+}
+
+func TestDownloadArchives_download_range_with_parallel_streams(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	restorationContext.Options.DownloadStreams = 3
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 20000,
+		archivesRetrievalMaxSize: utils.S_1MB * 4,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 10,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: nil,
+		archiveIterator: nil,
+		checksumManifest: newChecksumManifest(restorationContext.DestinationDirPath),
+	}
+
+	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 3145728);")
+	db.Close()
+
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-3145727", "jobId1")
+	mockDescribeJob(glacierMock, "jobId1", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "0-1048575", []byte(strings.Repeat("a", 1048576))).Once()
+	glacierMock.On("GetJobOutput", &glacier.GetJobOutputInput{
+		AccountId: aws.String(awsutils.AccountId),
+		JobId:     aws.String("jobId1"),
+		VaultName: aws.String(restorationContext.Vault),
+		Range:     aws.String("1048576-2097151"),
+	}).Return(nil, awserr.New("ThrottlingException", "Rate exceeded", nil)).Once()
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "1048576-2097151", []byte(strings.Repeat("b", 1048576))).Once()
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "2097152-3145727", []byte(strings.Repeat("c", 1048576))).Once()
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	glacierMock.AssertNumberOfCalls(t, "GetJobOutput", 4)
+	content := strings.Repeat("a", 1048576) + strings.Repeat("b", 1048576) + strings.Repeat("c", 1048576)
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", content)
+	sha256Sum := sha256.Sum256([]byte(content))
+	assertFileContent(t, "../../testtmp/dest/rsg-manifest.sha256", hex.EncodeToString(sha256Sum[:]) + "  share/data/file1.txt\n")
+}
+
+func TestDownloadArchives_retrieve_again_only_missing_segment_when_job_output_has_expired(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	restorationContext.Options.DownloadStreams = 3
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 20000,
+		archivesRetrievalMaxSize: utils.S_1MB * 4,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 10,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: nil,
+		archiveIterator: nil,
+		checksumManifest: newChecksumManifest(restorationContext.DestinationDirPath),
+	}
+
+	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 3145728);")
+	db.Close()
+
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-3145727", "jobId1")
+	mockDescribeJob(glacierMock, "jobId1", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "0-1048575", []byte(strings.Repeat("a", 1048576))).Once()
+	glacierMock.On("GetJobOutput", &glacier.GetJobOutputInput{
+		AccountId: aws.String(awsutils.AccountId),
+		JobId:     aws.String("jobId1"),
+		VaultName: aws.String(restorationContext.Vault),
+		Range:     aws.String("1048576-2097151"),
+	}).Return(nil, errors.New("ResourceNotFoundException: The job ID was not found: jobId1")).Once()
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "2097152-3145727", []byte(strings.Repeat("c", 1048576))).Once()
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "1048576-2097151", "jobId2")
+	mockDescribeJob(glacierMock, "jobId2", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId2", restorationContext.Vault, "0-1048575", []byte(strings.Repeat("b", 1048576))).Once()
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	glacierMock.AssertNumberOfCalls(t, "GetJobOutput", 4)
+	assert.Contains(t, string(buffer.Bytes()), "Output of job jobId1 has expired, 1M of archive archiveId1 will be retrieved again")
+	content := strings.Repeat("a", 1048576) + strings.Repeat("b", 1048576) + strings.Repeat("c", 1048576)
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", content)
+	sha256Sum := sha256.Sum256([]byte(content))
+	assertFileContent(t, "../../testtmp/dest/rsg-manifest.sha256", hex.EncodeToString(sha256Sum[:]) + "  share/data/file1.txt\n")
+}
+
+func TestDownloadArchivePart_missing_ranges_of_failed_segments(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	restorationContext.Options.DownloadStreams = 3
+	archivePartRetrieve := &archivePartRetrieve{jobId: "jobId1", archiveId: "archiveId1", retrievedSize: 5 * utils.S_1MB,
+		archiveSize: 6 * utils.S_1MB, nextByteIndexToWrite: utils.S_1MB}
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "0-1048575", []byte(strings.Repeat("a", 1048576))).Once()
+	glacierMock.On("GetJobOutput", &glacier.GetJobOutputInput{
+		AccountId: aws.String(awsutils.AccountId),
+		JobId:     aws.String("jobId1"),
+		VaultName: aws.String(restorationContext.Vault),
+		Range:     aws.String("1048576-2097151"),
+	}).Return(nil, errors.New("AccessDeniedException")).Once()
+	glacierMock.On("GetJobOutput", &glacier.GetJobOutputInput{
+		AccountId: aws.String(awsutils.AccountId),
+		JobId:     aws.String("jobId1"),
+		VaultName: aws.String(restorationContext.Vault),
+		Range:     aws.String("2097152-3145727"),
+	}).Return(nil, errors.New("AccessDeniedException")).Once()
+
+	// When
+	sizeDownloaded, missingRanges, _, err := downloadArchivePart(restorationContext, archivePartRetrieve, 0, 3 * utils.S_1MB, nil)
+
+	// Then
+	assert.Equal(t, errors.New("AccessDeniedException"), err)
+	assert.Equal(t, uint64(utils.S_1MB), sizeDownloaded)
+	// failed segments are merged with the bytes of the part not downloaded, the completed segment is not missing
+	assert.Equal(t, []awsutils.ByteRange{{From: 2 * utils.S_1MB, Size: 4 * utils.S_1MB}}, missingRanges)
+	assert.Equal(t, uint64(utils.S_1MB), archivePartRetrieve.nextByteIndexToWrite)
+}
+
+func TestChecksumManifest_hash_download_resumed_from_previous_run(t *testing.T) {
+	// Given
+	CommonInitTest()
+	os.MkdirAll("../../testtmp/dest", 0700)
+	checksumManifest := newChecksumManifest("../../testtmp/dest")
+	content := []byte(strings.Repeat("a", 1048576) + strings.Repeat("b", 1048576))
+	ioutil.WriteFile("../../testtmp/dest/archiveId1", content[:1000], 0600)
+
+	// When
+	checksumManifest.hashWriter("archiveId1", 1000).Write(content[1000:1048576])
+	// part retrieved again from the MB boundary before its first byte not written
+	checksumManifest.hashWriter("archiveId1", 0).Write(content)
+	sha256Sum := checksumManifest.archiveSha256("archiveId1", uint64(len(content)))
+
+	// Then
+	expectedSha256Sum := sha256.Sum256(content)
+	assert.Equal(t, hex.EncodeToString(expectedSha256Sum[:]), sha256Sum)
+}
+
+func TestChecksumManifest_append_entries_of_restored_files(t *testing.T) {
+	// Given
+	CommonInitTest()
+	os.MkdirAll("../../testtmp/dest", 0700)
+	ioutil.WriteFile("../../testtmp/dest/" + checksumManifestJsonFileName,
+		[]byte("[{\"share\":\"share\",\"path\":\"data/old.txt\",\"size\":5,\"archiveId\":\"archiveId0\",\"sha256\":\"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824\"}]"), 0600)
+	checksumManifest := newChecksumManifest("../../testtmp/dest")
+
+	// When
+	checksumManifest.addFile("share/data/file2.txt", 5, "archiveId2", "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	checksumManifest.addFile("share/data/file1.txt", 0, "GlacierZeroSizeFile", emptyFileSha256())
+
+	// Then the manifests contain the files restored before the end of the restoration
+	assertFileContent(t, "../../testtmp/dest/rsg-manifest.sha256",
+		"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  share/data/old.txt\n" +
+		"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  share/data/file2.txt\n" +
+		emptyFileSha256() + "  share/data/file1.txt\n")
+	entries := []ChecksumManifestEntry{}
+	jsonContent, _ := ioutil.ReadFile("../../testtmp/dest/rsg-manifest.json")
+	assert.Nil(t, json.Unmarshal(jsonContent, &entries))
+	assert.Equal(t, []string{"data/old.txt", "data/file2.txt", "data/file1.txt"}, []string{entries[0].Path, entries[1].Path, entries[2].Path})
+	assert.Equal(t, "GlacierZeroSizeFile", entries[2].ArchiveId)
+}
+
+func TestIsTransientError(t *testing.T) {
+	assert.True(t, awsutils.IsTransientError(awserr.New("ThrottlingException", "Rate exceeded", nil)))
+	assert.True(t, awsutils.IsTransientError(awserr.NewRequestFailure(awserr.New("Unknown", "Bad gateway", nil), 502, "requestId")))
+	assert.True(t, awsutils.IsTransientError(io.ErrUnexpectedEOF))
+	assert.False(t, awsutils.IsTransientError(awserr.New("ResourceNotFoundException", "The job ID was not found", nil)))
+	assert.False(t, awsutils.IsTransientError(errors.New("disk full")))
+}
+
+func TestDownloadArchives_write_checksum_manifests(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: utils.S_1MB,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 1,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: nil,
+		archiveIterator: nil,
+		checksumManifest: newChecksumManifest(restorationContext.DestinationDirPath),
+	}
+
+	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId1', 5);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file2.txt', 'archiveId1', 5);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/empty.txt', 'GlacierZeroSizeFile', 0);")
+	db.Close()
+
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-4", "jobId1")
+	mockDescribeJob(glacierMock, "jobId1", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "0-4", []byte("hello"))
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	helloSha256 := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	emptySha256 := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	assertFileContent(t, "../../testtmp/dest/rsg-manifest.sha256",
+		emptySha256 + "  share/data/empty.txt\n" +
+		helloSha256 + "  share/data/file1.txt\n" +
+		helloSha256 + "  share/data/file2.txt\n")
+
+	entries := []ChecksumManifestEntry{}
+	jsonContent, _ := ioutil.ReadFile("../../testtmp/dest/rsg-manifest.json")
+	json.Unmarshal(jsonContent, &entries)
+	assert.Equal(t, []ChecksumManifestEntry{
+		{Share: "share", Path: "data/empty.txt", Size: 0, ArchiveId: "GlacierZeroSizeFile", Sha256: emptySha256},
+		{Share: "share", Path: "data/file1.txt", Size: 5, ArchiveId: "archiveId1", Sha256: helloSha256},
+		{Share: "share", Path: "data/file2.txt", Size: 5, ArchiveId: "archiveId1", Sha256: helloSha256},
+	}, entries)
+}
+
+func TestDownloadArchives_restore_metadata_of_mapping_file(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: utils.S_1MB,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 1,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: nil,
+		archiveIterator: nil,
+	}
+
+	db, _ := sql.Open("sqlite3", restorationContext.GetMappingFilePath())
+	db.Exec("CREATE TABLE `file_info_tb` (`key` INTEGER PRIMARY KEY AUTOINCREMENT, `shareName` TEXT, `basePath` TEXT,`archiveID` TEXT, fileSize INTEGER, mtime INTEGER, mode INTEGER);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize, mtime, mode) VALUES ('share', 'data/file1.txt', 'archiveId1', 5, 1262304000, 33188);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize, mtime, mode) VALUES ('share', 'data/file2.txt', 'archiveId1', 5, 1293840000, 33152);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize, mtime, mode) VALUES ('share', 'data/empty.txt', 'GlacierZeroSizeFile', 0, 1325376000, 33216);")
+	db.Close()
+
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-4", "jobId1")
+	mockDescribeJob(glacierMock, "jobId1", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "0-4", []byte("hello"))
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	assertFileMetadata(t, "../../testtmp/dest/share/data/file1.txt", time.Unix(1262304000, 0), 0644)
+	assertFileMetadata(t, "../../testtmp/dest/share/data/file2.txt", time.Unix(1293840000, 0), 0600)
+	assertFileMetadata(t, "../../testtmp/dest/share/data/empty.txt", time.Unix(1325376000, 0), 0700)
+}
+
 func assertFileContent(t *testing.T, filePath, expected string) {
 	data, _ := ioutil.ReadFile(filePath)
 	assert.Equal(t, expected, string(data))
 }
 
+func assertFileMetadata(t *testing.T, filePath string, modificationTime time.Time, mode os.FileMode) {
+	stat, err := os.Stat(filePath)
+	assert.Nil(t, err)
+	assert.Equal(t, modificationTime.Unix(), stat.ModTime().Unix())
+	assert.Equal(t, mode, stat.Mode().Perm())
+}
+
 func assertFileDoestntExist(t *testing.T, filePath string) {
 	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
 		assert.Fail(t, "path should not exist")
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Schedule of downloads given by --max-rate and --schedule. Rules of the schedule are separated by ';', each rule is
+// "[days] [HH:MM-HH:MM]=value":
+// - days: mon-fri, sat,sun, weekdays or weekend, all days if not given
+// - hours: window of the day, it spans midnight if it ends before it starts, all day if not given
+// - value: a rate by second (ex: 2M), unlimited, or no-jobs (no retrieval job is started)
+// The download rate is the one of the first matching rule with a rate, else --max-rate.
+// Example: "20:00-07:00=unlimited;weekdays=no-jobs" with --max-rate 2M
+
+const unlimitedRate = "unlimited"
+const noJobs = "no-jobs"
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+type scheduleRule struct {
+	days   [7]bool // indexed by time.Weekday
+	from   int     // minute of the day
+	to     int     // minute of the day, window is the whole day if to == from
+	noJobs bool
+	rate   uint64 // bytes by second, unlimited if 0
+}
+
+type downloadSchedule struct {
+	maxRate uint64 // bytes by second out of rules, unlimited if 0
+	rules   []scheduleRule
+}
+
+func parseDownloadSchedule(maxRate uint64, schedule string) (*downloadSchedule, error) {
+	downloadSchedule := &downloadSchedule{maxRate: maxRate}
+	for _, ruleString := range strings.Split(schedule, ";") {
+		if strings.TrimSpace(ruleString) == "" {
+			continue
+		}
+		rule, err := parseScheduleRule(strings.TrimSpace(ruleString))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid schedule rule \"%s\": %v", ruleString, err))
+		}
+		downloadSchedule.rules = append(downloadSchedule.rules, rule)
+	}
+	return downloadSchedule, nil
+}
+
+func parseScheduleRule(ruleString string) (scheduleRule, error) {
+	rule := scheduleRule{}
+	parts := strings.SplitN(ruleString, "=", 2)
+	if len(parts) != 2 {
+		return rule, errors.New("value is missing (rate, unlimited or no-jobs)")
+	}
+	switch value := strings.TrimSpace(parts[1]); value {
+	case noJobs:
+		rule.noJobs = true
+	case unlimitedRate:
+	default:
+		rate, err := bytefmt.ToBytes(strings.TrimSuffix(value, "/s"))
+		if err != nil {
+			return rule, err
+		}
+		rule.rate = rate
+	}
+	daysGiven := false
+	for _, field := range strings.Fields(parts[0]) {
+		var err error
+		if strings.Contains(field, ":") {
+			rule.from, rule.to, err = parseHoursWindow(field)
+		} else {
+			daysGiven = true
+			err = parseDays(field, &rule.days)
+		}
+		if err != nil {
+			return rule, err
+		}
+	}
+	if !daysGiven {
+		for i := range rule.days {
+			rule.days[i] = true
+		}
+	}
+	return rule, nil
+}
+
+func parseHoursWindow(window string) (int, int, error) {
+	bounds := strings.Split(window, "-")
+	if len(bounds) != 2 {
+		return 0, 0, errors.New(fmt.Sprintf("invalid hours %s (ex: 20:00-07:00)", window))
+	}
+	from, err := parseMinuteOfDay(bounds[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := parseMinuteOfDay(bounds[1])
+	return from, to, err
+}
+
+func parseMinuteOfDay(hour string) (int, error) {
+	parsed, err := time.Parse("15:04", hour)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid hour %s (ex: 07:30)", hour))
+	}
+	return parsed.Hour() * 60 + parsed.Minute(), nil
+}
+
+// Days separated by ',', ranges of days (ex: fri-mon), weekdays or weekend
+func parseDays(daysString string, days *[7]bool) error {
+	for _, dayRange := range strings.Split(strings.ToLower(daysString), ",") {
+		switch dayRange {
+		case "weekdays":
+			dayRange = "mon-fri"
+		case "weekend":
+			dayRange = "sat-sun"
+		}
+		bounds := strings.Split(dayRange, "-")
+		first, err := parseWeekday(bounds[0])
+		if err != nil {
+			return err
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parseWeekday(bounds[1]); err != nil {
+				return err
+			}
+		} else if len(bounds) > 2 {
+			return errors.New(fmt.Sprintf("invalid days %s (ex: mon-fri)", dayRange))
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseWeekday(day string) (int, error) {
+	for i, name := range weekdayNames {
+		if day == name {
+			return i, nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("invalid day %s (%s)", day, strings.Join(weekdayNames, ", ")))
+}
+
+// A window spanning midnight belongs to the day it starts: after midnight the day before is checked
+func (rule scheduleRule) matches(date time.Time) bool {
+	minute := date.Hour() * 60 + date.Minute()
+	switch {
+	case rule.from == rule.to:
+		return rule.days[date.Weekday()]
+	case rule.from < rule.to:
+		return rule.days[date.Weekday()] && minute >= rule.from && minute < rule.to
+	case minute >= rule.from:
+		return rule.days[date.Weekday()]
+	case minute < rule.to:
+		return rule.days[(date.Weekday() + 6) % 7]
+	}
+	return false
+}
+
+// Download rate at the date, unlimited if 0
+func (schedule *downloadSchedule) rateAt(date time.Time) uint64 {
+	if schedule == nil {
+		return 0
+	}
+	for _, rule := range schedule.rules {
+		if !rule.noJobs && rule.matches(date) {
+			return rule.rate
+		}
+	}
+	return schedule.maxRate
+}
+
+func (schedule *downloadSchedule) jobsAllowedAt(date time.Time) bool {
+	if schedule == nil {
+		return true
+	}
+	for _, rule := range schedule.rules {
+		if rule.noJobs && rule.matches(date) {
+			return false
+		}
+	}
+	return true
+}
+
+func describeRate(rate uint64) string {
+	if rate == 0 {
+		return unlimitedRate
+	}
+	return bytefmt.ByteSize(rate) + "/s"
+}
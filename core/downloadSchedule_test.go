@@ -0,0 +1,95 @@
+package core
+
+import (
+	"testing"
+	"time"
+	"rsg/awsutils"
+	"github.com/stretchr/testify/assert"
+)
+
+// 2016-10-17 is a monday
+func scheduleDate(day, hour, minute int) time.Time {
+	return time.Date(2016, 10, 16 + day, hour, minute, 0, 0, time.Local)
+}
+
+func TestParseDownloadSchedule_rate_by_hours(t *testing.T) {
+	// Given
+	schedule, err := parseDownloadSchedule(2 * 1024 * 1024, "20:00-07:00=unlimited")
+
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), schedule.rateAt(scheduleDate(1, 22, 0)))
+	assert.Equal(t, uint64(0), schedule.rateAt(scheduleDate(2, 6, 59)))
+	assert.Equal(t, uint64(2 * 1024 * 1024), schedule.rateAt(scheduleDate(2, 7, 0)))
+	assert.Equal(t, uint64(2 * 1024 * 1024), schedule.rateAt(scheduleDate(2, 12, 0)))
+}
+
+func TestParseDownloadSchedule_no_jobs_on_weekdays(t *testing.T) {
+	// Given
+	schedule, err := parseDownloadSchedule(0, "weekdays=no-jobs; sat 09:00-18:00=512K/s")
+
+	// Then
+	assert.Nil(t, err)
+	assert.False(t, schedule.jobsAllowedAt(scheduleDate(1, 10, 0)))
+	assert.False(t, schedule.jobsAllowedAt(scheduleDate(5, 23, 0)))
+	assert.True(t, schedule.jobsAllowedAt(scheduleDate(6, 10, 0)))
+	assert.True(t, schedule.jobsAllowedAt(scheduleDate(0, 10, 0)))
+	assert.Equal(t, uint64(0), schedule.rateAt(scheduleDate(1, 10, 0)))
+	assert.Equal(t, uint64(512 * 1024), schedule.rateAt(scheduleDate(6, 10, 0)))
+	assert.Equal(t, uint64(0), schedule.rateAt(scheduleDate(6, 18, 0)))
+}
+
+func TestParseDownloadSchedule_window_spanning_midnight_on_days(t *testing.T) {
+	// Given
+	schedule, err := parseDownloadSchedule(0, "fri,sat 22:00-06:00=no-jobs")
+
+	// Then
+	assert.Nil(t, err)
+	assert.False(t, schedule.jobsAllowedAt(scheduleDate(5, 23, 0)))
+	assert.False(t, schedule.jobsAllowedAt(scheduleDate(6, 3, 0)))
+	assert.False(t, schedule.jobsAllowedAt(scheduleDate(7, 5, 59)))
+	assert.True(t, schedule.jobsAllowedAt(scheduleDate(5, 3, 0)))
+	assert.True(t, schedule.jobsAllowedAt(scheduleDate(7, 22, 0)))
+	assert.True(t, schedule.jobsAllowedAt(scheduleDate(8, 3, 0)))
+}
+
+func TestParseDownloadSchedule_invalid_rule(t *testing.T) {
+	// When
+	_, errWithoutValue := parseDownloadSchedule(0, "20:00-07:00")
+	_, errWithInvalidDay := parseDownloadSchedule(0, "monday=no-jobs")
+	_, errWithInvalidHour := parseDownloadSchedule(0, "20:00-25:00=1M")
+
+	// Then
+	assert.NotNil(t, errWithoutValue)
+	assert.NotNil(t, errWithInvalidDay)
+	assert.NotNil(t, errWithInvalidHour)
+}
+
+func TestDownloadSchedule_nil(t *testing.T) {
+	// Given
+	var schedule *downloadSchedule
+
+	// Then
+	assert.Equal(t, uint64(0), schedule.rateAt(time.Now()))
+	assert.True(t, schedule.jobsAllowedAt(time.Now()))
+}
+
+func TestRateLimiter_follows_schedule_at_each_wait(t *testing.T) {
+	// Given
+	limiter := &awsutils.RateLimiter{}
+	rate := uint64(1024)
+	limiter.SetRateSchedule(func(time.Time) uint64 {
+		return rate
+	})
+	rateBefore := limiter.Rate()
+
+	// When
+	rate = 0
+	start := time.Now()
+	limiter.Wait(10 * 1024 * 1024)
+
+	// Then
+	assert.Equal(t, uint64(1024), rateBefore)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, uint64(0), limiter.Rate())
+}
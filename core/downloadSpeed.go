@@ -0,0 +1,203 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+	"rsg/inputs"
+	"rsg/outputs"
+	"rsg/speedtest"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// The download speed sizes the retrieval jobs, it is estimated by the first estimator which succeeds:
+// - the speed given by --speed
+// - the median of the last speeds measured by downloads of job outputs in the region, kept in the rsg directory
+// - a download of the url given by --speed-test-url
+// - else the user is asked for it
+// A measure is appended to the history when the download of a job output is finished, the history is compacted to its
+// last measures when downloads start.
+
+const throughputHistoryFileName = "throughput-history.jsonl"
+
+// number of measures kept in the history, and used by the estimate
+const throughputHistoryMaxSize = 100
+const throughputEstimateMeasures = 10
+
+// measures of downloads smaller than this are not kept, they are too short to be reliable
+const throughputMinMeasuredSize = utils.S_1MB
+
+// path of the history, in rsg directory if empty
+var throughputHistoryFilePath = ""
+
+type throughputMeasure struct {
+	Date          time.Time
+	Region        string
+	Size          uint64
+	BytesBySecond uint64
+}
+
+type speedEstimator interface {
+	name() string
+	// speed in bytes by second, 0 if it cannot be estimated
+	estimate() (uint64, error)
+}
+
+type optionSpeedEstimator struct {
+	speed uint64
+}
+
+func (estimator optionSpeedEstimator) name() string {
+	return "--speed"
+}
+
+func (estimator optionSpeedEstimator) estimate() (uint64, error) {
+	return estimator.speed, nil
+}
+
+type urlSpeedEstimator struct {
+	url string
+}
+
+func (estimator urlSpeedEstimator) name() string {
+	return "speed test on " + estimator.url
+}
+
+func (estimator urlSpeedEstimator) estimate() (uint64, error) {
+	if estimator.url == "" {
+		return 0, nil
+	}
+	return speedtest.SpeedTest(estimator.url)
+}
+
+type historySpeedEstimator struct {
+	region string
+}
+
+func (estimator historySpeedEstimator) name() string {
+	return "speeds of previous downloads"
+}
+
+func (estimator historySpeedEstimator) estimate() (uint64, error) {
+	return estimateThroughput(readThroughputHistory(), estimator.region), nil
+}
+
+func speedEstimators(restorationContext *RestorationContext) []speedEstimator {
+	return []speedEstimator{optionSpeedEstimator{restorationContext.Options.Speed},
+		historySpeedEstimator{restorationContext.Region},
+		urlSpeedEstimator{restorationContext.Options.SpeedTestUrl}}
+}
+
+func estimateOrSelectDownloadSpeed(estimators []speedEstimator) uint64 {
+	for _, estimator := range estimators {
+		downloadSpeed, err := estimator.estimate()
+		if err != nil {
+			outputs.Printfln(outputs.Error, "Cannot estimate download speed with %s: %v", estimator.name(), err)
+		} else if downloadSpeed > 0 {
+			outputs.Printfln(outputs.OptionalInfo, "Download speed used : %v (%s)", bytefmt.ByteSize(downloadSpeed), estimator.name())
+			return downloadSpeed
+		}
+	}
+	downloadSpeed := uint64(0)
+	for downloadSpeed == 0 {
+		var err error
+		downloadSpeed, err = bytefmt.ToBytes(inputs.QueryString("Select your download speed by second (ex 10K, 256K, 1M, 10M):"))
+		if err != nil {
+			outputs.Printfln(outputs.Error, "%v", err)
+		}
+	}
+	outputs.Printfln(outputs.OptionalInfo, "Download speed used : %v", bytefmt.ByteSize(downloadSpeed))
+	return downloadSpeed
+}
+
+// Median of the last measures of the region, or of all regions if the region has none, 0 without measure
+func estimateThroughput(measures []throughputMeasure, region string) uint64 {
+	speeds := lastThroughputs(measures, region)
+	if len(speeds) == 0 {
+		speeds = lastThroughputs(measures, "")
+	}
+	if len(speeds) == 0 {
+		return 0
+	}
+	sort.Sort(uint64Slice(speeds))
+	return speeds[len(speeds) / 2]
+}
+
+// Speeds of the last measures of the region, of all regions if region is empty
+func lastThroughputs(measures []throughputMeasure, region string) []uint64 {
+	speeds := []uint64{}
+	for i := len(measures) - 1; i >= 0 && len(speeds) < throughputEstimateMeasures; i-- {
+		if region == "" || measures[i].Region == region {
+			speeds = append(speeds, measures[i].BytesBySecond)
+		}
+	}
+	return speeds
+}
+
+type uint64Slice []uint64
+
+func (slice uint64Slice) Len() int           { return len(slice) }
+func (slice uint64Slice) Less(i, j int) bool { return slice[i] < slice[j] }
+func (slice uint64Slice) Swap(i, j int)      { slice[i], slice[j] = slice[j], slice[i] }
+
+func getThroughputHistoryFilePath() string {
+	if throughputHistoryFilePath != "" {
+		return throughputHistoryFilePath
+	}
+	return getRsgDirPath() + "/" + throughputHistoryFileName
+}
+
+// Measures of the history, oldest first
+func readThroughputHistory() []throughputMeasure {
+	measures := []throughputMeasure{}
+	content, err := ioutil.ReadFile(getThroughputHistoryFilePath())
+	if err != nil {
+		return measures
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for decoder.More() {
+		measure := throughputMeasure{}
+		if err := decoder.Decode(&measure); err != nil {
+			outputs.Printfln(outputs.Warning, "Throughput history %s is invalid, it is ignored: %v", getThroughputHistoryFilePath(), err)
+			return []throughputMeasure{}
+		}
+		measures = append(measures, measure)
+	}
+	return measures
+}
+
+// Appends the measure to the history
+func recordThroughput(region string, size uint64, duration time.Duration) {
+	if size < throughputMinMeasuredSize || duration <= 0 {
+		return
+	}
+	line, err := json.Marshal(throughputMeasure{Date: time.Now().UTC(), Region: region, Size: size,
+		BytesBySecond: uint64(float64(size) / duration.Seconds())})
+	utils.ExitIfError(err)
+	history, err := os.OpenFile(getThroughputHistoryFilePath(), os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0600)
+	utils.ExitIfError(err)
+	defer utils.CheckingClose(history, &err)
+	_, err = history.Write(append(line, '\n'))
+	utils.ExitIfError(err)
+}
+
+// Keeps only the last measures of the history
+func compactThroughputHistory() {
+	measures := readThroughputHistory()
+	if len(measures) <= throughputHistoryMaxSize {
+		return
+	}
+	content := new(bytes.Buffer)
+	for _, measure := range measures[len(measures) - throughputHistoryMaxSize:] {
+		line, err := json.Marshal(measure)
+		utils.ExitIfError(err)
+		content.Write(append(line, '\n'))
+	}
+	tempFilePath := getThroughputHistoryFilePath() + ".tmp"
+	utils.ExitIfError(ioutil.WriteFile(tempFilePath, content.Bytes(), 0600))
+	utils.ExitIfError(os.Rename(tempFilePath, getThroughputHistoryFilePath()))
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"rsg/consts"
+	"rsg/inputs"
+	"rsg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingSpeedEstimator struct{}
+
+func (estimator failingSpeedEstimator) name() string {
+	return "failing"
+}
+
+func (estimator failingSpeedEstimator) estimate() (uint64, error) {
+	return 0, errors.New("unreachable")
+}
+
+func TestEstimateThroughput_median_of_region(t *testing.T) {
+	// Given
+	measures := []throughputMeasure{
+		{Region: "region", BytesBySecond: 100},
+		{Region: "other-region", BytesBySecond: 1000},
+		{Region: "region", BytesBySecond: 300},
+		{Region: "region", BytesBySecond: 200},
+	}
+
+	// When
+	speed := estimateThroughput(measures, "region")
+	speedOfUnknownRegion := estimateThroughput(measures, "unknown-region")
+	speedWithoutMeasure := estimateThroughput([]throughputMeasure{}, "region")
+
+	// Then
+	assert.Equal(t, uint64(200), speed)
+	assert.Equal(t, uint64(300), speedOfUnknownRegion)
+	assert.Equal(t, uint64(0), speedWithoutMeasure)
+}
+
+func TestRecordThroughput_append_measure(t *testing.T) {
+	// Given
+	CommonInitTest()
+	recordThroughput("region", utils.S_1MB, time.Second)
+
+	// When
+	recordThroughput("region", 2 * utils.S_1MB, time.Second)
+	recordThroughput("region", utils.S_1MB - 1, time.Second)
+
+	// Then
+	measures := readThroughputHistory()
+	assert.Equal(t, 2, len(measures))
+	assert.Equal(t, uint64(2 * utils.S_1MB), measures[1].BytesBySecond)
+}
+
+func TestCompactThroughputHistory_keep_last_measures(t *testing.T) {
+	// Given
+	CommonInitTest()
+	for i := 0; i < throughputHistoryMaxSize; i++ {
+		recordThroughput("region", utils.S_1MB, time.Second)
+	}
+	recordThroughput("region", 2 * utils.S_1MB, time.Second)
+
+	// When
+	compactThroughputHistory()
+
+	// Then
+	measures := readThroughputHistory()
+	assert.Equal(t, throughputHistoryMaxSize, len(measures))
+	assert.Equal(t, uint64(2 * utils.S_1MB), measures[len(measures) - 1].BytesBySecond)
+}
+
+func TestEstimateOrSelectDownloadSpeed_first_estimate(t *testing.T) {
+	// Given
+	CommonInitTest()
+	_, restorationContext := InitTestWithGlacier()
+	restorationContext.Options.Speed = 5000
+	recordThroughput("region", utils.S_1MB, time.Second)
+
+	// When
+	speedOfOption := estimateOrSelectDownloadSpeed(speedEstimators(restorationContext))
+	restorationContext.Options.Speed = 0
+	speedOfHistory := estimateOrSelectDownloadSpeed(append([]speedEstimator{failingSpeedEstimator{}}, speedEstimators(restorationContext)...))
+
+	// Then
+	assert.Equal(t, uint64(5000), speedOfOption)
+	assert.Equal(t, uint64(utils.S_1MB), speedOfHistory)
+}
+
+func TestEstimateOrSelectDownloadSpeed_test_url(t *testing.T) {
+	// Given
+	CommonInitTest()
+	_, restorationContext := InitTestWithGlacier()
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(strings.Repeat("_", 1024)))
+	}))
+	defer server.Close()
+	restorationContext.Options.SpeedTestUrl = server.URL
+
+	// When
+	speed := estimateOrSelectDownloadSpeed(speedEstimators(restorationContext))
+
+	// Then
+	assert.True(t, speed > 0)
+}
+
+func TestEstimateOrSelectDownloadSpeed_history_before_test_url(t *testing.T) {
+	// Given
+	CommonInitTest()
+	_, restorationContext := InitTestWithGlacier()
+	recordThroughput("region", utils.S_1MB, time.Second)
+	urlDownloaded := false
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		urlDownloaded = true
+	}))
+	defer server.Close()
+	restorationContext.Options.SpeedTestUrl = server.URL
+
+	// When
+	speed := estimateOrSelectDownloadSpeed(speedEstimators(restorationContext))
+
+	// Then
+	assert.Equal(t, uint64(utils.S_1MB), speed)
+	assert.False(t, urlDownloaded)
+}
+
+func TestEstimateOrSelectDownloadSpeed_selected_by_user(t *testing.T) {
+	// Given
+	CommonInitTest()
+	_, restorationContext := InitTestWithGlacier()
+	inputs.StdinReader = bufio.NewReader(bytes.NewReader([]byte("fast" + consts.LINE_BREAK + "2M" + consts.LINE_BREAK)))
+
+	// When
+	speed := estimateOrSelectDownloadSpeed(speedEstimators(restorationContext))
+
+	// Then
+	assert.Equal(t, uint64(2 * utils.S_1MB), speed)
+}
@@ -0,0 +1,24 @@
+package core
+
+import (
+	"os"
+	"rsg/utils"
+)
+
+// Apply metadata stored in the mapping file (modification time, creation time, mode) to restored files.
+// Creation time cannot be set on restored files, it's used as modification time if there is no mtime column.
+
+func applyFileMetadata(filePath string, mappingEntry *MappingEntry) {
+	modificationTime := mappingEntry.Mtime
+	if modificationTime == nil {
+		modificationTime = mappingEntry.Ctime
+	}
+	if modificationTime != nil {
+		err := os.Chtimes(filePath, *modificationTime, *modificationTime)
+		utils.ExitIfError(err)
+	}
+	if mappingEntry.Mode != nil {
+		err := os.Chmod(filePath, *mappingEntry.Mode)
+		utils.ExitIfError(err)
+	}
+}
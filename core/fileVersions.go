@@ -0,0 +1,74 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Versions of files: a path stored in several rows of the mapping file with distinct archive ids has several
+// versions, ordered by key (rows are appended by each backup).
+// Version selection is "latest", "oldest" or the key of a version (other paths use their latest version).
+
+const (
+	LatestVersion = "latest"
+	OldestVersion = "oldest"
+)
+
+// Key of the selected version, versions are ordered by key. A key of a version of another path selects the latest
+// version: the key must be checked by checkVersionKey first.
+func selectVersionKey(versions []*MappingEntry, version string) int64 {
+	if len(versions) == 0 {
+		return 0
+	}
+	switch version {
+	case OldestVersion:
+		return versions[0].Key
+	case LatestVersion:
+		return versions[len(versions) - 1].Key
+	}
+	key, _ := strconv.ParseInt(version, 10, 64)
+	for _, mappingEntry := range versions {
+		if mappingEntry.Key == key {
+			return key
+		}
+	}
+	return versions[len(versions) - 1].Key
+}
+
+// A key selection must be the key of a version of one of the paths with several versions
+func checkVersionKey(versionsByPath map[string][]*MappingEntry, version string) error {
+	if version == "" || version == LatestVersion || version == OldestVersion {
+		return nil
+	}
+	key, _ := strconv.ParseInt(version, 10, 64)
+	for _, versions := range versionsByPath {
+		for _, mappingEntry := range versions {
+			if mappingEntry.Key == key {
+				return nil
+			}
+		}
+	}
+	return errors.New(fmt.Sprintf("Version %s is not the key of a version of files stored several times (use find to list versions)", version))
+}
+
+// Path of a version restored side by side with other versions, the key is inserted before the extension
+func versionPath(path string, key int64) string {
+	extension := filepath.Ext(path)
+	if extension == filepath.Base(path) {
+		extension = ""
+	}
+	return strings.TrimSuffix(path, extension) + ".v" + strconv.FormatInt(key, 10) + extension
+}
+
+// Position (from 1) of the entry in its versions, 0 if not found
+func versionIndex(versions []*MappingEntry, mappingEntry *MappingEntry) int {
+	for i, version := range versions {
+		if version.Key == mappingEntry.Key {
+			return i + 1
+		}
+	}
+	return 0
+}
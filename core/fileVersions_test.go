@@ -0,0 +1,187 @@
+package core
+
+import (
+	"testing"
+	"rsg/awsutils"
+	"rsg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func mappingStoresWithVersionsForTest(t *testing.T) map[string]MappingStore {
+	CommonInitTest()
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", "../../testtmp/cache/mapping.sqllite")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId3', 6);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId4', 7);")
+	db.Close()
+	return map[string]MappingStore{
+		"sqlite": InitMappingStore("../../testtmp/cache/mapping.sqllite"),
+		"memory": newMemoryMappingStore(
+			&MappingEntry{ShareName: "share", BasePath: "data/file1.txt", ArchiveId: "archiveId1", FileSize: 5},
+			&MappingEntry{ShareName: "share", BasePath: "data/file2.txt", ArchiveId: "archiveId2", FileSize: 12},
+			&MappingEntry{ShareName: "share", BasePath: "data/file1.txt", ArchiveId: "archiveId3", FileSize: 6},
+			&MappingEntry{ShareName: "share", BasePath: "data/file1.txt", ArchiveId: "archiveId4", FileSize: 7}),
+	}
+}
+
+func archivesOfStore(t *testing.T, mappingStore MappingStore, filter MappingFilter) []awsutils.Archive {
+	archiveIterator, err := mappingStore.Archives(filter)
+	assert.Nil(t, err)
+	defer archiveIterator.Close()
+	archives := []awsutils.Archive{}
+	for archiveIterator.Next() {
+		archives = append(archives, archiveIterator.Archive())
+	}
+	return archives
+}
+
+func TestMappingStore_versions(t *testing.T) {
+	for name, mappingStore := range mappingStoresWithVersionsForTest(t) {
+		// When
+		versionsByPath, err := mappingStore.Versions(MappingFilter{})
+		noVersionsByPath, noVersionsErr := mappingStore.Versions(MappingFilter{Patterns: []string{"data/file2.txt"}})
+
+		// Then
+		assert.Nil(t, err, name)
+		assert.Nil(t, noVersionsErr, name)
+		assert.Equal(t, 1, len(versionsByPath), name)
+		keys := []int64{}
+		for _, mappingEntry := range versionsByPath["share/data/file1.txt"] {
+			keys = append(keys, mappingEntry.Key)
+		}
+		assert.Equal(t, []int64{1, 3, 4}, keys, name)
+		assert.Equal(t, 0, len(noVersionsByPath), name)
+		mappingStore.Close()
+	}
+}
+
+func TestMappingStore_archives_of_version(t *testing.T) {
+	for name, mappingStore := range mappingStoresWithVersionsForTest(t) {
+		// When
+		latest := archivesOfStore(t, mappingStore, MappingFilter{Version: LatestVersion})
+		oldest := archivesOfStore(t, mappingStore, MappingFilter{Version: OldestVersion})
+		key3 := archivesOfStore(t, mappingStore, MappingFilter{Version: "3"})
+		all := archivesOfStore(t, mappingStore, MappingFilter{})
+
+		// Then
+		assert.Equal(t, []awsutils.Archive{{ArchiveId: "archiveId2", Size: 12}, {ArchiveId: "archiveId4", Size: 7}}, latest, name)
+		assert.Equal(t, []awsutils.Archive{{ArchiveId: "archiveId1", Size: 5}, {ArchiveId: "archiveId2", Size: 12}}, oldest, name)
+		assert.Equal(t, []awsutils.Archive{{ArchiveId: "archiveId2", Size: 12}, {ArchiveId: "archiveId3", Size: 6}}, key3, name)
+		assert.Equal(t, 4, len(all), name)
+		mappingStore.Close()
+	}
+}
+
+func TestVersionPath(t *testing.T) {
+	assert.Equal(t, "share/data/file1.v3.txt", versionPath("share/data/file1.txt", 3))
+	assert.Equal(t, "share/data/file1.v3", versionPath("share/data/file1", 3))
+	assert.Equal(t, "share/data/.bashrc.v3", versionPath("share/data/.bashrc", 3))
+	assert.Equal(t, "share/data.d/file1.v3", versionPath("share/data.d/file1", 3))
+}
+
+func TestSelectVersionKey(t *testing.T) {
+	versions := []*MappingEntry{{Key: 1}, {Key: 3}, {Key: 4}}
+	assert.Equal(t, int64(4), selectVersionKey(versions, LatestVersion))
+	assert.Equal(t, int64(1), selectVersionKey(versions, OldestVersion))
+	assert.Equal(t, int64(3), selectVersionKey(versions, "3"))
+	assert.Equal(t, int64(4), selectVersionKey(versions, "2"))
+}
+
+func TestCheckVersionKey(t *testing.T) {
+	versionsByPath := map[string][]*MappingEntry{"share/data/file1.txt": {{Key: 1}, {Key: 3}, {Key: 4}}}
+	assert.Nil(t, checkVersionKey(versionsByPath, LatestVersion))
+	assert.Nil(t, checkVersionKey(versionsByPath, OldestVersion))
+	assert.Nil(t, checkVersionKey(versionsByPath, "3"))
+	assert.Equal(t, "Version 2 is not the key of a version of files stored several times (use find to list versions)",
+		checkVersionKey(versionsByPath, "2").Error())
+}
+
+func initDownloadVersionsTest() (*GlacierMock, DownloadContext) {
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: utils.S_1MB,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 1,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: newMemoryMappingStore(
+			&MappingEntry{ShareName: "share", BasePath: "data/file1.txt", ArchiveId: "archiveId1", FileSize: 5},
+			&MappingEntry{ShareName: "share", BasePath: "data/file1.txt", ArchiveId: "archiveId2", FileSize: 6}),
+		archiveIterator: nil,
+	}
+	return glacierMock, downloadContext
+}
+
+func TestDownloadArchives_restore_latest_version(t *testing.T) {
+	// Given
+	glacierMock, downloadContext := initDownloadVersionsTest()
+	mockStartPartialRetrieveJob(glacierMock, downloadContext.restorationContext.Vault, "archiveId2", "0-5", "jobId2")
+	mockDescribeJob(glacierMock, "jobId2", downloadContext.restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId2", downloadContext.restorationContext.Vault, "0-5", []byte("hello2"))
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", "hello2")
+	assert.False(t, utils.Exists("../../testtmp/dest/archiveId1"))
+}
+
+func TestDownloadArchives_restore_oldest_version(t *testing.T) {
+	// Given
+	glacierMock, downloadContext := initDownloadVersionsTest()
+	downloadContext.restorationContext.Options.FileVersion = OldestVersion
+	mockStartPartialRetrieveJob(glacierMock, downloadContext.restorationContext.Vault, "archiveId1", "0-4", "jobId1")
+	mockDescribeJob(glacierMock, "jobId1", downloadContext.restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId1", downloadContext.restorationContext.Vault, "0-4", []byte("hello"))
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", "hello")
+}
+
+func TestDownloadArchives_restore_all_versions_side_by_side(t *testing.T) {
+	// Given
+	glacierMock, downloadContext := initDownloadVersionsTest()
+	downloadContext.restorationContext.Options.AllVersions = true
+	downloadContext.archivePartRetrievalListMaxSize = 2
+	mockStartPartialRetrieveJob(glacierMock, downloadContext.restorationContext.Vault, "archiveId1", "0-4", "jobId1")
+	mockDescribeJob(glacierMock, "jobId1", downloadContext.restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId1", downloadContext.restorationContext.Vault, "0-4", []byte("hello"))
+	mockStartPartialRetrieveJob(glacierMock, downloadContext.restorationContext.Vault, "archiveId2", "0-5", "jobId2")
+	mockDescribeJob(glacierMock, "jobId2", downloadContext.restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId2", downloadContext.restorationContext.Vault, "0-5", []byte("hello2"))
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.v1.txt", "hello")
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.v2.txt", "hello2")
+	assert.False(t, utils.Exists("../../testtmp/dest/share/data/file1.txt"))
+}
+
+func TestFindFiles_list_versions(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := DefaultRestorationContext(nil)
+	restorationContext.DestinationDirPath = ""
+	restorationContext.Options.CommandArgs = []string{"file1"}
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", restorationContext.GetMappingFilePath())
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file1.txt', 'archiveId3', 6);")
+	db.Close()
+	buffer.Reset()
+
+	// When
+	FindFiles(restorationContext)
+
+	// Then
+	assert.Equal(t, "share\tdata/file1.txt\t5B\tarchiveId1\t-\t1/2 (key 1)\n" +
+		"share\tdata/file1.txt\t6B\tarchiveId3\t-\t2/2 (key 3)\n" +
+		"2 file(s) found, total size: 11B\n", string(buffer.Bytes()))
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"rsg/outputs"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Find files in the mapping file with search terms and facets (extensions, shares, size range).
+// Prints share, path, size, archive id, if the file has been restored in the destination directory and the version of
+// the file if the path has several versions.
+
+const (
+	restoredLocally = "restored"
+	notRestoredLocally = "not restored"
+	unknownRestoredLocally = "-" // no destination directory
+)
+
+func FindFiles(restorationContext *RestorationContext) {
+	mappingStore := InitMappingStore(restorationContext.GetMappingFilePath())
+	defer mappingStore.Close()
+
+	filter := NewFindFilter(restorationContext.Options)
+	if filter.FullText && !mappingStore.HasFullText() {
+		outputs.Println(outputs.Warning, "Full text search is not available (rsg built without sqlite_fts5 tag or mapping file not indexed), terms are searched as substrings")
+	}
+	versionsByPath, err := mappingStore.Versions(filter)
+	utils.ExitIfError(err)
+	fileIterator, err := mappingStore.Files(filter)
+	utils.ExitIfError(err)
+	defer fileIterator.Close()
+
+	count := 0
+	totalSize := uint64(0)
+	for fileIterator.Next() {
+		mappingEntry := fileIterator.Entry()
+		outputs.Printfln(outputs.Info, "%s\t%s\t%s\t%s\t%s\t%s", mappingEntry.ShareName, mappingEntry.BasePath,
+			bytefmt.ByteSize(mappingEntry.FileSize), mappingEntry.ArchiveId,
+			getRestoredLocally(restorationContext.DestinationDirPath, mappingEntry),
+			getVersion(versionsByPath[mappingEntry.Path()], mappingEntry))
+		count++
+		totalSize += mappingEntry.FileSize
+	}
+	utils.ExitIfError(fileIterator.Err())
+	outputs.Printfln(outputs.OptionalInfo, "%d file(s) found, total size: %s", count, bytefmt.ByteSize(totalSize))
+}
+
+func NewFindFilter(restorationOptions RestorationOptions) MappingFilter {
+	filter := newFacetFilter(restorationOptions)
+	filter.Terms = restorationOptions.CommandArgs
+	filter.FullText = restorationOptions.FullText
+	return filter
+}
+
+// Filter with patterns, extensions, shares and size range of options
+func newFacetFilter(restorationOptions RestorationOptions) MappingFilter {
+	extensions := []string{}
+	for _, extension := range restorationOptions.Extensions {
+		extensions = append(extensions, strings.TrimPrefix(extension, "."))
+	}
+	return MappingFilter{Patterns: restorationOptions.Filters,
+		Extensions: extensions,
+		Shares: restorationOptions.Shares,
+		MinSize: restorationOptions.MinSize,
+		MaxSize: restorationOptions.MaxSize}
+}
+
+func getVersion(versions []*MappingEntry, mappingEntry *MappingEntry) string {
+	if len(versions) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d/%d (key %d)", versionIndex(versions, mappingEntry), len(versions), mappingEntry.Key)
+}
+
+// A file is restored if it exists in the destination directory with the size of the mapping file
+func getRestoredLocally(destinationDirPath string, mappingEntry *MappingEntry) string {
+	if destinationDirPath == "" {
+		return unknownRestoredLocally
+	}
+	stat, err := os.Stat(destinationDirPath + "/" + mappingEntry.Path())
+	if err != nil || stat.IsDir() || uint64(stat.Size()) != mappingEntry.FileSize {
+		return notRestoredLocally
+	}
+	return restoredLocally
+}
@@ -0,0 +1,30 @@
+// +build sqlite_fts5
+
+package core
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+// Full text search needs sqlite with FTS5: go test -tags sqlite_fts5
+
+func TestFindFiles_with_full_text(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initFindFilesTest(t)
+	restorationContext.Options.CommandArgs = []string{"each"}
+	restorationContext.Options.FullText = true
+	restorationContext.DestinationDirPath = ""
+	IndexMappingFileIfNecessary(restorationContext)
+	_, fullText := mappingIndexIsUpToDate(restorationContext.GetMappingFilePath())
+	buffer.Reset()
+
+	// When
+	FindFiles(restorationContext)
+
+	// Then
+	assert.True(t, fullText)
+	// each is not a prefix of a word of the paths
+	assert.Equal(t, "0 file(s) found, total size: 0B\n", string(buffer.Bytes()))
+}
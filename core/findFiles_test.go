@@ -0,0 +1,117 @@
+package core
+
+import (
+	"testing"
+	"io/ioutil"
+	"os"
+	"strings"
+	"github.com/stretchr/testify/assert"
+)
+
+func initFindFilesTest(t *testing.T) *RestorationContext {
+	restorationContext := DefaultRestorationContext(nil)
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", restorationContext.GetMappingFilePath())
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('photos', '2016/Holidays/beach.JPG', 'archiveId3', 2048);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('photos', '2016/holidays_list.txt', 'archiveId4', 10);")
+	db.Close()
+	return restorationContext
+}
+
+func TestFindFiles_with_substring(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initFindFilesTest(t)
+	restorationContext.Options.CommandArgs = []string{"HOLIDAY"}
+	os.MkdirAll("../../testtmp/dest/photos/2016", 0700)
+	ioutil.WriteFile("../../testtmp/dest/photos/2016/holidays_list.txt", []byte("0123456789"), 0600)
+	buffer.Reset()
+
+	// When
+	FindFiles(restorationContext)
+
+	// Then
+	assert.Equal(t, "photos\t2016/Holidays/beach.JPG\t2K\tarchiveId3\tnot restored\t-\n" +
+		"photos\t2016/holidays_list.txt\t10B\tarchiveId4\trestored\t-\n" +
+		"2 file(s) found, total size: 2K\n", string(buffer.Bytes()))
+}
+
+func TestFindFiles_with_facets(t *testing.T) {
+	for name, options := range map[string]RestorationOptions{
+		"extension": {Extensions: []string{".jpg"}},
+		"share": {Shares: []string{"photos"}, MinSize: 11},
+		"size range": {MinSize: 1024, MaxSize: 4096},
+		"terms and extension": {CommandArgs: []string{"2016", "beach"}, Extensions: []string{"png", "jpg"}},
+	} {
+		// Given
+		buffer := CommonInitTest()
+		restorationContext := initFindFilesTest(t)
+		restorationContext.Options = options
+		restorationContext.DestinationDirPath = ""
+		buffer.Reset()
+
+		// When
+		FindFiles(restorationContext)
+
+		// Then
+		assert.Equal(t, "photos\t2016/Holidays/beach.JPG\t2K\tarchiveId3\t-\t-\n1 file(s) found, total size: 2K\n", string(buffer.Bytes()), name)
+	}
+}
+
+func TestFindFiles_with_full_text_not_available(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initFindFilesTest(t)
+	restorationContext.Options.CommandArgs = []string{"each"}
+	restorationContext.Options.FullText = true
+	restorationContext.DestinationDirPath = ""
+	buffer.Reset()
+
+	// When
+	FindFiles(restorationContext)
+
+	// Then
+	assert.Equal(t, "WARNING: Full text search is not available (rsg built without sqlite_fts5 tag or mapping file not indexed), terms are searched as substrings\n" +
+		"photos\t2016/Holidays/beach.JPG\t2K\tarchiveId3\t-\t-\n1 file(s) found, total size: 2K\n", string(buffer.Bytes()))
+}
+
+func TestFindFiles_with_full_text_or_substring_matches_share_name(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initFindFilesTest(t)
+	restorationContext.Options.CommandArgs = []string{"photos"}
+	restorationContext.Options.FullText = true
+	restorationContext.DestinationDirPath = ""
+	IndexMappingFileIfNecessary(restorationContext)
+	buffer.Reset()
+
+	// When
+	FindFiles(restorationContext)
+
+	// Then
+	// same files with FTS5 (go test -tags sqlite_fts5) or without it
+	assert.True(t, strings.HasSuffix(string(buffer.Bytes()), "photos\t2016/Holidays/beach.JPG\t2K\tarchiveId3\t-\t-\n" +
+		"photos\t2016/holidays_list.txt\t10B\tarchiveId4\t-\t-\n" +
+		"2 file(s) found, total size: 2K\n"), string(buffer.Bytes()))
+}
+
+func TestFindLocalMappingCopies(t *testing.T) {
+	// Given
+	CommonInitTest()
+	for _, workingDirPath := range []string{"../../testtmp/rsg/region1/vault1", "../../testtmp/rsg/region1/vault2", "../../testtmp/rsg/region2/vault1"} {
+		os.MkdirAll(workingDirPath, 0700)
+		ioutil.WriteFile(workingDirPath + "/mapping.sqllite", []byte{}, 0600)
+	}
+	os.MkdirAll("../../testtmp/rsg/region2/vault3", 0700)
+
+	// When
+	all := findLocalMappingCopies("../../testtmp/rsg", "", "")
+	vault1 := findLocalMappingCopies("../../testtmp/rsg", "", "vault1")
+	region1Vault2 := findLocalMappingCopies("../../testtmp/rsg", "region1", "vault2")
+	vault3 := findLocalMappingCopies("../../testtmp/rsg", "", "vault3")
+
+	// Then
+	assert.Equal(t, []string{"../../testtmp/rsg/region1/vault1", "../../testtmp/rsg/region1/vault2", "../../testtmp/rsg/region2/vault1"}, all)
+	assert.Equal(t, []string{"../../testtmp/rsg/region1/vault1", "../../testtmp/rsg/region2/vault1"}, vault1)
+	assert.Equal(t, []string{"../../testtmp/rsg/region1/vault2"}, region1Vault2)
+	assert.Equal(t, []string{}, vault3)
+}
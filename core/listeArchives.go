@@ -2,20 +2,21 @@ package core
 
 import (
 	"rsg/outputs"
+	"rsg/utils"
 )
 
 // List paths from the mapping file
 
 func ListArchives(restorationContext *RestorationContext) {
-	db := InitDb(restorationContext.GetMappingFilePath())
-	defer db.Close()
+	mappingStore := InitMappingStore(restorationContext.GetMappingFilePath())
+	defer mappingStore.Close()
 
-	archiveRows := GetFiles(db, restorationContext.Options.Filters)
-	defer archiveRows.Close()
+	fileIterator, err := mappingStore.Files(NewMappingFilter(restorationContext.Options.Filters))
+	utils.ExitIfError(err)
+	defer fileIterator.Close()
 
-	for archiveRows.Next() {
-		var basePath string
-		archiveRows.Scan(&basePath)
-		outputs.Printfln(outputs.Info, "%v", basePath)
+	for fileIterator.Next() {
+		outputs.Printfln(outputs.Info, "%v", fileIterator.Entry().BasePath)
 	}
+	utils.ExitIfError(fileIterator.Err())
 }
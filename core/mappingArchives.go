@@ -0,0 +1,100 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"rsg/awsutils"
+	"rsg/inputs"
+	"rsg/outputs"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// The mapping vault should contain one archive, but stale uploads or backup re-runs can leave several. The newest
+// archive by creation date is the mapping file, the others are older versions of the mapping file.
+// The archive to download is given by option: "latest", "choose" (asked to user), an archive id or a creation date
+// (or its beginning, ex: 2016-10-20).
+
+const (
+	LatestMappingArchive = "latest"
+	ChooseMappingArchive = "choose"
+)
+
+type byCreationDateDesc []awsutils.InventoryArchive
+
+func (archives byCreationDateDesc) Len() int {
+	return len(archives)
+}
+
+func (archives byCreationDateDesc) Swap(i, j int) {
+	archives[i], archives[j] = archives[j], archives[i]
+}
+
+func (archives byCreationDateDesc) Less(i, j int) bool {
+	return archives[i].CreationDate > archives[j].CreationDate
+}
+
+// Archives of all inventory pages of mapping vault, newest first
+func inventoryMappingArchives(restorationContext *RestorationContext) []awsutils.InventoryArchive {
+	jobId, jobCompleted := checkMappingInventoryOrStartNewJob(restorationContext)
+	archives := inventoryVault(restorationContext, restorationContext.MappingVault, jobId, jobCompleted).ArchiveList
+	if len(archives) == 0 {
+		utils.ExitIfError(errors.New("Mapping vault has no archive"))
+	}
+	sort.Stable(byCreationDateDesc(archives))
+	return archives
+}
+
+// Archives are ordered newest first
+func selectMappingArchive(archives []awsutils.InventoryArchive, mappingArchive string) awsutils.InventoryArchive {
+	switch mappingArchive {
+	case "", LatestMappingArchive:
+		if len(archives) > 1 {
+			outputs.Printfln(outputs.OptionalInfo, "Mapping vault has %d archives, the newest one (%s) is used, use mapping-archives command to list others", len(archives), archives[0].CreationDate)
+		}
+		return archives[0]
+	case ChooseMappingArchive:
+		if len(archives) == 1 {
+			return archives[0]
+		}
+		displayMappingArchives(archives)
+		for {
+			index, err := strconv.Atoi(inputs.QueryString("Select the mapping archive to use (number):"))
+			if err == nil && index >= 1 && index <= len(archives) {
+				return archives[index - 1]
+			}
+			outputs.Println(outputs.Info, "Mapping archive doesn't exist. Try again...")
+		}
+	}
+	matchingArchives := []awsutils.InventoryArchive{}
+	for _, archive := range archives {
+		if archive.ArchiveId == mappingArchive {
+			return archive
+		}
+		if strings.HasPrefix(archive.CreationDate, mappingArchive) {
+			matchingArchives = append(matchingArchives, archive)
+		}
+	}
+	if len(matchingArchives) != 1 {
+		utils.ExitIfError(errors.New(fmt.Sprintf("%d mapping archive(s) match %s, use mapping-archives command to list them", len(matchingArchives), mappingArchive)))
+	}
+	return matchingArchives[0]
+}
+
+// Archives of the last inventory of mapping vault
+func ListMappingArchives(restorationContext *RestorationContext) {
+	if len(restorationContext.RegionVaultCache.MappingArchives) == 0 {
+		outputs.Println(outputs.Info, "No inventory of mapping vault, it is done when mapping file is downloaded")
+		return
+	}
+	displayMappingArchives(restorationContext.RegionVaultCache.MappingArchives)
+}
+
+func displayMappingArchives(archives []awsutils.InventoryArchive) {
+	for i, archive := range archives {
+		outputs.Printfln(outputs.Info, "%d\t%s\t%s\t%s", i + 1, archive.CreationDate, bytefmt.ByteSize(archive.Size), archive.ArchiveId)
+	}
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"errors"
 	"rsg/outputs"
 	"rsg/utils"
 	"rsg/inputs"
@@ -15,14 +16,79 @@ import (
 // Download mapping file
 
 func DownloadMappingArchive(restorationContext *RestorationContext) {
+	if restorationContext.MappingFilePath != "" {
+		outputs.Printfln(outputs.Verbose, "Mapping file given by option is not downloaded: %s", restorationContext.MappingFilePath)
+		return
+	}
 	if stat, err := os.Stat(restorationContext.GetMappingFilePath()); os.IsNotExist(err) {
 		downloadMappingArchive(restorationContext)
-	} else if queryAndUpdateRefreshMappingFile(restorationContext, stat.ModTime().Format("Mon Jan _2 15:04:05 2006")) {
+	} else if mustRefreshMappingFile(restorationContext, stat.ModTime()) {
+		keepMappingSnapshot(restorationContext)
 		os.Remove(restorationContext.GetMappingFilePath())
 		downloadMappingArchive(restorationContext)
 	}
 }
 
+// Refresh is given by option, by max age of mapping file, by a newer mapping archive or changes of mapping vault since
+// download, or else by user
+func mustRefreshMappingFile(restorationContext *RestorationContext, modTime time.Time) bool {
+	if restorationContext.Options.RefreshMappingFile == nil {
+		if restorationContext.Options.MappingMaxAge > 0 {
+			refresh := time.Since(modTime) > restorationContext.Options.MappingMaxAge
+			outputs.Printfln(outputs.OptionalInfo, "Local mapping file downloaded at %v, refresh: %v (max age %v)", modTime.Format("Mon Jan _2 15:04:05 2006"), refresh, restorationContext.Options.MappingMaxAge)
+			restorationContext.Options.RefreshMappingFile = &refresh
+		} else if changed, known := newerMappingArchiveExists(restorationContext); restorationContext.Options.MappingInventory && known {
+			restorationContext.Options.RefreshMappingFile = &changed
+		} else if changed, known := mappingVaultHasChanged(restorationContext); known {
+			restorationContext.Options.RefreshMappingFile = &changed
+		}
+	}
+	return queryAndUpdateRefreshMappingFile(restorationContext, modTime.Format("Mon Jan _2 15:04:05 2006"))
+}
+
+// Compare number of archives, size and last inventory date of mapping vault with its state when mapping file was
+// downloaded. Returns false as second value if the state is unknown.
+func mappingVaultHasChanged(restorationContext *RestorationContext) (bool, bool) {
+	previousState := restorationContext.RegionVaultCache.MappingVaultState
+	if previousState == nil {
+		return false, false
+	}
+	state, err := awsutils.GetVaultState(restorationContext.GlacierClient, restorationContext.MappingVault)
+	if err != nil {
+		outputs.Printfln(outputs.Warning, "Cannot get state of mapping vault: %v", err)
+		return false, false
+	}
+	if state.NumberOfArchives != previousState.NumberOfArchives || state.SizeInBytes != previousState.SizeInBytes ||
+		state.LastInventoryDate != previousState.LastInventoryDate {
+		outputs.Printfln(outputs.OptionalInfo, "Mapping vault has changed since download of local mapping file (%d archive(s) of %s, inventory of %s), refresh mapping file",
+			state.NumberOfArchives, bytefmt.ByteSize(uint64(state.SizeInBytes)), state.LastInventoryDate)
+		return true, true
+	}
+	outputs.Printfln(outputs.OptionalInfo, "Mapping vault has not changed since download of local mapping file (inventory of %s)", state.LastInventoryDate)
+	return false, true
+}
+
+// Compare the mapping archive of the local mapping file with the one selected in a fresh inventory of mapping vault,
+// the selected archive is kept to be downloaded. Returns false as second value if the local mapping archive is unknown.
+func newerMappingArchiveExists(restorationContext *RestorationContext) (bool, bool) {
+	localArchive := restorationContext.RegionVaultCache.LocalMappingArchive
+	if !restorationContext.Options.MappingInventory || localArchive == nil {
+		return false, false
+	}
+	restorationContext.RegionVaultCache.MappingArchives = inventoryMappingArchives(restorationContext)
+	archive := selectMappingArchive(restorationContext.RegionVaultCache.MappingArchives, restorationContext.Options.MappingArchive)
+	if archive.ArchiveId == localArchive.ArchiveId {
+		outputs.Printfln(outputs.OptionalInfo, "Mapping archive has not changed since download of local mapping file (created at %s)", localArchive.CreationDate)
+		restorationContext.WriteCache()
+		return false, true
+	}
+	outputs.Printfln(outputs.OptionalInfo, "Mapping archive created at %s replaces the one of local mapping file (created at %s), refresh mapping file",
+		archive.CreationDate, localArchive.CreationDate)
+	restorationContext.RegionVaultCache.MappingArchive = archive.Archive()
+	restorationContext.WriteCache()
+	return true, true
+}
+
 func queryAndUpdateRefreshMappingFile(restorationContext *RestorationContext, modTime string) bool {
 	if restorationContext.Options.RefreshMappingFile == nil {
 		answer := inputs.QueryYesOrNo(fmt.Sprintf("Local mapping archive already exists with last modification date %v, retrieve a new mapping file ?", modTime), false)
@@ -32,23 +98,45 @@ func queryAndUpdateRefreshMappingFile(restorationContext *RestorationContext, mo
 }
 
 func downloadMappingArchive(restorationContext *RestorationContext) {
-	jobId, jobCompleted := checkRetrieveMappingOrStartNewJob(restorationContext, getMappingArchive(restorationContext))
+	mappingArchive := getMappingArchive(restorationContext)
+	jobId, jobCompleted := checkRetrieveMappingOrStartNewJob(restorationContext, mappingArchive)
 	if !jobCompleted {
 		awsutils.WaitJobIsCompleted(restorationContext.GlacierClient, restorationContext.MappingVault, jobId)
 		outputs.Printfln(outputs.OptionalInfo, "Job has finished: %s", jobId)
 	}
-	start := time.Now()
-	sizeDownloaded := awsutils.DownloadArchiveTo(restorationContext.GlacierClient, restorationContext.MappingVault, jobId, restorationContext.GetMappingFilePath())
-	restorationContext.BytesBySecond = uint64(float64(sizeDownloaded) / time.Since(start).Seconds())
-	outputs.Printfln(outputs.Verbose, "New download speed: %v/s", bytefmt.ByteSize(restorationContext.BytesBySecond))
+	treeHash := getMappingArchiveTreeHash(restorationContext, mappingArchive.ArchiveId, jobId)
+	for attempt := 1; ; attempt++ {
+		os.Remove(restorationContext.GetMappingFilePath())
+		start := time.Now()
+		sizeDownloaded := awsutils.DownloadArchiveTo(restorationContext.GlacierClient, restorationContext.MappingVault, jobId, restorationContext.GetMappingFilePath())
+		restorationContext.BytesBySecond = uint64(float64(sizeDownloaded) / time.Since(start).Seconds())
+		outputs.Printfln(outputs.Verbose, "New download speed: %v/s", bytefmt.ByteSize(restorationContext.BytesBySecond))
+		err := validateMappingFile(restorationContext.GetMappingFilePath(), mappingArchive, treeHash)
+		if err == nil {
+			break
+		}
+		if attempt == mappingDownloadMaxAttempts {
+			os.Remove(restorationContext.GetMappingFilePath())
+			utils.ExitIfError(errors.New(fmt.Sprintf("Mapping file is still invalid after %d downloads: %v", attempt, err)))
+		}
+		outputs.Printfln(outputs.Warning, "Mapping file is invalid, download it again from job %s: %v", jobId, err)
+	}
+	restorationContext.RegionVaultCache.LocalMappingArchive = findMappingArchive(restorationContext.RegionVaultCache.MappingArchives, mappingArchive)
 	restorationContext.RegionVaultCache.MappingArchive = nil
+	if state, err := awsutils.GetVaultState(restorationContext.GlacierClient, restorationContext.MappingVault); err == nil {
+		restorationContext.RegionVaultCache.MappingVaultState = state
+	} else {
+		outputs.Printfln(outputs.Warning, "Cannot get state of mapping vault, mapping file will not be refreshed automatically: %v", err)
+		restorationContext.RegionVaultCache.MappingVaultState = nil
+	}
 	restorationContext.WriteCache()
+	keepMappingSnapshot(restorationContext)
 	outputs.Println(outputs.OptionalInfo, "Mapping archive has been downloaded")
 }
 
 func checkRetrieveMappingOrStartNewJob(restorationContext *RestorationContext, archive awsutils.Archive) (string, bool) {
 	jobCompleted := false
-	jobId := awsutils.JobIdsAtStartup.MappingRetrievalJobId
+	jobId := awsutils.JobIdsAtStartup.MappingRetrievalJobIdByArchiveId[archive.ArchiveId]
 	var err error;
 	if jobId != "" {
 		outputs.Printfln(outputs.Verbose, "Retrieve mapping archive job id found : %s", jobId)
@@ -84,25 +172,33 @@ func startRetrieveMappingArchiveJob(restorationContext *RestorationContext, vaul
 }
 
 func getMappingArchive(restorationContext *RestorationContext) awsutils.Archive {
-	mappingArchive := restorationContext.RegionVaultCache.MappingArchive
-	if mappingArchive == nil {
-		jobId, jobCompleted := checkMappingInventoryOrStartNewJob(restorationContext)
-		if jobCompleted == false {
-			awsutils.WaitJobIsCompleted(restorationContext.GlacierClient, restorationContext.MappingVault, jobId)
-			outputs.Printfln(outputs.OptionalInfo, "Job has finished: %s", jobId)
-		}
-		restorationContext.RegionVaultCache.MappingArchive = awsutils.GetArchiveIdFromInventory(restorationContext.GlacierClient, restorationContext.MappingVault, jobId)
+	if restorationContext.RegionVaultCache.MappingArchive == nil {
+		restorationContext.RegionVaultCache.MappingArchives = inventoryMappingArchives(restorationContext)
+		mappingArchive := selectMappingArchive(restorationContext.RegionVaultCache.MappingArchives, restorationContext.Options.MappingArchive)
+		restorationContext.RegionVaultCache.MappingArchive = mappingArchive.Archive()
 		restorationContext.WriteCache()
 	}
 	outputs.Printfln(outputs.Verbose, "Mapping archive id is %s", restorationContext.RegionVaultCache.MappingArchive.ArchiveId)
 	return *restorationContext.RegionVaultCache.MappingArchive
 }
 
+// Archive of the inventory of mapping vault, without creation date if it's not in the inventory
+func findMappingArchive(archives []awsutils.InventoryArchive, archive awsutils.Archive) *awsutils.InventoryArchive {
+	for _, inventoryArchive := range archives {
+		if inventoryArchive.ArchiveId == archive.ArchiveId {
+			return &inventoryArchive
+		}
+	}
+	return &awsutils.InventoryArchive{ArchiveId: archive.ArchiveId, Size: archive.Size}
+}
+
 func checkMappingInventoryOrStartNewJob(restorationContext *RestorationContext) (string, bool) {
 	jobCompleted := false
 	jobId := awsutils.JobIdsAtStartup.MappingInventoryJobId
 	var err error
-	if jobId != "" {
+	if jobId != "" && mappingInventoryJobIsOutdated(restorationContext, awsutils.JobIdsAtStartup.MappingInventoryJobCreationDate) {
+		jobId = inventoryMappingVault(restorationContext)
+	} else if jobId != "" {
 		outputs.Printfln(outputs.Verbose, "Mapping vault inventory job id found : %s", jobId)
 		jobCompleted, err = awsutils.JobIsCompleted(restorationContext.GlacierClient, restorationContext.MappingVault, jobId)
 		if jobCompleted == false {
@@ -121,8 +217,31 @@ func checkMappingInventoryOrStartNewJob(restorationContext *RestorationContext)
 	return jobId, jobCompleted
 }
 
+// An inventory job created before the download of the local mapping file or before the creation of its archive can't
+// find a newer mapping archive
+func mappingInventoryJobIsOutdated(restorationContext *RestorationContext, jobCreationDate string) bool {
+	creationDate, err := time.Parse(time.RFC3339, jobCreationDate)
+	if err != nil {
+		return false
+	}
+	notBefore := time.Time{}
+	if localArchive := restorationContext.RegionVaultCache.LocalMappingArchive; localArchive != nil {
+		if archiveCreationDate, err := time.Parse(time.RFC3339, localArchive.CreationDate); err == nil {
+			notBefore = archiveCreationDate
+		}
+	}
+	if stat, err := os.Stat(restorationContext.GetMappingFilePath()); err == nil && stat.ModTime().After(notBefore) {
+		notBefore = stat.ModTime()
+	}
+	if creationDate.Before(notBefore) {
+		outputs.Printfln(outputs.Verbose, "Mapping inventory job created at %s is older than local mapping file, it is not reused", jobCreationDate)
+		return true
+	}
+	return false
+}
+
 func inventoryMappingVault(restorationContext *RestorationContext) string {
-	jobId := awsutils.InventoryTowElementsOfVault(restorationContext.GlacierClient, restorationContext.MappingVault)
+	jobId := awsutils.StartInventoryJob(restorationContext.GlacierClient, restorationContext.MappingVault, nil)
 	outputs.Printfln(outputs.OptionalInfo, "Job to find mapping archive id has started (can last up to 4 hours): %s", jobId)
 	return jobId
 }
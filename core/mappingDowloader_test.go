@@ -16,6 +16,9 @@ import (
 	"regexp"
 	"strings"
 	"rsg/consts"
+	"os"
+	"strconv"
+	"time"
 )
 
 func mockStartMappingJobInventory(glacierMock *GlacierMock, vault string) *mock.Call {
@@ -24,7 +27,6 @@ func mockStartMappingJobInventory(glacierMock *GlacierMock, vault string) *mock.
 		VaultName: aws.String(vault),
 		JobParameters: &glacier.JobParameters{
 			Type:        aws.String("inventory-retrieval"),
-			InventoryRetrievalParameters: &glacier.InventoryRetrievalJobInput{Limit: aws.String("2")},
 		},
 	}
 
@@ -35,6 +37,53 @@ func mockStartMappingJobInventory(glacierMock *GlacierMock, vault string) *mock.
 	return glacierMock.On("InitiateJob", params).Return(out, nil)
 }
 
+func mockStartMappingJobInventoryFromMarker(glacierMock *GlacierMock, vault, marker, jobIdToReturn string) *mock.Call {
+	params := &glacier.InitiateJobInput{
+		AccountId: aws.String(awsutils.AccountId),
+		VaultName: aws.String(vault),
+		JobParameters: &glacier.JobParameters{
+			Type:        aws.String("inventory-retrieval"),
+			InventoryRetrievalParameters: &glacier.InventoryRetrievalJobInput{Marker: aws.String(marker)},
+		},
+	}
+
+	out := &glacier.InitiateJobOutput{
+		JobId: aws.String(jobIdToReturn),
+	}
+
+	return glacierMock.On("InitiateJob", params).Return(out, nil)
+}
+
+func mockDescribeInventoryJobWithMarker(glacierMock *GlacierMock, jobId, vault, marker string) *mock.Call {
+	params := &glacier.DescribeJobInput{
+		AccountId: aws.String(awsutils.AccountId),
+		JobId:     aws.String(jobId),
+		VaultName: aws.String(vault),
+	}
+
+	out := &glacier.JobDescription{
+		Completed: aws.Bool(true),
+		InventoryRetrievalParameters: &glacier.InventoryRetrievalJobDescription{Marker: aws.String(marker)},
+	}
+
+	return glacierMock.On("DescribeJob", params).Return(out, nil)
+}
+
+func mockDescribeVault(glacierMock *GlacierMock, vault string, numberOfArchives, sizeInBytes int64) *mock.Call {
+	params := &glacier.DescribeVaultInput{
+		AccountId: aws.String(awsutils.AccountId),
+		VaultName: aws.String(vault),
+	}
+
+	out := &glacier.DescribeVaultOutput{
+		LastInventoryDate: aws.String("2016-10-20T10:00:00Z"),
+		NumberOfArchives: aws.Int64(numberOfArchives),
+		SizeInBytes: aws.Int64(sizeInBytes),
+	}
+
+	return glacierMock.On("DescribeVault", params).Return(out, nil)
+}
+
 func mockDescribeJob(glacierMock *GlacierMock, jobId, vault string, completed bool) *mock.Call {
 	params := &glacier.DescribeJobInput{
 		AccountId: aws.String(awsutils.AccountId),
@@ -94,19 +143,21 @@ func TestDownloadMappingArchive_download_mapping_first_time(t *testing.T) {
 	// Given
 	buffer := CommonInitTest()
 	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
 
 	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
 	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, []byte("{\"ArchiveList\":[{\"ArchiveId\":\"mappingArchiveId\",\"Size\":42}]}"))
-	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", "0-41", "retrieveMappingJobId")
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, mappingInventory("mappingArchiveId", mappingContent))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
 	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, []byte("hello !"))
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
 
 	// When
 	DownloadMappingArchive(restorationContext)
 
 	// Then
-	assertMappingArchive(t, "hello !")
+	assertMappingArchive(t, string(mappingContent))
 	assertCacheIsEmpty(t)
 
 	assert.Equal(t, "Job to find mapping archive id has started (can last up to 4 hours): inventoryMappingJobId" + consts.LINE_BREAK +
@@ -120,20 +171,22 @@ func TestDownloadMappingArchive_download_mapping_with_inventory_job_in_progress(
 	// Given
 	buffer := CommonInitTest()
 	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
 	awsutils.JobIdsAtStartup.MappingInventoryJobId = "inventoryMappingJobId"
 
 	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, false).Once()
 	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, []byte("{\"ArchiveList\":[{\"ArchiveId\":\"mappingArchiveId\",\"Size\":42}]}"))
-	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", "0-41", "retrieveMappingJobId")
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, mappingInventory("mappingArchiveId", mappingContent))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
 	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, []byte("hello !"))
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
 
 	// When
 	DownloadMappingArchive(restorationContext)
 
 	//Then
-	assertMappingArchive(t, "hello !")
+	assertMappingArchive(t, string(mappingContent))
 	assertCacheIsEmpty(t)
 
 	assert.Equal(t, "Job to find mapping archive id is in progress (can last up to 4 hours): inventoryMappingJobId" + consts.LINE_BREAK +
@@ -147,21 +200,23 @@ func TestDownloadMappingArchive_download_mapping_with_inventory_job_deprecated(t
 	// Given
 	buffer := CommonInitTest()
 	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
 	awsutils.JobIdsAtStartup.MappingInventoryJobId = "unknownInventoryMappingJobId"
 
 	mockDescribeJobErr(glacierMock, "unknownInventoryMappingJobId", restorationContext.MappingVault, errors.New("The job ID was not found"))
 	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
 	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, []byte("{\"ArchiveList\":[{\"ArchiveId\":\"mappingArchiveId\",\"Size\":42}]}"))
-	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", "0-41", "retrieveMappingJobId")
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, mappingInventory("mappingArchiveId", mappingContent))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
 	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, []byte("hello !"))
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
 
 	// When
 	DownloadMappingArchive(restorationContext)
 
 	// Then
-	assertMappingArchive(t, "hello !")
+	assertMappingArchive(t, string(mappingContent))
 	assertCacheIsEmpty(t)
 
 	assert.Equal(t, "WARNING: Inventory job cahed for mapping vaul was not found" + consts.LINE_BREAK +
@@ -176,19 +231,21 @@ func TestDownloadMappingArchive_download_mapping_with_inventory_done(t *testing.
 	// Given
 	buffer := CommonInitTest()
 	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
 	awsutils.JobIdsAtStartup.MappingInventoryJobId = "inventoryMappingJobId"
 
 	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, []byte("{\"ArchiveList\":[{\"ArchiveId\":\"mappingArchiveId\",\"Size\":42}]}"))
-	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", "0-41", "retrieveMappingJobId")
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, mappingInventory("mappingArchiveId", mappingContent))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
 	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, []byte("hello !"))
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
 
 	// When
 	DownloadMappingArchive(restorationContext)
 
 	// Then
-	assertMappingArchive(t, "hello !")
+	assertMappingArchive(t, string(mappingContent))
 	assertCacheIsEmpty(t)
 
 	assert.Equal(t, "Job to retrieve mapping archive has started (can last up to 4 hours): retrieveMappingJobId" + consts.LINE_BREAK +
@@ -200,18 +257,20 @@ func TestDownloadMappingArchive_download_mapping_with_retrieve_job_in_progress(t
 	// Given
 	buffer := CommonInitTest()
 	glacierMock, restorationContext := InitTestWithGlacier()
-	awsutils.JobIdsAtStartup.MappingRetrievalJobId = "retrieveMappingJobId"
-	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{"mappingArchiveId", 42},}
+	mappingContent := mappingFileContent(t)
+	awsutils.JobIdsAtStartup.MappingRetrievalJobIdByArchiveId["mappingArchiveId"] = "retrieveMappingJobId"
+	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{ArchiveId: "mappingArchiveId", Size: uint64(len(mappingContent))},}
 
 	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, false).Once()
 	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, []byte("hello !"))
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
 
 	// When
 	DownloadMappingArchive(restorationContext)
 
 	//Then
-	assertMappingArchive(t, "hello !")
+	assertMappingArchive(t, string(mappingContent))
 	assertCacheIsEmpty(t)
 
 	assert.Equal(t, "Job to retrieve mapping archive is in progress (can last up to 4 hours): retrieveMappingJobId" + consts.LINE_BREAK +
@@ -223,19 +282,21 @@ func TestDownloadMappingArchive_download_mapping_with_retrieve_job_deprecated(t
 	// Given
 	buffer := CommonInitTest()
 	glacierMock, restorationContext := InitTestWithGlacier()
-	awsutils.JobIdsAtStartup.MappingRetrievalJobId = "unknownRetrieveMappingJobId"
-	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{"mappingArchiveId", 42},}
+	mappingContent := mappingFileContent(t)
+	awsutils.JobIdsAtStartup.MappingRetrievalJobIdByArchiveId["mappingArchiveId"] = "unknownRetrieveMappingJobId"
+	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{ArchiveId: "mappingArchiveId", Size: uint64(len(mappingContent))},}
 
 	mockDescribeJobErr(glacierMock, "unknownRetrieveMappingJobId", restorationContext.MappingVault, errors.New("The job ID was not found"))
-	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", "0-41", "retrieveMappingJobId")
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
 	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, []byte("hello !"))
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
 
 	// When
 	DownloadMappingArchive(restorationContext)
 
 	// Then
-	assertMappingArchive(t, "hello !")
+	assertMappingArchive(t, string(mappingContent))
 	assertCacheIsEmpty(t)
 
 	assert.Equal(t, "WARNING: Retrieve mapping archive job cached was not found" + consts.LINE_BREAK +
@@ -244,21 +305,47 @@ func TestDownloadMappingArchive_download_mapping_with_retrieve_job_deprecated(t
 		"Mapping archive has been downloaded" + consts.LINE_BREAK, string(buffer.Bytes()))
 }
 
+func TestDownloadMappingArchive_download_mapping_with_retrieve_job_of_another_archive(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
+	awsutils.JobIdsAtStartup.MappingRetrievalJobIdByArchiveId["oldMappingArchiveId"] = "retrieveOldMappingJobId"
+	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{ArchiveId: "mappingArchiveId", Size: uint64(len(mappingContent))},}
+
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
+	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, string(mappingContent))
+
+	assert.Equal(t, "Job to retrieve mapping archive has started (can last up to 4 hours): retrieveMappingJobId" + consts.LINE_BREAK +
+		"Job has finished: retrieveMappingJobId" + consts.LINE_BREAK +
+		"Mapping archive has been downloaded" + consts.LINE_BREAK, string(buffer.Bytes()))
+}
+
 func TestDownloadMappingArchive_download_mapping_with_retrieve_done(t *testing.T) {
 	// Given
 	buffer := CommonInitTest()
 	glacierMock, restorationContext := InitTestWithGlacier()
-	awsutils.JobIdsAtStartup.MappingRetrievalJobId = "retrieveMappingJobId"
-	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{"mappingArchiveId", 42},}
+	mappingContent := mappingFileContent(t)
+	awsutils.JobIdsAtStartup.MappingRetrievalJobIdByArchiveId["mappingArchiveId"] = "retrieveMappingJobId"
+	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{ArchiveId: "mappingArchiveId", Size: uint64(len(mappingContent))},}
 
 	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, []byte("hello !"))
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
 
 	// When
 	DownloadMappingArchive(restorationContext)
 
 	// Then
-	assertMappingArchive(t, "hello !")
+	assertMappingArchive(t, string(mappingContent))
 	assertCacheIsEmpty(t)
 
 	assert.Equal(t, "Mapping archive has been downloaded" + consts.LINE_BREAK, string(buffer.Bytes()))
@@ -269,8 +356,8 @@ func TestDownloadMappingArchive_download_mapping_with_mapping_already_exists(t *
 	buffer := CommonInitTest()
 	glacierMock := new(GlacierMock)
 	restorationContext := DefaultRestorationContext(glacierMock)
-	awsutils.JobIdsAtStartup.MappingRetrievalJobId = "retrieveMappingJobId"
-	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{"mappingArchiveId", 42},}
+	awsutils.JobIdsAtStartup.MappingRetrievalJobIdByArchiveId["mappingArchiveId"] = "retrieveMappingJobId"
+	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{ArchiveId: "mappingArchiveId", Size: 42},}
 
 	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("hello !"), 0600)
 
@@ -293,23 +380,25 @@ func TestDownloadMappingArchive_download_mapping_with_mapping_already_exists_but
 	// Given
 	buffer := CommonInitTest()
 	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
 
-	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("hello !"), 0600)
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", mappingContent, 0600)
 
 	inputs.StdinReader = bufio.NewReader(bytes.NewReader([]byte("y" + consts.LINE_BREAK)))
 
 	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
 	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, []byte("{\"ArchiveList\":[{\"ArchiveId\":\"mappingArchiveId\",\"Size\":42}]}"))
-	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", "0-41", "retrieveMappingJobId")
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, mappingInventory("mappingArchiveId", mappingContent))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
 	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
-	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, []byte("hello !"))
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
 
 	// When
 	DownloadMappingArchive(restorationContext)
 
 	// Then
-	assertMappingArchive(t, "hello !")
+	assertMappingArchive(t, string(mappingContent))
 	assertCacheIsEmpty(t)
 
 	outputs := strings.Split(string(buffer.Bytes()), consts.LINE_BREAK)
@@ -321,6 +410,337 @@ func TestDownloadMappingArchive_download_mapping_with_mapping_already_exists_but
 	assert.Equal(t, "Mapping archive has been downloaded", outputs[4])
 }
 
+func TestDownloadMappingArchive_keep_vault_state_in_cache(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
+	awsutils.JobIdsAtStartup.MappingRetrievalJobIdByArchiveId["mappingArchiveId"] = "retrieveMappingJobId"
+	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{ArchiveId: "mappingArchiveId", Size: uint64(len(mappingContent))},}
+
+	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 3, 1000)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	cache := ReadCache(restorationContext.WorkingDirPath)
+	assert.Nil(t, cache.MappingArchive)
+	assert.Equal(t, &awsutils.InventoryArchive{ArchiveId: "mappingArchiveId", Size: uint64(len(mappingContent))}, cache.LocalMappingArchive)
+	assert.Equal(t, &awsutils.VaultState{LastInventoryDate: "2016-10-20T10:00:00Z", NumberOfArchives: 3, SizeInBytes: 1000}, cache.MappingVaultState)
+}
+
+func TestDownloadMappingArchive_mapping_already_exists_and_inventory_finds_newer_archive(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
+	restorationContext.Options.MappingInventory = true
+	restorationContext.RegionVaultCache = RegionVaultCache{LocalMappingArchive: &awsutils.InventoryArchive{ArchiveId: "oldMappingArchiveId", CreationDate: "2016-10-18T10:00:00Z", Size: 42}}
+
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("old !"), 0600)
+
+	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
+	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, []byte("{\"ArchiveList\":[" +
+		"{\"ArchiveId\":\"oldMappingArchiveId\",\"CreationDate\":\"2016-10-18T10:00:00Z\",\"Size\":42}," +
+		"{\"ArchiveId\":\"mappingArchiveId\",\"CreationDate\":\"2016-10-20T10:00:00Z\",\"Size\":" + strconv.Itoa(len(mappingContent)) + "}]}"))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
+	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 2, 84)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, string(mappingContent))
+	assert.Equal(t, &awsutils.InventoryArchive{ArchiveId: "mappingArchiveId", CreationDate: "2016-10-20T10:00:00Z", Size: uint64(len(mappingContent))},
+		ReadCache(restorationContext.WorkingDirPath).LocalMappingArchive)
+	assert.Contains(t, string(buffer.Bytes()), "Mapping archive created at 2016-10-20T10:00:00Z replaces the one of local mapping file (created at 2016-10-18T10:00:00Z), refresh mapping file")
+}
+
+func TestDownloadMappingArchive_mapping_already_exists_and_inventory_finds_same_archive(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	restorationContext.Options.MappingInventory = true
+	restorationContext.RegionVaultCache = RegionVaultCache{LocalMappingArchive: &awsutils.InventoryArchive{ArchiveId: "mappingArchiveId", CreationDate: "2016-10-18T10:00:00Z", Size: 7}}
+
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("hello !"), 0600)
+
+	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
+	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault,
+		[]byte("{\"ArchiveList\":[{\"ArchiveId\":\"mappingArchiveId\",\"CreationDate\":\"2016-10-18T10:00:00Z\",\"Size\":7}]}"))
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, "hello !")
+	assert.Nil(t, ReadCache(restorationContext.WorkingDirPath).MappingArchive)
+	assert.Contains(t, string(buffer.Bytes()), "Mapping archive has not changed since download of local mapping file (created at 2016-10-18T10:00:00Z)")
+}
+
+func TestDownloadMappingArchive_mapping_already_exists_and_vault_has_not_changed(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	restorationContext.RegionVaultCache = RegionVaultCache{MappingVaultState: &awsutils.VaultState{LastInventoryDate: "2016-10-20T10:00:00Z", NumberOfArchives: 1, SizeInBytes: 42}}
+
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("hello !"), 0600)
+
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, "hello !")
+	glacierMock.AssertNotCalled(t, "InitiateJob", mock.Anything)
+	assert.Equal(t, "Mapping vault has not changed since download of local mapping file (inventory of 2016-10-20T10:00:00Z)" + consts.LINE_BREAK, string(buffer.Bytes()))
+}
+
+func TestDownloadMappingArchive_mapping_already_exists_and_vault_has_changed(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
+	restorationContext.RegionVaultCache = RegionVaultCache{MappingVaultState: &awsutils.VaultState{LastInventoryDate: "2016-10-19T10:00:00Z", NumberOfArchives: 1, SizeInBytes: 42}}
+
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("old !"), 0600)
+
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 2, 84)
+	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
+	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, mappingInventory("mappingArchiveId", mappingContent))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
+	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, string(mappingContent))
+	assert.Equal(t, &awsutils.VaultState{LastInventoryDate: "2016-10-20T10:00:00Z", NumberOfArchives: 2, SizeInBytes: 84}, ReadCache(restorationContext.WorkingDirPath).MappingVaultState)
+	assert.True(t, strings.HasPrefix(string(buffer.Bytes()), "Mapping vault has changed since download of local mapping file (2 archive(s) of 84B, inventory of 2016-10-20T10:00:00Z), refresh mapping file" + consts.LINE_BREAK))
+}
+
+func TestDownloadMappingArchive_mapping_already_exists_and_vault_has_a_new_inventory(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
+	restorationContext.RegionVaultCache = RegionVaultCache{MappingVaultState: &awsutils.VaultState{LastInventoryDate: "2016-10-19T10:00:00Z", NumberOfArchives: 1, SizeInBytes: 42}}
+
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("old !"), 0600)
+
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
+	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
+	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, mappingInventory("mappingArchiveId", mappingContent))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
+	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, string(mappingContent))
+	assert.True(t, strings.HasPrefix(string(buffer.Bytes()), "Mapping vault has changed since download of local mapping file (1 archive(s) of 42B, inventory of 2016-10-20T10:00:00Z), refresh mapping file" + consts.LINE_BREAK))
+}
+
+func TestDownloadMappingArchive_inventory_job_older_than_local_mapping_is_not_reused(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	restorationContext.Options.MappingInventory = true
+	restorationContext.RegionVaultCache = RegionVaultCache{LocalMappingArchive: &awsutils.InventoryArchive{ArchiveId: "mappingArchiveId", CreationDate: "2016-10-18T10:00:00Z", Size: 7}}
+	awsutils.JobIdsAtStartup.MappingInventoryJobId = "oldInventoryMappingJobId"
+	awsutils.JobIdsAtStartup.MappingInventoryJobCreationDate = time.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339)
+
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("hello !"), 0600)
+
+	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
+	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault,
+		[]byte("{\"ArchiveList\":[{\"ArchiveId\":\"mappingArchiveId\",\"CreationDate\":\"2016-10-18T10:00:00Z\",\"Size\":7}]}"))
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, "hello !")
+	assert.True(t, strings.HasPrefix(string(buffer.Bytes()), "Job to find mapping archive id has started (can last up to 4 hours): inventoryMappingJobId" + consts.LINE_BREAK))
+}
+
+func TestDownloadMappingArchive_inventory_job_newer_than_local_mapping_is_reused(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	restorationContext.Options.MappingInventory = true
+	restorationContext.RegionVaultCache = RegionVaultCache{LocalMappingArchive: &awsutils.InventoryArchive{ArchiveId: "mappingArchiveId", CreationDate: "2016-10-18T10:00:00Z", Size: 7}}
+	awsutils.JobIdsAtStartup.MappingInventoryJobId = "inventoryMappingJobId"
+	awsutils.JobIdsAtStartup.MappingInventoryJobCreationDate = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("hello !"), 0600)
+
+	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault,
+		[]byte("{\"ArchiveList\":[{\"ArchiveId\":\"mappingArchiveId\",\"CreationDate\":\"2016-10-18T10:00:00Z\",\"Size\":7}]}"))
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, "hello !")
+	glacierMock.AssertNotCalled(t, "InitiateJob", mock.Anything)
+	assert.Contains(t, string(buffer.Bytes()), "Mapping archive has not changed since download of local mapping file (created at 2016-10-18T10:00:00Z)")
+}
+
+func TestDownloadMappingArchive_mapping_already_exists_and_younger_than_max_age(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock := new(GlacierMock)
+	restorationContext := DefaultRestorationContext(glacierMock)
+	restorationContext.Options.MappingMaxAge = time.Hour
+
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("hello !"), 0600)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, "hello !")
+	glacierMock.AssertNotCalled(t, mock.Anything)
+}
+
+func TestDownloadMappingArchive_mapping_already_exists_and_older_than_max_age(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
+	restorationContext.Options.MappingMaxAge = time.Hour
+
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("old !"), 0600)
+	twoHoursAgo := time.Now().Add(-2 * time.Hour)
+	os.Chtimes("../../testtmp/cache/mapping.sqllite", twoHoursAgo, twoHoursAgo)
+
+	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
+	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, mappingInventory("mappingArchiveId", mappingContent))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
+	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, string(mappingContent))
+}
+
+func TestDownloadMappingArchive_download_newest_mapping_archive_of_paginated_inventory(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
+
+	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
+	mockDescribeInventoryJobWithMarker(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, "marker1")
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, []byte("{\"ArchiveList\":[{\"ArchiveId\":\"oldMappingArchiveId\",\"CreationDate\":\"2016-10-01T10:00:00Z\",\"Size\":12}]}"))
+	mockStartMappingJobInventoryFromMarker(glacierMock, restorationContext.MappingVault, "marker1", "inventoryMappingJobId2")
+	mockDescribeJob(glacierMock, "inventoryMappingJobId2", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "inventoryMappingJobId2", restorationContext.MappingVault, []byte("{\"ArchiveList\":[{\"ArchiveId\":\"mappingArchiveId\",\"CreationDate\":\"2016-10-20T10:00:00Z\",\"Size\":" + strconv.Itoa(len(mappingContent)) + "}]}"))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
+	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 2, 54)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, string(mappingContent))
+	cache := ReadCache(restorationContext.WorkingDirPath)
+	assert.Equal(t, []awsutils.InventoryArchive{
+		{ArchiveId: "mappingArchiveId", CreationDate: "2016-10-20T10:00:00Z", Size: uint64(len(mappingContent))},
+		{ArchiveId: "oldMappingArchiveId", CreationDate: "2016-10-01T10:00:00Z", Size: 12},
+	}, cache.MappingArchives)
+
+	assert.Equal(t, "Job to find mapping archive id has started (can last up to 4 hours): inventoryMappingJobId" + consts.LINE_BREAK +
+		"Job has finished: inventoryMappingJobId" + consts.LINE_BREAK +
+		"Job to get next archives of vault vault_mapping has started (can last up to 4 hours): inventoryMappingJobId2" + consts.LINE_BREAK +
+		"Job has finished: inventoryMappingJobId2" + consts.LINE_BREAK +
+		"Mapping vault has 2 archives, the newest one (2016-10-20T10:00:00Z) is used, use mapping-archives command to list others" + consts.LINE_BREAK +
+		"Job to retrieve mapping archive has started (can last up to 4 hours): retrieveMappingJobId" + consts.LINE_BREAK +
+		"Job has finished: retrieveMappingJobId" + consts.LINE_BREAK +
+		"Mapping archive has been downloaded" + consts.LINE_BREAK, string(buffer.Bytes()))
+}
+
+func TestSelectMappingArchive_by_archive_id_or_creation_date(t *testing.T) {
+	// Given
+	CommonInitTest()
+	archives := []awsutils.InventoryArchive{
+		{ArchiveId: "archiveId3", CreationDate: "2016-10-20T10:00:00Z", Size: 42},
+		{ArchiveId: "archiveId2", CreationDate: "2016-10-12T10:00:00Z", Size: 30},
+		{ArchiveId: "archiveId1", CreationDate: "2016-09-01T10:00:00Z", Size: 12},
+	}
+
+	// When
+	latest := selectMappingArchive(archives, LatestMappingArchive)
+	byArchiveId := selectMappingArchive(archives, "archiveId1")
+	byCreationDate := selectMappingArchive(archives, "2016-10-12")
+
+	// Then
+	assert.Equal(t, "archiveId3", latest.ArchiveId)
+	assert.Equal(t, "archiveId1", byArchiveId.ArchiveId)
+	assert.Equal(t, "archiveId2", byCreationDate.ArchiveId)
+}
+
+func TestSelectMappingArchive_choose(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	archives := []awsutils.InventoryArchive{
+		{ArchiveId: "archiveId2", CreationDate: "2016-10-20T10:00:00Z", Size: 42},
+		{ArchiveId: "archiveId1", CreationDate: "2016-09-01T10:00:00Z", Size: 12},
+	}
+	inputs.StdinReader = bufio.NewReader(bytes.NewReader([]byte("3" + consts.LINE_BREAK + "2" + consts.LINE_BREAK)))
+
+	// When
+	archive := selectMappingArchive(archives, ChooseMappingArchive)
+
+	// Then
+	assert.Equal(t, "archiveId1", archive.ArchiveId)
+	assert.Equal(t, "1\t2016-10-20T10:00:00Z\t42B\tarchiveId2" + consts.LINE_BREAK +
+		"2\t2016-09-01T10:00:00Z\t12B\tarchiveId1" + consts.LINE_BREAK +
+		"Select the mapping archive to use (number): Mapping archive doesn't exist. Try again..." + consts.LINE_BREAK +
+		"Select the mapping archive to use (number): ", string(buffer.Bytes()))
+}
+
+// Content of a valid mapping file
+func mappingFileContent(t *testing.T) []byte {
+	createMappingFromFixture(t, "mapping_dsm5.sqllite", "../../testtmp/mapping_fixture.sqllite").Close()
+	content, err := ioutil.ReadFile("../../testtmp/mapping_fixture.sqllite")
+	assert.Nil(t, err)
+	os.Remove("../../testtmp/mapping_fixture.sqllite")
+	return content
+}
+
+func mappingInventory(archiveId string, content []byte) []byte {
+	return []byte("{\"ArchiveList\":[{\"ArchiveId\":\"" + archiveId + "\",\"Size\":" + strconv.Itoa(len(content)) + "}]}")
+}
+
+func mappingRange(content []byte) string {
+	return "0-" + strconv.Itoa(len(content) - 1)
+}
+
 func assertMappingArchive(t *testing.T, expected string) {
 	data, _ := ioutil.ReadFile("../../testtmp/cache/mapping.sqllite")
 	assert.Equal(t, expected, string(data))
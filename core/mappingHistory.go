@@ -0,0 +1,189 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"rsg/outputs"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Every downloaded mapping file is kept in the history directory of the vault, named with its download date.
+// Two mapping files (snapshots, current mapping file or mapping file of another vault) can be compared to find files
+// added, removed, resized or stored in another archive.
+
+const mappingSnapshotPrefix = "mapping-"
+const mappingSnapshotSuffix = ".sqllite"
+const mappingSnapshotTimeLayout = "2006-01-02T15-04-05"
+
+func (restorationContext *RestorationContext) GetMappingHistoryDirPath() string {
+	return restorationContext.WorkingDirPath + "/history"
+}
+
+// Copy the mapping file into history, named with its modification date (its download date), if not already done
+func keepMappingSnapshot(restorationContext *RestorationContext) {
+	stat, err := os.Stat(restorationContext.GetMappingFilePath())
+	if os.IsNotExist(err) {
+		return
+	}
+	utils.ExitIfError(err)
+	snapshotPath := restorationContext.GetMappingHistoryDirPath() + "/" + mappingSnapshotPrefix + stat.ModTime().UTC().Format(mappingSnapshotTimeLayout) + mappingSnapshotSuffix
+	if utils.Exists(snapshotPath) {
+		return
+	}
+	err = os.MkdirAll(restorationContext.GetMappingHistoryDirPath(), 0700)
+	utils.ExitIfError(err)
+	err = utils.CopyFile(snapshotPath, restorationContext.GetMappingFilePath())
+	utils.ExitIfError(err)
+	err = os.Chtimes(snapshotPath, stat.ModTime(), stat.ModTime())
+	utils.ExitIfError(err)
+	outputs.Printfln(outputs.Verbose, "Mapping file kept in history: %s", snapshotPath)
+}
+
+// Paths of snapshots, oldest first
+func getMappingSnapshots(restorationContext *RestorationContext) []string {
+	snapshotPaths, err := filepath.Glob(restorationContext.GetMappingHistoryDirPath() + "/" + mappingSnapshotPrefix + "*" + mappingSnapshotSuffix)
+	utils.ExitIfError(err)
+	sort.Strings(snapshotPaths)
+	return snapshotPaths
+}
+
+func getMappingSnapshotName(snapshotPath string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(filepath.Base(snapshotPath), mappingSnapshotPrefix), mappingSnapshotSuffix)
+}
+
+func ListMappingSnapshots(restorationContext *RestorationContext) {
+	for _, snapshotPath := range getMappingSnapshots(restorationContext) {
+		size := uint64(0)
+		if stat, err := os.Stat(snapshotPath); err == nil {
+			size = uint64(stat.Size())
+		}
+		outputs.Printfln(outputs.Info, "%s\t%s", getMappingSnapshotName(snapshotPath), bytefmt.ByteSize(size))
+	}
+}
+
+// A mapping file is given by:
+// - "current" for the current mapping file of the vault
+// - the date of a snapshot, or its beginning if only one snapshot matches (ex: 2016-10-20)
+// - "region:vault" or "vault" for the current mapping file of another vault
+// - the path of a mapping file
+func resolveMappingFile(restorationContext *RestorationContext, name string) (string, error) {
+	if name == "current" {
+		return restorationContext.GetMappingFilePath(), nil
+	}
+	if strings.Contains(name, "/") && utils.Exists(name) {
+		return name, nil
+	}
+	matchingSnapshots := []string{}
+	for _, snapshotPath := range getMappingSnapshots(restorationContext) {
+		if strings.HasPrefix(getMappingSnapshotName(snapshotPath), name) {
+			matchingSnapshots = append(matchingSnapshots, snapshotPath)
+		}
+	}
+	if len(matchingSnapshots) == 1 {
+		return matchingSnapshots[0], nil
+	}
+	if len(matchingSnapshots) > 1 {
+		return "", errors.New(fmt.Sprintf("Several mapping snapshots match %s", name))
+	}
+	region, vault := "", name
+	if index := strings.Index(name, ":"); index != -1 {
+		region, vault = name[:index], name[index + 1:]
+	}
+	if workingDirPaths := findLocalMappingCopies(filepath.Dir(filepath.Dir(restorationContext.WorkingDirPath)), region, vault); len(workingDirPaths) == 1 {
+		return workingDirPaths[0] + "/mapping.sqllite", nil
+	}
+	if utils.Exists(name) {
+		return name, nil
+	}
+	return "", errors.New(fmt.Sprintf("No mapping file found for %s", name))
+}
+
+type diffEntry struct {
+	size      uint64
+	archiveId string
+}
+
+// Compare two mapping files (arguments), by default the two last snapshots, or a snapshot and the current mapping
+// file if only one argument is given
+func DiffMappings(restorationContext *RestorationContext) {
+	names := restorationContext.Options.CommandArgs
+	var fromPath, toPath string
+	var err error
+	switch len(names) {
+	case 0:
+		snapshotPaths := getMappingSnapshots(restorationContext)
+		if len(snapshotPaths) < 2 {
+			utils.ExitIfError(errors.New("At least two mapping snapshots are needed to compare, give mapping files to compare"))
+		}
+		fromPath, toPath = snapshotPaths[len(snapshotPaths) - 2], snapshotPaths[len(snapshotPaths) - 1]
+	case 1:
+		fromPath, err = resolveMappingFile(restorationContext, names[0])
+		utils.ExitIfError(err)
+		toPath = restorationContext.GetMappingFilePath()
+	default:
+		fromPath, err = resolveMappingFile(restorationContext, names[0])
+		utils.ExitIfError(err)
+		toPath, err = resolveMappingFile(restorationContext, names[1])
+		utils.ExitIfError(err)
+	}
+	outputs.Printfln(outputs.OptionalInfo, "Compare %s to %s", fromPath, toPath)
+
+	filter := newFacetFilter(restorationContext.Options)
+	filter.Version = LatestVersion
+	fromEntries := loadDiffEntries(fromPath, filter)
+	toEntries := loadDiffEntries(toPath, filter)
+	paths := []string{}
+	for path := range fromEntries {
+		paths = append(paths, path)
+	}
+	for path := range toEntries {
+		if _, ok := fromEntries[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	added, removed, resized, rearchived := 0, 0, 0, 0
+	for _, path := range paths {
+		fromEntry, inFrom := fromEntries[path]
+		toEntry, inTo := toEntries[path]
+		switch {
+		case !inFrom:
+			outputs.Printfln(outputs.Info, "+ %s\t%s", path, bytefmt.ByteSize(toEntry.size))
+			added++
+		case !inTo:
+			outputs.Printfln(outputs.Info, "- %s\t%s", path, bytefmt.ByteSize(fromEntry.size))
+			removed++
+		case fromEntry.size != toEntry.size:
+			outputs.Printfln(outputs.Info, "~ %s\t%s -> %s", path, bytefmt.ByteSize(fromEntry.size), bytefmt.ByteSize(toEntry.size))
+			resized++
+		case fromEntry.archiveId != toEntry.archiveId:
+			outputs.Printfln(outputs.Info, "* %s\t%s -> %s", path, fromEntry.archiveId, toEntry.archiveId)
+			rearchived++
+		}
+	}
+	outputs.Printfln(outputs.OptionalInfo, "%d added, %d removed, %d resized, %d re-archived", added, removed, resized, rearchived)
+}
+
+func loadDiffEntries(mappingFilePath string, filter MappingFilter) map[string]diffEntry {
+	if !utils.Exists(mappingFilePath) {
+		utils.ExitIfError(errors.New(fmt.Sprintf("Mapping file not found: %s", mappingFilePath)))
+	}
+	mappingStore := InitMappingStore(mappingFilePath)
+	defer mappingStore.Close()
+	fileIterator, err := mappingStore.Files(filter)
+	utils.ExitIfError(err)
+	defer fileIterator.Close()
+	entries := make(map[string]diffEntry)
+	for fileIterator.Next() {
+		mappingEntry := fileIterator.Entry()
+		entries[mappingEntry.Path()] = diffEntry{size: mappingEntry.FileSize, archiveId: mappingEntry.ArchiveId}
+	}
+	utils.ExitIfError(fileIterator.Err())
+	return entries
+}
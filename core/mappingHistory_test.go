@@ -0,0 +1,136 @@
+package core
+
+import (
+	"testing"
+	"bufio"
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+	"rsg/consts"
+	"rsg/inputs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadMappingArchive_keep_mapping_files_in_history(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
+
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("old !"), 0600)
+	oldTime := time.Date(2016, 10, 20, 15, 4, 5, 0, time.UTC)
+	os.Chtimes("../../testtmp/cache/mapping.sqllite", oldTime, oldTime)
+
+	inputs.StdinReader = bufio.NewReader(bytes.NewReader([]byte("y" + consts.LINE_BREAK)))
+
+	mockStartMappingJobInventory(glacierMock, restorationContext.MappingVault)
+	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.MappingVault, mappingInventory("mappingArchiveId", mappingContent))
+	mockStartRetrieveJob(glacierMock, restorationContext.MappingVault, "mappingArchiveId", mappingRange(mappingContent), "retrieveMappingJobId")
+	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	snapshotPaths := getMappingSnapshots(restorationContext)
+	assert.Equal(t, 2, len(snapshotPaths))
+	assert.Equal(t, "../../testtmp/cache/history/mapping-2016-10-20T15-04-05.sqllite", snapshotPaths[0])
+	assertFileContent(t, snapshotPaths[0], "old !")
+	assertFileContent(t, snapshotPaths[1], string(mappingContent))
+	assertMappingArchive(t, string(mappingContent))
+}
+
+func createMappingSnapshot(t *testing.T, restorationContext *RestorationContext, name string, rows ...string) string {
+	snapshotPath := restorationContext.GetMappingHistoryDirPath() + "/" + mappingSnapshotPrefix + name + mappingSnapshotSuffix
+	os.MkdirAll(filepath.Dir(snapshotPath), 0700)
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", snapshotPath)
+	for _, row := range rows {
+		_, err := db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES " + row)
+		assert.Nil(t, err)
+	}
+	db.Close()
+	return snapshotPath
+}
+
+func TestDiffMappings_of_two_last_snapshots(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := DefaultRestorationContext(nil)
+	createMappingSnapshot(t, restorationContext, "2016-10-01T10-00-00", "('share', 'data/file3.txt', 'archiveId3', 3)")
+	createMappingSnapshot(t, restorationContext, "2016-10-10T10-00-00",
+		"('share', 'data/file3.txt', 'archiveId3', 3)",
+		"('share', 'data/file4.txt', 'archiveId4', 4)")
+	lastSnapshot := createMappingSnapshot(t, restorationContext, "2016-10-20T10-00-00",
+		"('share', 'data/file3.txt', 'archiveId5', 3)",
+		"('share', 'data/file4.txt', 'archiveId6', 6)",
+		"('share', 'data/file5.txt', 'archiveId7', 5)")
+	db, _ := sql.Open("sqlite3", lastSnapshot)
+	db.Exec("DELETE FROM `file_info_tb` WHERE basePath = 'data/file2.txt'")
+	db.Close()
+	buffer.Reset()
+
+	// When
+	DiffMappings(restorationContext)
+
+	// Then
+	assert.Equal(t, "Compare ../../testtmp/cache/history/mapping-2016-10-10T10-00-00.sqllite to ../../testtmp/cache/history/mapping-2016-10-20T10-00-00.sqllite\n" +
+		"- share/data/file2.txt\t12B\n" +
+		"* share/data/file3.txt\tarchiveId3 -> archiveId5\n" +
+		"~ share/data/file4.txt\t4B -> 6B\n" +
+		"+ share/data/file5.txt\t5B\n" +
+		"1 added, 1 removed, 1 resized, 1 re-archived\n", string(buffer.Bytes()))
+}
+
+func TestDiffMappings_of_snapshot_and_current_mapping_file(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := DefaultRestorationContext(nil)
+	restorationContext.Options.CommandArgs = []string{"2016-10-01"}
+	restorationContext.Options.Shares = []string{"share"}
+	createMappingSnapshot(t, restorationContext, "2016-10-01T10-00-00")
+	createMappingSnapshot(t, restorationContext, "2016-10-10T10-00-00")
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", restorationContext.GetMappingFilePath())
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file3.txt', 'archiveId3', 3);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('other', 'data/file4.txt', 'archiveId4', 4);")
+	db.Close()
+	buffer.Reset()
+
+	// When
+	DiffMappings(restorationContext)
+
+	// Then
+	assert.Equal(t, "Compare ../../testtmp/cache/history/mapping-2016-10-01T10-00-00.sqllite to ../../testtmp/cache/mapping.sqllite\n" +
+		"+ share/data/file3.txt\t3B\n" +
+		"1 added, 0 removed, 0 resized, 0 re-archived\n", string(buffer.Bytes()))
+}
+
+func TestResolveMappingFile(t *testing.T) {
+	// Given
+	CommonInitTest()
+	restorationContext := DefaultRestorationContext(nil)
+	snapshot1 := createMappingSnapshot(t, restorationContext, "2016-10-01T10-00-00")
+	createMappingSnapshot(t, restorationContext, "2016-10-01T12-00-00")
+
+	// When
+	current, currentErr := resolveMappingFile(restorationContext, "current")
+	byDate, byDateErr := resolveMappingFile(restorationContext, "2016-10-01T10")
+	_, ambiguousErr := resolveMappingFile(restorationContext, "2016-10-01")
+	byPath, byPathErr := resolveMappingFile(restorationContext, snapshot1)
+	_, unknownErr := resolveMappingFile(restorationContext, "unknown")
+
+	// Then
+	assert.Nil(t, currentErr)
+	assert.Equal(t, "../../testtmp/cache/mapping.sqllite", current)
+	assert.Nil(t, byDateErr)
+	assert.Equal(t, snapshot1, byDate)
+	assert.EqualError(t, ambiguousErr, "Several mapping snapshots match 2016-10-01")
+	assert.Nil(t, byPathErr)
+	assert.Equal(t, snapshot1, byPath)
+	assert.EqualError(t, unknownErr, "No mapping file found for unknown")
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"rsg/outputs"
+	"rsg/utils"
+)
+
+// Build a local index of the mapping file, the mapping file written by Synology has no index and queries on huge
+// vaults scan the whole table for each archive.
+// The index is a sidecar sqlite file with a copy of file_info_tb (DSM columns and metadata columns),
+// an index on archive id, an index on base path (used by LIKE 'prefix%' filters), an index on share name and base path
+// (used to group versions of paths) and, if sqlite supports it, a FTS5 table on share names and base paths, the columns
+// searched by LIKE without it. The mapping file is never modified.
+// The index records size and modification date of the mapping file, it's ignored and rebuilt when they change.
+
+const mappingIndexVersion = 3
+const mappingIndexFullTextTable = "file_info_fts"
+
+func mappingIndexFilePath(mappingFilePath string) string {
+	return mappingFilePath + ".index"
+}
+
+func IndexMappingFileIfNecessary(restorationContext *RestorationContext) {
+	if restorationContext.MappingFilePath != "" {
+		outputs.Printfln(outputs.Verbose, "Mapping file given by option is not indexed: %s", restorationContext.MappingFilePath)
+		return
+	}
+	mappingFilePath := restorationContext.GetMappingFilePath()
+	if upToDate, _ := mappingIndexIsUpToDate(mappingFilePath); upToDate {
+		outputs.Println(outputs.Verbose, "Mapping index is up to date")
+		return
+	}
+	outputs.Println(outputs.OptionalInfo, "Index mapping file...")
+	if err := BuildMappingIndex(mappingFilePath); err != nil {
+		outputs.Printfln(outputs.Warning, "Cannot index mapping file, queries could be slow: %v", err)
+	}
+}
+
+// Returns the availability of the full text table if the index is up to date
+func mappingIndexIsUpToDate(mappingFilePath string) (bool, bool) {
+	indexFilePath := mappingIndexFilePath(mappingFilePath)
+	stat, err := os.Stat(mappingFilePath)
+	if err != nil || !utils.Exists(indexFilePath) {
+		return false, false
+	}
+	db, err := sql.Open("sqlite3", indexFilePath)
+	if err != nil {
+		return false, false
+	}
+	defer db.Close()
+	var version, sourceSize, sourceModTime int64
+	var fullText bool
+	err = db.QueryRow("SELECT version, sourceSize, sourceModTime, fullText FROM rsg_index_info").Scan(&version, &sourceSize, &sourceModTime, &fullText)
+	if err != nil {
+		return false, false
+	}
+	upToDate := version == mappingIndexVersion && sourceSize == stat.Size() && sourceModTime == stat.ModTime().UnixNano()
+	return upToDate, upToDate && fullText
+}
+
+func BuildMappingIndex(mappingFilePath string) error {
+	stat, err := os.Stat(mappingFilePath)
+	if err != nil {
+		return err
+	}
+	sourceDb, err := sql.Open("sqlite3", readOnlyDataSourceName(mappingFilePath))
+	if err != nil {
+		return err
+	}
+	schema, err := DetectMappingSchema(sourceDb)
+	sourceDb.Close()
+	if err != nil {
+		return err
+	}
+
+	indexFilePath := mappingIndexFilePath(mappingFilePath)
+	tmpIndexFilePath := indexFilePath + ".tmp"
+	os.Remove(tmpIndexFilePath)
+	db, err := sql.Open("sqlite3", tmpIndexFilePath)
+	if err != nil {
+		return err
+	}
+	// only one connection to keep the attached database
+	db.SetMaxOpenConns(1)
+	fullText, err := buildMappingIndex(db, schema, mappingFilePath)
+	if err == nil {
+		_, err = db.Exec("INSERT INTO rsg_index_info (version, sourceSize, sourceModTime, fullText) VALUES (?, ?, ?, ?)",
+			mappingIndexVersion, stat.Size(), stat.ModTime().UnixNano(), fullText)
+	}
+	db.Close()
+	if err != nil {
+		os.Remove(tmpIndexFilePath)
+		return err
+	}
+	outputs.Printfln(outputs.Verbose, "Mapping index built (full text search: %v)", fullText)
+	return os.Rename(tmpIndexFilePath, indexFilePath)
+}
+
+func buildMappingIndex(db *sql.DB, schema *MappingSchema, mappingFilePath string) (bool, error) {
+	columns := []string{"`key` INTEGER PRIMARY KEY", "`shareName` TEXT", "`basePath` TEXT", "`archiveID` TEXT", "`fileSize` INTEGER"}
+	selectedColumns := []string{quoteIdentifier(schema.Key), quoteIdentifier(schema.ShareName), quoteIdentifier(schema.BasePath), quoteIdentifier(schema.ArchiveId), quoteIdentifier(schema.FileSize)}
+	for _, metadataColumn := range [][2]string{{"mtime", schema.Mtime}, {"ctime", schema.Ctime}, {"mode", schema.Mode}} {
+		if metadataColumn[1] != "" {
+			columns = append(columns, quoteIdentifier(metadataColumn[0]))
+			selectedColumns = append(selectedColumns, quoteIdentifier(metadataColumn[1]))
+		}
+	}
+	statements := []string{
+		"ATTACH DATABASE '" + strings.Replace(readOnlyDataSourceName(mappingFilePath), "'", "''", -1) + "' AS source",
+		"CREATE TABLE rsg_index_info (version INTEGER, sourceSize INTEGER, sourceModTime INTEGER, fullText INTEGER)",
+		"CREATE TABLE `" + mappingTable + "` (" + strings.Join(columns, ", ") + ")",
+		"INSERT INTO `" + mappingTable + "` SELECT " + strings.Join(selectedColumns, ", ") + " FROM source." + quoteIdentifier(schema.Table),
+		"DETACH DATABASE source",
+		"CREATE INDEX file_info_tb_archiveID ON `" + mappingTable + "` (archiveID)",
+		"CREATE INDEX file_info_tb_basePath ON `" + mappingTable + "` (basePath COLLATE NOCASE)",
+		"CREATE INDEX file_info_tb_path ON `" + mappingTable + "` (shareName, basePath)",
+	}
+	for _, statement := range statements {
+		outputs.Printfln(outputs.Verbose, "Mapping index: %v", statement)
+		if _, err := db.Exec(statement); err != nil {
+			return false, errors.New(fmt.Sprintf("%v (%s)", err, statement))
+		}
+	}
+	if _, err := db.Exec("CREATE VIRTUAL TABLE " + mappingIndexFullTextTable + " USING fts5(shareName, basePath, content='" + mappingTable + "', content_rowid='key')"); err != nil {
+		outputs.Printfln(outputs.Verbose, "Full text search not available: %v", err)
+		return false, nil
+	}
+	if _, err := db.Exec("INSERT INTO " + mappingIndexFullTextTable + "(" + mappingIndexFullTextTable + ") VALUES('rebuild')"); err != nil {
+		return false, err
+	}
+	return true, nil
+}
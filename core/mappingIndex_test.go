@@ -0,0 +1,112 @@
+package core
+
+import (
+	"testing"
+	"crypto/sha256"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+	"rsg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func fileSha256(t *testing.T, filePath string) [32]byte {
+	content, err := ioutil.ReadFile(filePath)
+	assert.Nil(t, err)
+	return sha256.Sum256(content)
+}
+
+func queryPlan(t *testing.T, db *sql.DB, query string, args ...interface{}) string {
+	rows, err := db.Query("EXPLAIN QUERY PLAN " + query, args...)
+	assert.Nil(t, err)
+	defer rows.Close()
+	columns, _ := rows.Columns()
+	plan := ""
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		valuePointers := make([]interface{}, len(columns))
+		for i := range values {
+			valuePointers[i] = &values[i]
+		}
+		rows.Scan(valuePointers...)
+		plan += string(values[len(values) - 1].(string)) + "\n"
+	}
+	return plan
+}
+
+func TestBuildMappingIndex(t *testing.T) {
+	// Given
+	CommonInitTest()
+	mappingFilePath := "../../testtmp/cache/mapping.sqllite"
+	createMappingFromFixture(t, "mapping_dsm6.sqllite", mappingFilePath).Close()
+	mappingSha256 := fileSha256(t, mappingFilePath)
+
+	// When
+	err := BuildMappingIndex(mappingFilePath)
+
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, mappingSha256, fileSha256(t, mappingFilePath))
+	upToDate, _ := mappingIndexIsUpToDate(mappingFilePath)
+	assert.True(t, upToDate)
+
+	db, _ := sql.Open("sqlite3", mappingIndexFilePath(mappingFilePath))
+	defer db.Close()
+	assert.Contains(t, queryPlan(t, db, "SELECT basePath FROM file_info_tb WHERE archiveID = ?", "archiveId1"), "file_info_tb_archiveID")
+	assert.Contains(t, queryPlan(t, db, "SELECT basePath FROM file_info_tb WHERE basePath LIKE 'data/%'"), "file_info_tb_basePath")
+
+	mappingStore := InitMappingStore(mappingFilePath)
+	defer mappingStore.Close()
+	entries, err := mappingStore.EntriesForArchive("archiveId1")
+	assert.Nil(t, err)
+	mtime := time.Unix(1262304000, 0)
+	ctime := time.Unix(1230768000, 0)
+	mode := os.FileMode(0644)
+	assert.Equal(t, []*MappingEntry{{Key: 1,
+		ShareName: "share",
+		BasePath: "data/file1.txt",
+		ArchiveId: "archiveId1",
+		FileSize: 5,
+		Mtime: &mtime,
+		Ctime: &ctime,
+		Mode: &mode}}, entries)
+}
+
+func TestBuildMappingIndex_is_outdated_when_mapping_file_changes(t *testing.T) {
+	// Given
+	CommonInitTest()
+	mappingFilePath := "../../testtmp/cache/mapping.sqllite"
+	createMappingFromFixture(t, "mapping_dsm5.sqllite", mappingFilePath).Close()
+	BuildMappingIndex(mappingFilePath)
+
+	// When
+	os.Remove(mappingFilePath)
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", mappingFilePath)
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file3.txt', 'archiveId3', 3);")
+	db.Close()
+
+	// Then
+	upToDate, _ := mappingIndexIsUpToDate(mappingFilePath)
+	assert.False(t, upToDate)
+	mappingStore := InitMappingStore(mappingFilePath)
+	defer mappingStore.Close()
+	paths, _ := mappingStore.PathsForArchive("archiveId3")
+	assert.Equal(t, []string{"share/data/file3.txt"}, paths)
+}
+
+func TestIndexMappingFileIfNecessary_warns_when_mapping_file_is_not_supported(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := DefaultRestorationContext(nil)
+	createMappingFromFixture(t, "mapping_unknown.sqllite", restorationContext.GetMappingFilePath()).Close()
+
+	// When
+	IndexMappingFileIfNecessary(restorationContext)
+
+	// Then
+	assert.True(t, strings.HasPrefix(string(buffer.Bytes()), "Index mapping file..."))
+	assert.Contains(t, string(buffer.Bytes()), "WARNING: Cannot index mapping file, queries could be slow: Unsupported mapping file layout")
+	assert.False(t, utils.Exists(mappingIndexFilePath(restorationContext.GetMappingFilePath())))
+}
@@ -0,0 +1,274 @@
+package core
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"rsg/outputs"
+)
+
+// Detect the layout of the mapping file written by Synology Glacier Backup and map its columns onto MappingEntry.
+// The layout is identified by the set of columns of file_info_tb written by each version of DSM, the newest version
+// whose columns all exist is detected. Metadata columns (mtime, ctime and mode) are used when they exist.
+
+const mappingTable = "file_info_tb"
+
+type MappingSchema struct {
+	Version   string // version of DSM which wrote the mapping file
+	Table     string
+	Key       string
+	ShareName string
+	BasePath  string
+	ArchiveId string
+	FileSize  string
+	Mtime     string // empty if the column doesn't exist
+	Ctime     string // empty if the column doesn't exist
+	Mode      string // empty if the column doesn't exist
+}
+
+type MappingEntry struct {
+	Key       int64
+	ShareName string
+	BasePath  string
+	ArchiveId string
+	FileSize  uint64
+	Mtime     *time.Time
+	Ctime     *time.Time
+	Mode      *os.FileMode
+}
+
+// Path of the file in the destination directory
+func (mappingEntry *MappingEntry) Path() string {
+	return mappingEntry.ShareName + "/" + mappingEntry.BasePath
+}
+
+type mappingVersion struct {
+	Version string
+	Columns []string
+}
+
+// Columns of file_info_tb written by each version of DSM, oldest first
+var dsmMappingVersions = []mappingVersion{
+	{"DSM 5", []string{"key", "shareName", "basePath", "archiveID", "fileSize"}},
+	{"DSM 6", []string{"key", "shareName", "basePath", "archiveID", "fileSize", "mtime", "ctime", "mode"}},
+}
+
+func missingColumns(columns map[string]string, version mappingVersion) []string {
+	missingColumns := []string{}
+	for _, column := range version.Columns {
+		if columns[strings.ToLower(column)] == "" {
+			missingColumns = append(missingColumns, column)
+		}
+	}
+	return missingColumns
+}
+
+// Newest version whose columns all exist, or else the version with the fewest missing columns and its missing columns
+func detectMappingVersion(columns map[string]string) (mappingVersion, []string) {
+	detected := dsmMappingVersions[0]
+	detectedMissingColumns := missingColumns(columns, detected)
+	for _, version := range dsmMappingVersions[1:] {
+		if missing := missingColumns(columns, version); len(missing) == 0 || len(missing) < len(detectedMissingColumns) {
+			detected, detectedMissingColumns = version, missing
+		}
+	}
+	return detected, detectedMissingColumns
+}
+
+func DetectMappingSchema(db *sql.DB) (*MappingSchema, error) {
+	tables, err := getTables(db)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot read mapping file: %v", err))
+	}
+	if !tables[mappingTable] {
+		return nil, errors.New(fmt.Sprintf("Unsupported mapping file layout, table %s not found (tables found: %s)",
+			mappingTable, strings.Join(sortedKeys(tables), ", ")))
+	}
+	columns, err := getColumns(db, mappingTable)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Cannot read mapping file: %v", err))
+	}
+	version, missingColumns := detectMappingVersion(columns)
+	if len(missingColumns) > 0 {
+		return nil, errors.New(fmt.Sprintf("Unsupported mapping file layout, closest layout is %s but columns %s are not found in %s (columns found: %s)",
+			version.Version, strings.Join(missingColumns, ", "), mappingTable, strings.Join(sortedValues(columns), ", ")))
+	}
+	schema := &MappingSchema{Version: version.Version,
+		Table: mappingTable,
+		Key: columns["key"],
+		ShareName: columns["sharename"],
+		BasePath: columns["basepath"],
+		ArchiveId: columns["archiveid"],
+		FileSize: columns["filesize"],
+		Mtime: columns["mtime"],
+		Ctime: columns["ctime"],
+		Mode: columns["mode"]}
+	outputs.Printfln(outputs.Verbose, "Mapping file layout: %+v", *schema)
+	return schema, nil
+}
+
+func getTables(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = 'table'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables[name] = true
+	}
+	return tables, rows.Err()
+}
+
+// Column names of a table by lower case name, from PRAGMA table_info
+func getColumns(db *sql.DB, table string) (map[string]string, error) {
+	rows, err := db.Query("PRAGMA table_info(" + quoteIdentifier(table) + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]string)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name string
+		var columnType, defaultValue sql.NullString
+		if err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[strings.ToLower(name)] = name
+	}
+	return columns, rows.Err()
+}
+
+func sortedKeys(values map[string]bool) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedValues(values map[string]string) []string {
+	sortedValues := make([]string, 0, len(values))
+	for _, value := range values {
+		sortedValues = append(sortedValues, value)
+	}
+	sort.Strings(sortedValues)
+	return sortedValues
+}
+
+func quoteIdentifier(identifier string) string {
+	return "`" + strings.Replace(identifier, "`", "``", -1) + "`"
+}
+
+// Sql expression of a column, NULL if the column doesn't exist
+func columnOrNull(column string) string {
+	if column == "" {
+		return "NULL"
+	}
+	return quoteIdentifier(column)
+}
+
+// Sql expression of the path in the destination directory
+func (schema *MappingSchema) pathExpression() string {
+	return quoteIdentifier(schema.ShareName) + " || '/' || " + quoteIdentifier(schema.BasePath)
+}
+
+func (schema *MappingSchema) entryColumns() string {
+	return strings.Join([]string{quoteIdentifier(schema.Key),
+		quoteIdentifier(schema.ShareName),
+		quoteIdentifier(schema.BasePath),
+		quoteIdentifier(schema.ArchiveId),
+		quoteIdentifier(schema.FileSize),
+		columnOrNull(schema.Mtime),
+		columnOrNull(schema.Ctime),
+		columnOrNull(schema.Mode)}, ", ")
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scans a row selected with entryColumns
+func scanMappingEntry(row rowScanner) (*MappingEntry, error) {
+	var key sql.NullInt64
+	var shareName, basePath, archiveId sql.NullString
+	var fileSize sql.NullInt64
+	var mtime, ctime sql.NullString
+	var mode interface{}
+	if err := row.Scan(&key, &shareName, &basePath, &archiveId, &fileSize, &mtime, &ctime, &mode); err != nil {
+		return nil, err
+	}
+	mappingEntry := &MappingEntry{Key: key.Int64,
+		ShareName: shareName.String,
+		BasePath: basePath.String,
+		ArchiveId: archiveId.String,
+		FileSize: uint64(fileSize.Int64)}
+	if value, ok := parseMappingTime(mtime); ok {
+		mappingEntry.Mtime = &value
+	}
+	if value, ok := parseMappingTime(ctime); ok {
+		mappingEntry.Ctime = &value
+	}
+	if value, ok := parseMappingMode(mode); ok {
+		mappingEntry.Mode = &value
+	}
+	return mappingEntry, nil
+}
+
+// Time is stored as unix timestamp (seconds or milliseconds) or as text date
+func parseMappingTime(value sql.NullString) (time.Time, bool) {
+	if !value.Valid || value.String == "" {
+		return time.Time{}, false
+	}
+	if timestamp, err := strconv.ParseInt(value.String, 10, 64); err == nil {
+		if timestamp <= 0 {
+			return time.Time{}, false
+		}
+		if timestamp > 100000000000 {
+			return time.Unix(timestamp / 1000, (timestamp % 1000) * int64(time.Millisecond)), true
+		}
+		return time.Unix(timestamp, 0), true
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006/01/02 15:04:05"} {
+		if parsedTime, err := time.Parse(layout, value.String); err == nil {
+			return parsedTime, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// DSM stores the st_mode as integer (33188 for 0100644), a text mode is octal permissions ("644", "0644" or st_mode
+// "100644"). A mode without permission is unknown.
+func parseMappingMode(value interface{}) (os.FileMode, bool) {
+	var mode uint64
+	switch value := value.(type) {
+	case int64:
+		if value <= 0 {
+			return 0, false
+		}
+		mode = uint64(value)
+	case string, []byte:
+		parsedMode, err := strconv.ParseUint(fmt.Sprintf("%s", value), 8, 32)
+		if err != nil {
+			return 0, false
+		}
+		mode = parsedMode
+	default:
+		return 0, false
+	}
+	if os.FileMode(mode) & os.ModePerm == 0 {
+		return 0, false
+	}
+	return os.FileMode(mode) & os.ModePerm, true
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"testing"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"time"
+	"github.com/stretchr/testify/assert"
+)
+
+// Copy of a mapping file of testdata, one per version of DSM
+func createMappingFromFixture(t *testing.T, fixture, mappingFilePath string) *sql.DB {
+	content, err := ioutil.ReadFile("testdata/" + fixture)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(mappingFilePath, content, 0600))
+	db, _ := sql.Open("sqlite3", mappingFilePath)
+	return db
+}
+
+func TestDetectMappingSchema_dsm5(t *testing.T) {
+	// Given
+	CommonInitTest()
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", "../../testtmp/cache/mapping.sqllite")
+	defer db.Close()
+
+	// When
+	schema, err := DetectMappingSchema(db)
+
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, &MappingSchema{Version: "DSM 5",
+		Table: "file_info_tb",
+		Key: "key",
+		ShareName: "shareName",
+		BasePath: "basePath",
+		ArchiveId: "archiveID",
+		FileSize: "fileSize"}, schema)
+}
+
+func TestDetectMappingSchema_dsm6(t *testing.T) {
+	// Given
+	CommonInitTest()
+	db := createMappingFromFixture(t, "mapping_dsm6.sqllite", "../../testtmp/cache/mapping.sqllite")
+	defer db.Close()
+
+	// When
+	schema, err := DetectMappingSchema(db)
+
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, &MappingSchema{Version: "DSM 6",
+		Table: "file_info_tb",
+		Key: "key",
+		ShareName: "shareName",
+		BasePath: "basePath",
+		ArchiveId: "archiveID",
+		FileSize: "fileSize",
+		Mtime: "mtime",
+		Ctime: "ctime",
+		Mode: "mode"}, schema)
+}
+
+func TestDetectMappingSchema_unknown_layout(t *testing.T) {
+	// Given
+	CommonInitTest()
+	db := createMappingFromFixture(t, "mapping_unknown.sqllite", "../../testtmp/cache/mapping.sqllite")
+	defer db.Close()
+
+	// When
+	schema, err := DetectMappingSchema(db)
+
+	// Then
+	assert.Nil(t, schema)
+	assert.Equal(t, "Unsupported mapping file layout, closest layout is DSM 5 but columns shareName, basePath, fileSize are not found " +
+		"in file_info_tb (columns found: archiveID, key, name)", err.Error())
+}
+
+func TestDetectMappingSchema_table_not_found(t *testing.T) {
+	// Given
+	CommonInitTest()
+	db, _ := sql.Open("sqlite3", "../../testtmp/cache/mapping.sqllite")
+	db.Exec("CREATE TABLE `version_tb` (`version` INTEGER);")
+	defer db.Close()
+
+	// When
+	schema, err := DetectMappingSchema(db)
+
+	// Then
+	assert.Nil(t, schema)
+	assert.Equal(t, "Unsupported mapping file layout, table file_info_tb not found (tables found: version_tb)", err.Error())
+}
+
+func TestDetectMappingSchema_not_a_mapping_file(t *testing.T) {
+	// Given
+	CommonInitTest()
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("hello !"), 0600)
+	db, _ := sql.Open("sqlite3", "../../testtmp/cache/mapping.sqllite")
+	defer db.Close()
+
+	// When
+	schema, err := DetectMappingSchema(db)
+
+	// Then
+	assert.Nil(t, schema)
+	assert.Equal(t, "Cannot read mapping file: file is not a database", err.Error())
+}
+
+func TestEntriesForArchive_dsm5(t *testing.T) {
+	// Given
+	CommonInitTest()
+	createMappingFromFixture(t, "mapping_dsm5.sqllite", "../../testtmp/cache/mapping.sqllite").Close()
+	mappingStore := InitMappingStore("../../testtmp/cache/mapping.sqllite")
+	defer mappingStore.Close()
+
+	// When
+	entries, err := mappingStore.EntriesForArchive("archiveId2")
+
+	// Then
+	assert.Nil(t, err)
+	assert.False(t, mappingStore.HasFileMetadata())
+	assert.Equal(t, []*MappingEntry{{Key: 2,
+		ShareName: "share",
+		BasePath: "data/file2.txt",
+		ArchiveId: "archiveId2",
+		FileSize: 12}}, entries)
+}
+
+func TestEntriesForArchive_dsm6(t *testing.T) {
+	// Given
+	CommonInitTest()
+	createMappingFromFixture(t, "mapping_dsm6.sqllite", "../../testtmp/cache/mapping.sqllite").Close()
+	mappingStore := InitMappingStore("../../testtmp/cache/mapping.sqllite")
+	defer mappingStore.Close()
+
+	// When
+	entries1, err1 := mappingStore.EntriesForArchive("archiveId1")
+	entries2, err2 := mappingStore.EntriesForArchive("archiveId2")
+
+	// Then
+	assert.Nil(t, err1)
+	assert.Nil(t, err2)
+	assert.True(t, mappingStore.HasFileMetadata())
+	mtime := time.Unix(1262304000, 0)
+	ctime := time.Unix(1230768000, 0)
+	mode := os.FileMode(0644)
+	assert.Equal(t, []*MappingEntry{{Key: 1,
+		ShareName: "share",
+		BasePath: "data/file1.txt",
+		ArchiveId: "archiveId1",
+		FileSize: 5,
+		Mtime: &mtime,
+		Ctime: &ctime,
+		Mode: &mode}}, entries1)
+	assert.Equal(t, []*MappingEntry{{Key: 2,
+		ShareName: "share",
+		BasePath: "data/file2.txt",
+		ArchiveId: "archiveId2",
+		FileSize: 12,
+		Ctime: &ctime}}, entries2)
+}
+
+func TestParseMappingMode(t *testing.T) {
+	for value, expected := range map[string]os.FileMode{"644": 0644, "0600": 0600, "100755": 0755} {
+		mode, ok := parseMappingMode(value)
+		assert.True(t, ok, value)
+		assert.Equal(t, expected, mode, value)
+	}
+	for _, value := range []string{"", "0", "100000", "33188", "rw-r--r--"} {
+		_, ok := parseMappingMode(value)
+		assert.False(t, ok, value)
+	}
+	_, ok := parseMappingMode(nil)
+	assert.False(t, ok)
+}
+
+func TestParseMappingMode_integer_st_mode(t *testing.T) {
+	for value, expected := range map[int64]os.FileMode{33188: 0644, 33261: 0755} {
+		mode, ok := parseMappingMode(value)
+		assert.True(t, ok, "%d", value)
+		assert.Equal(t, expected, mode, "%d", value)
+	}
+	for _, value := range []int64{0, -1, 32768} {
+		_, ok := parseMappingMode(value)
+		assert.False(t, ok, "%d", value)
+	}
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"rsg/outputs"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Size aggregations of the mapping file (du and stats commands).
+// The size of files is the sum of file sizes, the retrieval size is the size of distinct archives, several paths
+// can be stored in the same archive.
+
+// Upper bounds of histogram buckets, the last bucket has no upper bound
+var sizeHistogramBounds = []uint64{1024, 1024 * 1024, 10 * 1024 * 1024, 100 * 1024 * 1024, 1024 * 1024 * 1024}
+
+type sizeStats struct {
+	files         int
+	size          uint64
+	retrievalSize uint64
+	archiveIds    map[string]bool
+}
+
+func newSizeStats() *sizeStats {
+	return &sizeStats{archiveIds: make(map[string]bool)}
+}
+
+func (stats *sizeStats) add(mappingEntry *MappingEntry) {
+	stats.files++
+	stats.size += mappingEntry.FileSize
+	if !stats.archiveIds[mappingEntry.ArchiveId] {
+		stats.archiveIds[mappingEntry.ArchiveId] = true
+		stats.retrievalSize += mappingEntry.FileSize
+	}
+}
+
+// Ratio of paths by distinct archives
+func (stats *sizeStats) dedupRatio() float64 {
+	if len(stats.archiveIds) == 0 {
+		return 1
+	}
+	return float64(stats.files) / float64(len(stats.archiveIds))
+}
+
+func (stats *sizeStats) String() string {
+	return fmt.Sprintf("%s\t%s\t%d", bytefmt.ByteSize(stats.size), bytefmt.ByteSize(stats.retrievalSize), stats.files)
+}
+
+// Size by entry of the directory (first argument, root if none), shares are entries of root
+func DiskUsage(restorationContext *RestorationContext) {
+	dirPath := ""
+	if len(restorationContext.Options.CommandArgs) > 0 {
+		dirPath = strings.Trim(restorationContext.Options.CommandArgs[0], "/")
+	}
+	filter := newFacetFilter(restorationContext.Options)
+	if dirPath != "" {
+		filter.Paths = []string{dirPath}
+	}
+	statsByPath := make(map[string]*sizeStats)
+	total := newSizeStats()
+	iterateFiles(restorationContext, filter, func(mappingEntry *MappingEntry) {
+		entryPath := mappingEntry.Path()
+		if entryPath != dirPath {
+			relativePath := strings.TrimPrefix(strings.TrimPrefix(entryPath, dirPath), "/")
+			entryPath = strings.TrimPrefix(dirPath + "/" + strings.SplitN(relativePath, "/", 2)[0], "/")
+		}
+		if _, ok := statsByPath[entryPath]; !ok {
+			statsByPath[entryPath] = newSizeStats()
+		}
+		statsByPath[entryPath].add(mappingEntry)
+		total.add(mappingEntry)
+	})
+	outputs.Println(outputs.OptionalInfo, "size\tretrieval\tfiles\tpath")
+	for _, entryPath := range sortedStatsKeys(statsByPath) {
+		outputs.Printfln(outputs.Info, "%v\t%s", statsByPath[entryPath], entryPath)
+	}
+	outputs.Printfln(outputs.Info, "%v\ttotal", total)
+}
+
+// Totals by share, largest files, counts by extension, size histogram and deduplication ratio
+func MappingStats(restorationContext *RestorationContext) {
+	statsByShare := make(map[string]*sizeStats)
+	statsByExtension := make(map[string]*sizeStats)
+	histogram := make([]*sizeStats, len(sizeHistogramBounds) + 1)
+	for i := range histogram {
+		histogram[i] = newSizeStats()
+	}
+	total := newSizeStats()
+	largestFiles := []*MappingEntry{}
+	iterateFiles(restorationContext, newFacetFilter(restorationContext.Options), func(mappingEntry *MappingEntry) {
+		if _, ok := statsByShare[mappingEntry.ShareName]; !ok {
+			statsByShare[mappingEntry.ShareName] = newSizeStats()
+		}
+		statsByShare[mappingEntry.ShareName].add(mappingEntry)
+		extension := strings.ToLower(path.Ext(mappingEntry.BasePath))
+		if _, ok := statsByExtension[extension]; !ok {
+			statsByExtension[extension] = newSizeStats()
+		}
+		statsByExtension[extension].add(mappingEntry)
+		histogram[getSizeHistogramBucket(mappingEntry.FileSize)].add(mappingEntry)
+		total.add(mappingEntry)
+		largestFiles = addLargestFile(largestFiles, mappingEntry, restorationContext.Options.Top)
+	})
+
+	outputs.Println(outputs.Info, "Shares (size, retrieval, files):")
+	for _, shareName := range sortedStatsKeys(statsByShare) {
+		outputs.Printfln(outputs.Info, "  %v\t%s", statsByShare[shareName], shareName)
+	}
+	outputs.Printfln(outputs.Info, "Largest files:")
+	for _, mappingEntry := range largestFiles {
+		outputs.Printfln(outputs.Info, "  %s\t%s", bytefmt.ByteSize(mappingEntry.FileSize), mappingEntry.Path())
+	}
+	outputs.Println(outputs.Info, "Extensions (size, retrieval, files):")
+	for _, extension := range sortedStatsKeys(statsByExtension) {
+		name := extension
+		if name == "" {
+			name = "(none)"
+		}
+		outputs.Printfln(outputs.Info, "  %v\t%s", statsByExtension[extension], name)
+	}
+	outputs.Println(outputs.Info, "Size histogram (size, retrieval, files):")
+	for i, stats := range histogram {
+		outputs.Printfln(outputs.Info, "  %v\t%s", stats, getSizeHistogramBucketName(i))
+	}
+	outputs.Printfln(outputs.Info, "Total: %s in %d path(s), %s in %d distinct archive(s), dedup ratio %.2f",
+		bytefmt.ByteSize(total.size), total.files, bytefmt.ByteSize(total.retrievalSize), len(total.archiveIds), total.dedupRatio())
+}
+
+func iterateFiles(restorationContext *RestorationContext, filter MappingFilter, fn func(mappingEntry *MappingEntry)) {
+	mappingStore := InitMappingStore(restorationContext.GetMappingFilePath())
+	defer mappingStore.Close()
+	fileIterator, err := mappingStore.Files(filter)
+	utils.ExitIfError(err)
+	defer fileIterator.Close()
+	for fileIterator.Next() {
+		fn(fileIterator.Entry())
+	}
+	utils.ExitIfError(fileIterator.Err())
+}
+
+// Keeps the n largest files ordered by size (descending)
+func addLargestFile(largestFiles []*MappingEntry, mappingEntry *MappingEntry, n int) []*MappingEntry {
+	index := sort.Search(len(largestFiles), func(i int) bool {
+		return largestFiles[i].FileSize < mappingEntry.FileSize
+	})
+	if index >= n {
+		return largestFiles
+	}
+	largestFiles = append(largestFiles, nil)
+	copy(largestFiles[index + 1:], largestFiles[index:])
+	largestFiles[index] = mappingEntry
+	if len(largestFiles) > n {
+		largestFiles = largestFiles[:n]
+	}
+	return largestFiles
+}
+
+func getSizeHistogramBucket(size uint64) int {
+	for i, bound := range sizeHistogramBounds {
+		if size < bound {
+			return i
+		}
+	}
+	return len(sizeHistogramBounds)
+}
+
+func getSizeHistogramBucketName(bucket int) string {
+	if bucket == len(sizeHistogramBounds) {
+		return ">= " + bytefmt.ByteSize(sizeHistogramBounds[bucket - 1])
+	}
+	return "< " + bytefmt.ByteSize(sizeHistogramBounds[bucket])
+}
+
+func sortedStatsKeys(statsByKey map[string]*sizeStats) []string {
+	keys := make([]string, 0, len(statsByKey))
+	for key := range statsByKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func initMappingStatsTest(t *testing.T) *RestorationContext {
+	restorationContext := DefaultRestorationContext(nil)
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", restorationContext.GetMappingFilePath())
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/copy/file1.txt', 'archiveId1', 5);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'readme', 'archiveId4', 2048);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('photos', '2016/beach.JPG', 'archiveId3', 2097152);")
+	db.Close()
+	return restorationContext
+}
+
+func TestDiskUsage_of_shares(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initMappingStatsTest(t)
+	buffer.Reset()
+
+	// When
+	DiskUsage(restorationContext)
+
+	// Then
+	assert.Equal(t, "size\tretrieval\tfiles\tpath\n" +
+		"2M\t2M\t1\tphotos\n" +
+		"2K\t2K\t4\tshare\n" +
+		"2M\t2M\t5\ttotal\n", string(buffer.Bytes()))
+}
+
+func TestDiskUsage_of_directory(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initMappingStatsTest(t)
+	restorationContext.Options.CommandArgs = []string{"/share/data/"}
+	buffer.Reset()
+
+	// When
+	DiskUsage(restorationContext)
+
+	// Then
+	assert.Equal(t, "size\tretrieval\tfiles\tpath\n" +
+		"5B\t5B\t1\tshare/data/copy\n" +
+		"5B\t5B\t1\tshare/data/file1.txt\n" +
+		"12B\t12B\t1\tshare/data/file2.txt\n" +
+		"22B\t17B\t3\ttotal\n", string(buffer.Bytes()))
+}
+
+func TestDiskUsage_of_file(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initMappingStatsTest(t)
+	restorationContext.Options.CommandArgs = []string{"share/readme"}
+	buffer.Reset()
+
+	// When
+	DiskUsage(restorationContext)
+
+	// Then
+	assert.Equal(t, "size\tretrieval\tfiles\tpath\n" +
+		"2K\t2K\t1\tshare/readme\n" +
+		"2K\t2K\t1\ttotal\n", string(buffer.Bytes()))
+}
+
+func TestMappingStats(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initMappingStatsTest(t)
+	restorationContext.Options.Top = 2
+	buffer.Reset()
+
+	// When
+	MappingStats(restorationContext)
+
+	// Then
+	assert.Equal(t, "Shares (size, retrieval, files):\n" +
+		"  2M\t2M\t1\tphotos\n" +
+		"  2K\t2K\t4\tshare\n" +
+		"Largest files:\n" +
+		"  2M\tphotos/2016/beach.JPG\n" +
+		"  2K\tshare/readme\n" +
+		"Extensions (size, retrieval, files):\n" +
+		"  2K\t2K\t1\t(none)\n" +
+		"  2M\t2M\t1\t.jpg\n" +
+		"  22B\t17B\t3\t.txt\n" +
+		"Size histogram (size, retrieval, files):\n" +
+		"  22B\t17B\t3\t< 1K\n" +
+		"  2K\t2K\t1\t< 1M\n" +
+		"  2M\t2M\t1\t< 10M\n" +
+		"  0B\t0B\t0\t< 100M\n" +
+		"  0B\t0B\t0\t< 1G\n" +
+		"  0B\t0B\t0\t>= 1G\n" +
+		"Total: 2M in 5 path(s), 2M in 4 distinct archive(s), dedup ratio 1.25\n", string(buffer.Bytes()))
+}
+
+func TestAddLargestFile(t *testing.T) {
+	// Given
+	largestFiles := []*MappingEntry{}
+
+	// When
+	for _, size := range []uint64{3, 1, 5, 4, 2} {
+		largestFiles = addLargestFile(largestFiles, &MappingEntry{FileSize: size}, 3)
+	}
+
+	// Then
+	sizes := []uint64{}
+	for _, mappingEntry := range largestFiles {
+		sizes = append(sizes, mappingEntry.FileSize)
+	}
+	assert.Equal(t, []uint64{5, 4, 3}, sizes)
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"rsg/awsutils"
+	"rsg/utils"
+)
+
+// Access to the content of the mapping file.
+// Iterators must be closed, errors of iteration are returned by Err() after Next() returns false.
+
+type MappingStore interface {
+	// Files matching the filter, ordered by base path
+	Files(filter MappingFilter) (MappingEntryIterator, error)
+	// Distinct archives of files matching the filter, ordered by key
+	Archives(filter MappingFilter) (ArchiveIterator, error)
+	// Distinct paths (share name + '/' + base path) of files stored in the archive
+	PathsForArchive(archiveId string) ([]string, error)
+	// Files stored in the archive
+	EntriesForArchive(archiveId string) ([]*MappingEntry, error)
+	// Entries of paths matching the filter stored in several distinct archives, by path, ordered by key
+	Versions(filter MappingFilter) (map[string][]*MappingEntry, error)
+	// Size of distinct archives of files matching the filter
+	TotalSize(filter MappingFilter) (uint64, error)
+	// Files and directories in the directory (share name + '/' + base path), shares if the path is empty, ordered by name
+	Directory(path string) ([]DirectoryEntry, error)
+	// True if entries have modification time, creation time or mode
+	HasFileMetadata() bool
+	// True if terms of filters with FullText are searched with full text search, else they are searched as substrings
+	HasFullText() bool
+	Close() error
+}
+
+// Files matching all criteria of the filter, all files if the filter is empty
+type MappingFilter struct {
+	Patterns   []string // at least one pattern (globals * and ?) matches the base path
+	Terms      []string // each term is found in the path (share name + '/' + base path), case insensitive
+	FullText   bool     // terms are searched as word prefixes of path segments when full text search is available
+	Extensions []string // the base path ends with one of the extensions (without dot), case insensitive
+	Shares     []string // the file is in one of the shares
+	Paths      []string // the path (share name + '/' + base path) is one of the paths or is in one of the directories
+	ArchiveIds []string // the file is stored in one of the archives
+	MinSize    uint64
+	MaxSize    uint64   // no maximum if 0
+	Version    string   // only the selected version of paths (latest, oldest or key), all versions if empty
+}
+
+// File or directory in a directory of the mapping
+type DirectoryEntry struct {
+	Name string
+	Dir  bool
+	Size uint64 // sum of file sizes for directories
+}
+
+type MappingEntryIterator interface {
+	Next() bool
+	Entry() *MappingEntry
+	Err() error
+	Close() error
+}
+
+type ArchiveIterator interface {
+	Next() bool
+	Archive() awsutils.Archive
+	Err() error
+	Close() error
+}
+
+func NewMappingFilter(patterns []string) MappingFilter {
+	return MappingFilter{Patterns: patterns}
+}
+
+func InitMappingStore(file string) MappingStore {
+	mappingStore, err := OpenMappingStore(file)
+	utils.ExitIfError(err)
+	return mappingStore
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"testing"
+	"rsg/awsutils"
+	"rsg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// Same scenarios are played on sqlite and in memory stores
+
+func mappingStoresForTest(t *testing.T) map[string]MappingStore {
+	CommonInitTest()
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", "../../testtmp/cache/mapping.sqllite")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/copy/file1.txt', 'archiveId1', 5);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'other/file3.txt', 'archiveId3', 7);")
+	db.Close()
+	return map[string]MappingStore{
+		"sqlite": InitMappingStore("../../testtmp/cache/mapping.sqllite"),
+		"memory": newMemoryMappingStore(
+			&MappingEntry{ShareName: "share", BasePath: "data/file1.txt", ArchiveId: "archiveId1", FileSize: 5},
+			&MappingEntry{ShareName: "share", BasePath: "data/file2.txt", ArchiveId: "archiveId2", FileSize: 12},
+			&MappingEntry{ShareName: "share", BasePath: "data/copy/file1.txt", ArchiveId: "archiveId1", FileSize: 5},
+			&MappingEntry{ShareName: "share", BasePath: "other/file3.txt", ArchiveId: "archiveId3", FileSize: 7}),
+	}
+}
+
+func TestMappingStore_files_with_filter(t *testing.T) {
+	for name, mappingStore := range mappingStoresForTest(t) {
+		// When
+		fileIterator, err := mappingStore.Files(NewMappingFilter([]string{"data/*", "OTHER/file?.txt"}))
+
+		// Then
+		assert.Nil(t, err, name)
+		basePaths := []string{}
+		for fileIterator.Next() {
+			basePaths = append(basePaths, fileIterator.Entry().BasePath)
+		}
+		assert.Nil(t, fileIterator.Err(), name)
+		fileIterator.Close()
+		assert.Equal(t, []string{"data/copy/file1.txt", "data/file1.txt", "data/file2.txt", "other/file3.txt"}, basePaths, name)
+		mappingStore.Close()
+	}
+}
+
+func TestMappingStore_archives_and_total_size(t *testing.T) {
+	for name, mappingStore := range mappingStoresForTest(t) {
+		// When
+		archiveIterator, err := mappingStore.Archives(NewMappingFilter([]string{"data/*"}))
+		totalSize, totalSizeErr := mappingStore.TotalSize(NewMappingFilter([]string{"data/*"}))
+
+		// Then
+		assert.Nil(t, err, name)
+		archives := []awsutils.Archive{}
+		for archiveIterator.Next() {
+			archives = append(archives, archiveIterator.Archive())
+		}
+		assert.Nil(t, archiveIterator.Err(), name)
+		archiveIterator.Close()
+		assert.Equal(t, []awsutils.Archive{{ArchiveId: "archiveId1", Size: 5}, {ArchiveId: "archiveId2", Size: 12}}, archives, name)
+		assert.Nil(t, totalSizeErr, name)
+		assert.Equal(t, uint64(17), totalSize, name)
+		mappingStore.Close()
+	}
+}
+
+func TestMappingStore_paths_for_archive(t *testing.T) {
+	for name, mappingStore := range mappingStoresForTest(t) {
+		// When
+		paths1, err1 := mappingStore.PathsForArchive("archiveId1")
+		paths2, err2 := mappingStore.PathsForArchive("archiveId2")
+		paths4, err4 := mappingStore.PathsForArchive("archiveId4")
+
+		// Then
+		assert.Nil(t, err1, name)
+		assert.Nil(t, err2, name)
+		assert.Nil(t, err4, name)
+		assert.Equal(t, []string{"share/data/file1.txt", "share/data/copy/file1.txt"}, paths1, name)
+		assert.Equal(t, []string{"share/data/file2.txt"}, paths2, name)
+		assert.Equal(t, []string{}, paths4, name)
+		mappingStore.Close()
+	}
+}
+
+func TestMappingStore_directory(t *testing.T) {
+	for name, mappingStore := range mappingStoresForTest(t) {
+		// When
+		shares, sharesErr := mappingStore.Directory("")
+		share, shareErr := mappingStore.Directory("share")
+		data, dataErr := mappingStore.Directory("share/data")
+
+		// Then
+		assert.Nil(t, sharesErr, name)
+		assert.Nil(t, shareErr, name)
+		assert.Nil(t, dataErr, name)
+		assert.Equal(t, []DirectoryEntry{{Name: "share", Dir: true, Size: 29}}, shares, name)
+		assert.Equal(t, []DirectoryEntry{{Name: "data", Dir: true, Size: 22}, {Name: "other", Dir: true, Size: 7}}, share, name)
+		assert.Equal(t, []DirectoryEntry{{Name: "copy", Dir: true, Size: 5}, {Name: "file1.txt", Size: 5}, {Name: "file2.txt", Size: 12}}, data, name)
+		mappingStore.Close()
+	}
+}
+
+func TestMappingStore_files_in_paths(t *testing.T) {
+	for name, mappingStore := range mappingStoresForTest(t) {
+		// When
+		fileIterator, err := mappingStore.Files(MappingFilter{Paths: []string{"share/data/copy", "share/other/file3.txt", "share/dat"}})
+
+		// Then
+		assert.Nil(t, err, name)
+		basePaths := []string{}
+		for fileIterator.Next() {
+			basePaths = append(basePaths, fileIterator.Entry().BasePath)
+		}
+		assert.Nil(t, fileIterator.Err(), name)
+		fileIterator.Close()
+		assert.Equal(t, []string{"data/copy/file1.txt", "other/file3.txt"}, basePaths, name)
+		mappingStore.Close()
+	}
+}
+
+func TestMappingStore_files_of_archives(t *testing.T) {
+	for name, mappingStore := range mappingStoresForTest(t) {
+		// When
+		fileIterator, err := mappingStore.Files(MappingFilter{ArchiveIds: []string{"archiveId1", "archiveId3"}})
+
+		// Then
+		assert.Nil(t, err, name)
+		basePaths := []string{}
+		for fileIterator.Next() {
+			basePaths = append(basePaths, fileIterator.Entry().BasePath)
+		}
+		assert.Nil(t, fileIterator.Err(), name)
+		fileIterator.Close()
+		assert.Equal(t, []string{"data/copy/file1.txt", "data/file1.txt", "other/file3.txt"}, basePaths, name)
+		mappingStore.Close()
+	}
+}
+
+func TestDownloadArchives_with_memory_mapping_store(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: utils.S_1MB,
+		speedAutoUpdate: false,
+		archivesRetrievalSize: 0,
+		archivePartRetrievalListMaxSize: 1,
+		archivePartRetrieveList: nil,
+		hasArchiveRows: false,
+		mappingStore: newMemoryMappingStore(
+			&MappingEntry{ShareName: "share", BasePath: "data/file1.txt", ArchiveId: "archiveId1", FileSize: 5},
+			&MappingEntry{ShareName: "share", BasePath: "data/file2.txt", ArchiveId: "archiveId1", FileSize: 5}),
+		archiveIterator: nil,
+	}
+
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-4", "jobId1")
+	mockDescribeJob(glacierMock, "jobId1", restorationContext.Vault, true)
+	mockPartialOutputJob(glacierMock, "jobId1", restorationContext.Vault, "0-4", []byte("hello"))
+
+	// When
+	downloadContext.downloadArchives()
+
+	// Then
+	assertFileContent(t, "../../testtmp/dest/share/data/file1.txt", "hello")
+	assertFileContent(t, "../../testtmp/dest/share/data/file2.txt", "hello")
+}
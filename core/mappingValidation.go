@@ -0,0 +1,69 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"rsg/awsutils"
+	"rsg/outputs"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/glacier"
+)
+
+// A downloaded mapping file is checked before use: its size must be the size of the archive, its tree hash the one
+// computed by aws (given by the retrieval job or by the inventory), and sqlite must find it sound with a supported
+// layout. An invalid mapping file is downloaded again from the retrieval job, its output stays available about 24
+// hours.
+
+const mappingDownloadMaxAttempts = 3
+
+func validateMappingFile(mappingFilePath string, archive awsutils.Archive, treeHash string) error {
+	file, err := os.Open(mappingFilePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if uint64(stat.Size()) != archive.Size {
+		return errors.New(fmt.Sprintf("Mapping file has %d bytes instead of %d", stat.Size(), archive.Size))
+	}
+	if treeHash != "" {
+		if computedTreeHash := hex.EncodeToString(glacier.ComputeHashes(file).TreeHash); computedTreeHash != treeHash {
+			return errors.New(fmt.Sprintf("Tree hash of mapping file is %s instead of %s", computedTreeHash, treeHash))
+		}
+	} else {
+		outputs.Println(outputs.Verbose, "No tree hash to check mapping file")
+	}
+	db, err := sql.Open("sqlite3", readOnlyDataSourceName(mappingFilePath))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var integrity string
+	if err = db.QueryRow("PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return errors.New(fmt.Sprintf("Mapping file is not a sqlite database: %v", err))
+	}
+	if integrity != "ok" {
+		return errors.New(fmt.Sprintf("Mapping file is corrupted: %s", integrity))
+	}
+	_, err = DetectMappingSchema(db)
+	return err
+}
+
+// Tree hash of the retrieval job, or of the inventory of mapping vault, empty if unknown
+func getMappingArchiveTreeHash(restorationContext *RestorationContext, archiveId, jobId string) string {
+	if jobDescription, err := awsutils.DescribeJob(restorationContext.GlacierClient, restorationContext.MappingVault, jobId); err == nil && aws.StringValue(jobDescription.SHA256TreeHash) != "" {
+		return aws.StringValue(jobDescription.SHA256TreeHash)
+	}
+	for _, archive := range restorationContext.RegionVaultCache.MappingArchives {
+		if archive.ArchiveId == archiveId {
+			return archive.SHA256TreeHash
+		}
+	}
+	return ""
+}
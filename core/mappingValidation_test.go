@@ -0,0 +1,101 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"rsg/awsutils"
+	"rsg/consts"
+	"github.com/aws/aws-sdk-go/service/glacier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMappingFile_valid(t *testing.T) {
+	// Given
+	CommonInitTest()
+	mappingContent := mappingFileContent(t)
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", mappingContent, 0600)
+	treeHash := hex.EncodeToString(glacier.ComputeHashes(bytes.NewReader(mappingContent)).TreeHash)
+
+	// When
+	err := validateMappingFile("../../testtmp/cache/mapping.sqllite", awsutils.Archive{ArchiveId: "mappingArchiveId", Size: uint64(len(mappingContent))}, treeHash)
+
+	// Then
+	assert.Nil(t, err)
+}
+
+func TestValidateMappingFile_truncated(t *testing.T) {
+	// Given
+	CommonInitTest()
+	mappingContent := mappingFileContent(t)
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", mappingContent[:1024], 0600)
+
+	// When
+	err := validateMappingFile("../../testtmp/cache/mapping.sqllite", awsutils.Archive{ArchiveId: "mappingArchiveId", Size: uint64(len(mappingContent))}, "")
+
+	// Then
+	assert.EqualError(t, err, "Mapping file has 1024 bytes instead of " + strconv.Itoa(len(mappingContent)))
+}
+
+func TestValidateMappingFile_wrong_tree_hash(t *testing.T) {
+	// Given
+	CommonInitTest()
+	mappingContent := mappingFileContent(t)
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", mappingContent, 0600)
+
+	// When
+	err := validateMappingFile("../../testtmp/cache/mapping.sqllite", awsutils.Archive{ArchiveId: "mappingArchiveId", Size: uint64(len(mappingContent))}, "0123")
+
+	// Then
+	assert.True(t, strings.HasSuffix(err.Error(), " instead of 0123"))
+}
+
+func TestValidateMappingFile_not_sqlite(t *testing.T) {
+	// Given
+	CommonInitTest()
+	ioutil.WriteFile("../../testtmp/cache/mapping.sqllite", []byte("hello !"), 0600)
+
+	// When
+	err := validateMappingFile("../../testtmp/cache/mapping.sqllite", awsutils.Archive{ArchiveId: "mappingArchiveId", Size: 7}, "")
+
+	// Then
+	assert.True(t, strings.HasPrefix(err.Error(), "Mapping file is not a sqlite database"))
+}
+
+func TestValidateMappingFile_unsupported_layout(t *testing.T) {
+	// Given
+	CommonInitTest()
+	createMappingFromFixture(t, "mapping_unknown.sqllite", "../../testtmp/cache/mapping.sqllite").Close()
+	content, _ := ioutil.ReadFile("../../testtmp/cache/mapping.sqllite")
+
+	// When
+	err := validateMappingFile("../../testtmp/cache/mapping.sqllite", awsutils.Archive{ArchiveId: "mappingArchiveId", Size: uint64(len(content))}, "")
+
+	// Then
+	assert.True(t, strings.HasPrefix(err.Error(), "Unsupported mapping file layout"))
+}
+
+func TestDownloadMappingArchive_download_again_invalid_mapping_file(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	mappingContent := mappingFileContent(t)
+	awsutils.JobIdsAtStartup.MappingRetrievalJobIdByArchiveId["mappingArchiveId"] = "retrieveMappingJobId"
+	restorationContext.RegionVaultCache = RegionVaultCache{MappingArchive: &awsutils.Archive{ArchiveId: "mappingArchiveId", Size: uint64(len(mappingContent))},}
+
+	mockDescribeJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, true)
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent[:1024]).Once()
+	mockOutputJob(glacierMock, "retrieveMappingJobId", restorationContext.MappingVault, mappingContent)
+	mockDescribeVault(glacierMock, restorationContext.MappingVault, 1, 42)
+
+	// When
+	DownloadMappingArchive(restorationContext)
+
+	// Then
+	assertMappingArchive(t, string(mappingContent))
+	assert.Equal(t, "WARNING: Mapping file is invalid, download it again from job retrieveMappingJobId: Mapping file has 1024 bytes instead of " + strconv.Itoa(len(mappingContent)) + consts.LINE_BREAK +
+		"Mapping archive has been downloaded" + consts.LINE_BREAK, string(buffer.Bytes()))
+}
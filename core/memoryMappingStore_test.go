@@ -0,0 +1,268 @@
+package core
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"rsg/awsutils"
+	"rsg/utils"
+)
+
+// In memory implementation of MappingStore for tests, filters behave like sql LIKE (case insensitive)
+
+type memoryMappingStore struct {
+	entries []*MappingEntry
+}
+
+func newMemoryMappingStore(entries ...*MappingEntry) *memoryMappingStore {
+	for i, mappingEntry := range entries {
+		if mappingEntry.Key == 0 {
+			mappingEntry.Key = int64(i + 1)
+		}
+	}
+	return &memoryMappingStore{entries: entries}
+}
+
+func (store *memoryMappingStore) matchingEntries(filter MappingFilter) []*MappingEntry {
+	regexps := []*regexp.Regexp{}
+	for _, pattern := range filter.Patterns {
+		expression := regexp.QuoteMeta(pattern)
+		expression = strings.Replace(expression, "\\*", ".*", -1)
+		expression = strings.Replace(expression, "\\?", ".", -1)
+		regexps = append(regexps, regexp.MustCompile("(?is)^" + expression + "$"))
+	}
+	entries := []*MappingEntry{}
+	for _, mappingEntry := range store.entries {
+		matches := len(regexps) == 0
+		for _, patternRegexp := range regexps {
+			matches = matches || patternRegexp.MatchString(mappingEntry.BasePath)
+		}
+		if matches && matchesFilterFacets(mappingEntry, filter) && store.isSelectedVersion(mappingEntry, filter.Version) {
+			entries = append(entries, mappingEntry)
+		}
+	}
+	return entries
+}
+
+func (store *memoryMappingStore) isSelectedVersion(mappingEntry *MappingEntry, version string) bool {
+	if version == "" {
+		return true
+	}
+	versions := []*MappingEntry{}
+	for _, otherEntry := range store.entries {
+		if otherEntry.Path() == mappingEntry.Path() {
+			versions = append(versions, otherEntry)
+		}
+	}
+	return selectVersionKey(versions, version) == mappingEntry.Key
+}
+
+// Full text search is handled as substring search
+func matchesFilterFacets(mappingEntry *MappingEntry, filter MappingFilter) bool {
+	for _, term := range filter.Terms {
+		if !strings.Contains(strings.ToLower(mappingEntry.Path()), strings.ToLower(term)) {
+			return false
+		}
+	}
+	if len(filter.Extensions) > 0 {
+		matches := false
+		for _, extension := range filter.Extensions {
+			matches = matches || strings.HasSuffix(strings.ToLower(mappingEntry.BasePath), "." + strings.ToLower(extension))
+		}
+		if !matches {
+			return false
+		}
+	}
+	if len(filter.Shares) > 0 && !utils.Contains(filter.Shares, mappingEntry.ShareName) {
+		return false
+	}
+	if len(filter.Paths) > 0 {
+		matches := false
+		for _, path := range filter.Paths {
+			matches = matches || mappingEntry.Path() == path || strings.HasPrefix(mappingEntry.Path(), path + "/")
+		}
+		if !matches {
+			return false
+		}
+	}
+	if len(filter.ArchiveIds) > 0 && !utils.Contains(filter.ArchiveIds, mappingEntry.ArchiveId) {
+		return false
+	}
+	return mappingEntry.FileSize >= filter.MinSize && (filter.MaxSize == 0 || mappingEntry.FileSize <= filter.MaxSize)
+}
+
+func (store *memoryMappingStore) Files(filter MappingFilter) (MappingEntryIterator, error) {
+	entries := store.matchingEntries(filter)
+	sort.Stable(mappingEntriesByBasePath(entries))
+	return &memoryMappingEntryIterator{entries: entries, index: -1}, nil
+}
+
+func (store *memoryMappingStore) Archives(filter MappingFilter) (ArchiveIterator, error) {
+	archives := []awsutils.Archive{}
+	found := make(map[awsutils.Archive]bool)
+	for _, mappingEntry := range store.matchingEntries(filter) {
+		archive := awsutils.Archive{ArchiveId: mappingEntry.ArchiveId, Size: mappingEntry.FileSize}
+		if !found[archive] {
+			found[archive] = true
+			archives = append(archives, archive)
+		}
+	}
+	return &memoryArchiveIterator{archives: archives, index: -1}, nil
+}
+
+func (store *memoryMappingStore) PathsForArchive(archiveId string) ([]string, error) {
+	paths := []string{}
+	found := make(map[string]bool)
+	for _, mappingEntry := range store.entries {
+		if mappingEntry.ArchiveId == archiveId && !found[mappingEntry.Path()] {
+			found[mappingEntry.Path()] = true
+			paths = append(paths, mappingEntry.Path())
+		}
+	}
+	return paths, nil
+}
+
+func (store *memoryMappingStore) EntriesForArchive(archiveId string) ([]*MappingEntry, error) {
+	entries := []*MappingEntry{}
+	for _, mappingEntry := range store.entries {
+		if mappingEntry.ArchiveId == archiveId {
+			entries = append(entries, mappingEntry)
+		}
+	}
+	return entries, nil
+}
+
+func (store *memoryMappingStore) Versions(filter MappingFilter) (map[string][]*MappingEntry, error) {
+	filter.Version = ""
+	archiveIdsByPath := make(map[string]map[string]bool)
+	for _, mappingEntry := range store.matchingEntries(filter) {
+		if archiveIdsByPath[mappingEntry.Path()] == nil {
+			archiveIdsByPath[mappingEntry.Path()] = make(map[string]bool)
+		}
+		archiveIdsByPath[mappingEntry.Path()][mappingEntry.ArchiveId] = true
+	}
+	versionsByPath := make(map[string][]*MappingEntry)
+	for _, mappingEntry := range store.entries {
+		if len(archiveIdsByPath[mappingEntry.Path()]) > 1 {
+			versionsByPath[mappingEntry.Path()] = append(versionsByPath[mappingEntry.Path()], mappingEntry)
+		}
+	}
+	return versionsByPath, nil
+}
+
+func (store *memoryMappingStore) TotalSize(filter MappingFilter) (uint64, error) {
+	totalSize := uint64(0)
+	found := make(map[string]bool)
+	for _, mappingEntry := range store.matchingEntries(filter) {
+		if !found[mappingEntry.ArchiveId] {
+			found[mappingEntry.ArchiveId] = true
+			totalSize += mappingEntry.FileSize
+		}
+	}
+	return totalSize, nil
+}
+
+func (store *memoryMappingStore) Directory(path string) ([]DirectoryEntry, error) {
+	prefix := ""
+	if path != "" {
+		prefix = path + "/"
+	}
+	entryByName := make(map[string]*DirectoryEntry)
+	names := []string{}
+	for _, mappingEntry := range store.entries {
+		if !strings.HasPrefix(mappingEntry.Path(), prefix) {
+			continue
+		}
+		name, dir := strings.TrimPrefix(mappingEntry.Path(), prefix), false
+		if index := strings.Index(name, "/"); index >= 0 {
+			name, dir = name[:index], true
+		}
+		if entryByName[name] == nil {
+			entryByName[name] = &DirectoryEntry{Name: name}
+			names = append(names, name)
+		}
+		entryByName[name].Dir = entryByName[name].Dir || dir
+		entryByName[name].Size += mappingEntry.FileSize
+	}
+	sort.Strings(names)
+	entries := []DirectoryEntry{}
+	for _, name := range names {
+		entries = append(entries, *entryByName[name])
+	}
+	return entries, nil
+}
+
+func (store *memoryMappingStore) HasFileMetadata() bool {
+	for _, mappingEntry := range store.entries {
+		if mappingEntry.Mtime != nil || mappingEntry.Ctime != nil || mappingEntry.Mode != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *memoryMappingStore) HasFullText() bool {
+	return false
+}
+
+func (store *memoryMappingStore) Close() error {
+	return nil
+}
+
+type mappingEntriesByBasePath []*MappingEntry
+
+func (entries mappingEntriesByBasePath) Len() int {
+	return len(entries)
+}
+
+func (entries mappingEntriesByBasePath) Less(i, j int) bool {
+	return entries[i].BasePath < entries[j].BasePath
+}
+
+func (entries mappingEntriesByBasePath) Swap(i, j int) {
+	entries[i], entries[j] = entries[j], entries[i]
+}
+
+type memoryMappingEntryIterator struct {
+	entries []*MappingEntry
+	index   int
+}
+
+func (iterator *memoryMappingEntryIterator) Next() bool {
+	iterator.index++
+	return iterator.index < len(iterator.entries)
+}
+
+func (iterator *memoryMappingEntryIterator) Entry() *MappingEntry {
+	return iterator.entries[iterator.index]
+}
+
+func (iterator *memoryMappingEntryIterator) Err() error {
+	return nil
+}
+
+func (iterator *memoryMappingEntryIterator) Close() error {
+	return nil
+}
+
+type memoryArchiveIterator struct {
+	archives []awsutils.Archive
+	index    int
+}
+
+func (iterator *memoryArchiveIterator) Next() bool {
+	iterator.index++
+	return iterator.index < len(iterator.archives)
+}
+
+func (iterator *memoryArchiveIterator) Archive() awsutils.Archive {
+	return iterator.archives[iterator.index]
+}
+
+func (iterator *memoryArchiveIterator) Err() error {
+	return nil
+}
+
+func (iterator *memoryArchiveIterator) Close() error {
+	return nil
+}
@@ -20,15 +20,18 @@ func DisplayInfoAboutCosts(options options.Options) {
 
 func DisplayWarnIfNotFreeTier(restorationContext *RestorationContext) {
 	if restorationContext.Options.InfoMessage {
-		strategy := awsutils.GetDataRetrievalStrategy(restorationContext.GlacierClient)
-		if strategy != "FreeTier" {
-			outputs.Printfln(outputs.OptionalInfo, "##################################################################################################################")
-			outputs.Printfln(outputs.OptionalInfo, "Your data retrieval strategy is \"%v\", the retrieval operations could generate additional costs !!!", strategy)
-			outputs.Printfln(outputs.OptionalInfo, "Select strategy \"FreeTier\" to avoid these costs :")
-			outputs.Printfln(outputs.OptionalInfo, "http://docs.aws.amazon.com/amazonglacier/latest/dev/data-retrieval-policy.html#data-retrieval-policy-using-console")
-			outputs.Printfln(outputs.OptionalInfo, "##################################################################################################################")
-			inputs.QueryContinue()
-		}
+		displayWarnIfNotFreeTier(awsutils.GetDataRetrievalPolicy(restorationContext.GlacierClient))
 	}
 
+}
+
+func displayWarnIfNotFreeTier(policy awsutils.DataRetrievalPolicy) {
+	if policy.Strategy != awsutils.FreeTierStrategy {
+		outputs.Printfln(outputs.OptionalInfo, "##################################################################################################################")
+		outputs.Printfln(outputs.OptionalInfo, "Your data retrieval strategy is \"%v\", the retrieval operations could generate additional costs !!!", describeRetrievalPolicy(policy))
+		outputs.Printfln(outputs.OptionalInfo, "Select strategy \"FreeTier\" to avoid these costs (rsg policy set FreeTier) :")
+		outputs.Printfln(outputs.OptionalInfo, "http://docs.aws.amazon.com/amazonglacier/latest/dev/data-retrieval-policy.html#data-retrieval-policy-using-console")
+		outputs.Printfln(outputs.OptionalInfo, "##################################################################################################################")
+		inputs.QueryContinue()
+	}
 }
\ No newline at end of file
@@ -0,0 +1,232 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+	"rsg/outputs"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+	"github.com/aws/aws-sdk-go/service/glacier"
+)
+
+// Restorability drill: a random sample of archives of the mapping file is retrieved by the usual jobs into a
+// temporary directory, the restored files are checked (size, and tree hash when the last inventory of the vault knows
+// it) then deleted. The result of each drill is appended to the drill history of the working directory.
+
+const drillHistoryFileName = "drill-history.jsonl"
+
+type drillArchive struct {
+	archiveId string
+	size      uint64
+	shareName string // share of the first file stored in the archive
+}
+
+type drillRecord struct {
+	Date     string
+	Archives int
+	Size     uint64
+	Passed   int
+	Failed   []string // ids of archives not restored correctly
+}
+
+func (restorationContext *RestorationContext) GetDrillHistoryFilePath() string {
+	return restorationContext.WorkingDirPath + "/" + drillHistoryFileName
+}
+
+// Exits with an error if an archive of the sample is not restored correctly
+func RestorabilityDrill(restorationContext *RestorationContext) {
+	if restorationContext.Options.Sample <= 0 {
+		utils.ExitIfError(errors.New("Sample of drill must contain at least one archive"))
+	}
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	sample := selectDrillSample(collectDrillArchives(restorationContext), restorationContext.Options.Sample,
+		restorationContext.Options.MaxBytes, restorationContext.Options.Stratify, random)
+	if len(sample) == 0 {
+		utils.ExitIfError(errors.New("No archive to drill (archives are empty or bigger than --max-bytes)"))
+	}
+	record := drillRecord{Date: time.Now().UTC().Format(time.RFC3339), Archives: len(sample), Failed: []string{}}
+	archiveIds := []string{}
+	for _, archive := range sample {
+		archiveIds = append(archiveIds, archive.archiveId)
+		record.Size += archive.size
+	}
+	outputs.Printfln(outputs.Info, "%d archive(s) sampled (%s)", len(sample), bytefmt.ByteSize(record.Size))
+
+	tempDirPath, err := ioutil.TempDir("", "rsg-drill-")
+	utils.ExitIfError(err)
+	drillContext := *restorationContext
+	drillContext.DestinationDirPath = tempDirPath
+	drillContext.Options.Filters = nil
+	drillContext.Options.Selection = nil
+	drillContext.Options.ArchiveIds = archiveIds
+	drillContext.Options.AllVersions = true
+	drillContext.Options.ChecksumManifest = false
+	DownloadArchives(&drillContext)
+
+	treeHashByArchiveId := make(map[string]string)
+	if vaultInventory := readVaultInventory(restorationContext); vaultInventory != nil {
+		for _, inventoryArchive := range vaultInventory.ArchiveList {
+			treeHashByArchiveId[inventoryArchive.ArchiveId] = inventoryArchive.SHA256TreeHash
+		}
+	} else {
+		outputs.Println(outputs.OptionalInfo, "No inventory of the vault, tree hashes are not checked (run audit to get it)")
+	}
+	failures := verifyDrillArchives(&drillContext, treeHashByArchiveId)
+	utils.ExitIfError(os.RemoveAll(tempDirPath))
+
+	for _, archive := range sample {
+		if failure, ok := failures[archive.archiveId]; ok {
+			outputs.Printfln(outputs.Info, "fail\t%s\t%s\t%s", archive.archiveId, bytefmt.ByteSize(archive.size), failure)
+			record.Failed = append(record.Failed, archive.archiveId)
+		} else {
+			outputs.Printfln(outputs.Info, "pass\t%s\t%s", archive.archiveId, bytefmt.ByteSize(archive.size))
+			record.Passed++
+		}
+	}
+	appendDrillRecord(restorationContext, record)
+	outputs.Printfln(outputs.Info, "%d archive(s) passed, %d failed", record.Passed, len(record.Failed))
+	if len(record.Failed) > 0 {
+		utils.ExitIfError(errors.New(fmt.Sprintf("Restorability drill failed: %d archive(s) not restored correctly", len(record.Failed))))
+	}
+}
+
+// Non empty archives of the mapping file
+func collectDrillArchives(restorationContext *RestorationContext) []drillArchive {
+	archives := []drillArchive{}
+	collected := make(map[string]bool)
+	iterateFiles(restorationContext, MappingFilter{}, func(mappingEntry *MappingEntry) {
+		if mappingEntry.FileSize > 0 && !collected[mappingEntry.ArchiveId] {
+			collected[mappingEntry.ArchiveId] = true
+			archives = append(archives, drillArchive{mappingEntry.ArchiveId, mappingEntry.FileSize, mappingEntry.ShareName})
+		}
+	})
+	return archives
+}
+
+// Random archives within the size budget (no budget if 0). Stratified samples take archives in turn from each share
+// and size bucket, so small shares and big files are drilled too.
+func selectDrillSample(archives []drillArchive, sample int, maxBytes uint64, stratify bool, random *rand.Rand) []drillArchive {
+	strata := make(map[string][]drillArchive)
+	for _, index := range random.Perm(len(archives)) {
+		archive := archives[index]
+		key := ""
+		if stratify {
+			key = fmt.Sprintf("%s\t%d", archive.shareName, getSizeHistogramBucket(archive.size))
+		}
+		strata[key] = append(strata[key], archive)
+	}
+	sortedKeys := []string{}
+	for key := range strata {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+	keys := []string{}
+	for _, index := range random.Perm(len(sortedKeys)) {
+		keys = append(keys, sortedKeys[index])
+	}
+
+	selected := []drillArchive{}
+	size := uint64(0)
+	for len(selected) < sample && len(keys) > 0 {
+		remainingKeys := []string{}
+		for _, key := range keys {
+			if len(selected) >= sample {
+				break
+			}
+			for len(strata[key]) > 0 {
+				archive := strata[key][0]
+				strata[key] = strata[key][1:]
+				if maxBytes == 0 || size + archive.size <= maxBytes {
+					selected = append(selected, archive)
+					size += archive.size
+					break
+				}
+			}
+			if len(strata[key]) > 0 {
+				remainingKeys = append(remainingKeys, key)
+			}
+		}
+		keys = remainingKeys
+	}
+	return selected
+}
+
+// Reason of failure by archive id, for archives with a file missing or differing in the destination directory
+func verifyDrillArchives(restorationContext *RestorationContext, treeHashByArchiveId map[string]string) map[string]string {
+	failures := make(map[string]string)
+	checked := make(map[string]bool)
+	iterateFilesToRestore(restorationContext, func(path string, mappingEntry *MappingEntry) {
+		if _, failed := failures[mappingEntry.ArchiveId]; failed {
+			return
+		}
+		filePath := restorationContext.DestinationDirPath + "/" + path
+		stat, err := os.Stat(filePath)
+		switch {
+		case err != nil:
+			failures[mappingEntry.ArchiveId] = "missing " + path
+		case uint64(stat.Size()) != mappingEntry.FileSize:
+			failures[mappingEntry.ArchiveId] = fmt.Sprintf("%s has %s instead of %s", path, bytefmt.ByteSize(uint64(stat.Size())), bytefmt.ByteSize(mappingEntry.FileSize))
+		case !checked[mappingEntry.ArchiveId] && treeHashByArchiveId[mappingEntry.ArchiveId] != "":
+			checked[mappingEntry.ArchiveId] = true
+			treeHash, err := computeFileTreeHash(filePath)
+			utils.ExitIfError(err)
+			if treeHash != treeHashByArchiveId[mappingEntry.ArchiveId] {
+				failures[mappingEntry.ArchiveId] = fmt.Sprintf("tree hash of %s is %s instead of %s", path, treeHash, treeHashByArchiveId[mappingEntry.ArchiveId])
+			}
+		}
+	})
+	return failures
+}
+
+func computeFileTreeHash(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return hex.EncodeToString(glacier.ComputeHashes(file).TreeHash), nil
+}
+
+func appendDrillRecord(restorationContext *RestorationContext, record drillRecord) {
+	content, err := json.Marshal(record)
+	utils.ExitIfError(err)
+	history, err := os.OpenFile(restorationContext.GetDrillHistoryFilePath(), os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0600)
+	utils.ExitIfError(err)
+	defer utils.CheckingClose(history, &err)
+	_, err = history.Write(append(content, '\n'))
+	utils.ExitIfError(err)
+}
+
+// Drills of the history, oldest first
+func readDrillHistory(restorationContext *RestorationContext) []drillRecord {
+	records := []drillRecord{}
+	content, err := ioutil.ReadFile(restorationContext.GetDrillHistoryFilePath())
+	if err != nil {
+		return records
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for decoder.More() {
+		record := drillRecord{}
+		utils.ExitIfError(decoder.Decode(&record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func DisplayDrillHistory(restorationContext *RestorationContext) {
+	for _, record := range readDrillHistory(restorationContext) {
+		status := "pass"
+		if len(record.Failed) > 0 {
+			status = "fail"
+		}
+		outputs.Printfln(outputs.Info, "%s\t%s\t%d archive(s)\t%s\t%d failed", record.Date, status, record.Archives, bytefmt.ByteSize(record.Size), len(record.Failed))
+	}
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+	"github.com/stretchr/testify/assert"
+)
+
+func initDrillVerificationTest(t *testing.T) *RestorationContext {
+	CommonInitTest()
+	_, restorationContext := InitTestWithGlacier()
+	createMappingFromFixture(t, "mapping_dsm5.sqllite", restorationContext.GetMappingFilePath()).Close()
+	restorationContext.Options.ArchiveIds = []string{"archiveId1", "archiveId2"}
+	restorationContext.Options.AllVersions = true
+	os.MkdirAll("../../testtmp/dest/share/data", 0700)
+	ioutil.WriteFile("../../testtmp/dest/share/data/file1.txt", []byte("hello"), 0600)
+	return restorationContext
+}
+
+func TestSelectDrillSample_within_max_bytes(t *testing.T) {
+	// Given
+	archives := []drillArchive{{"archiveId1", 5, "share"}, {"archiveId2", 12, "share"}, {"archiveId3", 100, "share"}}
+
+	// When
+	sample := selectDrillSample(archives, 3, 20, false, rand.New(rand.NewSource(1)))
+
+	// Then
+	assert.Equal(t, 2, len(sample))
+	assert.Contains(t, sample, archives[0])
+	assert.Contains(t, sample, archives[1])
+}
+
+func TestSelectDrillSample_stratified_by_share(t *testing.T) {
+	// Given
+	archives := []drillArchive{{"archiveId1", 5, "big"}, {"archiveId2", 6, "big"}, {"archiveId3", 7, "big"},
+		{"archiveId4", 8, "big"}, {"archiveId5", 9, "small"}}
+
+	for seed := int64(0); seed < 10; seed++ {
+		// When
+		sample := selectDrillSample(archives, 2, 0, true, rand.New(rand.NewSource(seed)))
+
+		// Then
+		assert.Equal(t, 2, len(sample))
+		assert.Contains(t, sample, archives[4])
+	}
+}
+
+func TestVerifyDrillArchives_missing_file(t *testing.T) {
+	// Given
+	restorationContext := initDrillVerificationTest(t)
+	treeHashByArchiveId := map[string]string{"archiveId1": "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}
+
+	// When
+	failures := verifyDrillArchives(restorationContext, treeHashByArchiveId)
+
+	// Then
+	assert.Equal(t, map[string]string{"archiveId2": "missing share/data/file2.txt"}, failures)
+}
+
+func TestVerifyDrillArchives_wrong_tree_hash(t *testing.T) {
+	// Given
+	restorationContext := initDrillVerificationTest(t)
+	restorationContext.Options.ArchiveIds = []string{"archiveId1"}
+	treeHashByArchiveId := map[string]string{"archiveId1": "0000"}
+
+	// When
+	failures := verifyDrillArchives(restorationContext, treeHashByArchiveId)
+
+	// Then
+	assert.Equal(t, map[string]string{"archiveId1": "tree hash of share/data/file1.txt is 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824 instead of 0000"}, failures)
+}
+
+func TestAppendDrillRecord(t *testing.T) {
+	// Given
+	CommonInitTest()
+	_, restorationContext := InitTestWithGlacier()
+
+	// When
+	appendDrillRecord(restorationContext, drillRecord{Date: "2016-10-20T10:00:00Z", Archives: 2, Size: 17, Passed: 2, Failed: []string{}})
+	appendDrillRecord(restorationContext, drillRecord{Date: "2016-10-21T10:00:00Z", Archives: 1, Size: 5, Failed: []string{"archiveId1"}})
+
+	// Then
+	records := readDrillHistory(restorationContext)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "2016-10-20T10:00:00Z", records[0].Date)
+	assert.Equal(t, []string{"archiveId1"}, records[1].Failed)
+}
@@ -8,7 +8,11 @@ import (
 	"os/user"
 	"io/ioutil"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 	"github.com/aws/aws-sdk-go/service/glacier/glacieriface"
 	"rsg/options"
 	"rsg/awsutils"
@@ -17,6 +21,7 @@ import (
 type RestorationContext struct {
 	GlacierClient        glacieriface.GlacierAPI
 	WorkingDirPath       string
+	MappingFilePath      string // mapping file given by option, else mapping file of working directory
 	Region               string
 	Vault                string
 	MappingVault         string
@@ -31,22 +36,121 @@ type RestorationOptions struct {
 	RefreshMappingFile *bool
 	KeepFiles          *bool
 	InfoMessage        bool
+	ChecksumManifest   bool
+	CommandArgs        []string // search terms of find, path of du
+	FullText           bool
+	Extensions         []string
+	Shares             []string
+	MinSize            uint64
+	MaxSize            uint64
+	Selection          []string // paths of files or directories selected in the browser, all files if empty
+	Top                int
+	FileVersion        string // latest, oldest or key of the version
+	AllVersions        bool
+	MappingMaxAge      time.Duration // 0 if mapping file is refreshed when mapping vault changes
+	MappingArchive     string // latest, choose, archive id or creation date
+	MappingInventory   bool // mapping file is refreshed when an inventory of mapping vault finds a newer mapping archive
+	DryRun             bool // prune displays archives without deleting them
+	MinAge             time.Duration // minimum age of archives deleted by prune
+	ArchiveIds         []string // archives to restore, all archives if empty
+	Sample             int // number of archives retrieved by drill
+	MaxBytes           uint64 // maximum size of archives retrieved by drill, no maximum if 0
+	Stratify           bool // drill samples archives across shares and sizes
+	DownloadStreams    int // parallel downloads of a retrieved range, sequential if less than 2
+	MaxRate            uint64 // download rate by second, unlimited if 0
+	Schedule           string // rules of rate and retrieval jobs by time window
+	MaxBytesByHour     uint64 // budgets of retrieval of all runs, no budget if 0
+	MaxBytesByDay      uint64
+	MaxBytesByMonth    uint64
+	MaxCostByDay       float64 // in dollars
+	MaxCostByMonth     float64
+	Speed              uint64 // download speed by second, estimated if 0
+	SpeedTestUrl       string // url of download speed test, no test if empty
 }
 
 type RegionVaultCache struct {
-	MappingArchive             *awsutils.Archive
+	MappingArchive             *awsutils.Archive // mapping archive selected for the download in progress
+	MappingArchives            []awsutils.InventoryArchive // last inventory of mapping vault, newest first
+	LocalMappingArchive        *awsutils.InventoryArchive // mapping archive of the local mapping file, nil if unknown
+	MappingVaultState          *awsutils.VaultState // state of mapping vault when mapping file was downloaded
 }
 
 func CreateRestorationContext(region, vault string, optionsValue options.Options) *RestorationContext {
-	usr, err := user.Current()
-	utils.ExitIfError(err)
-	workingDirPath := usr.HomeDir + "/.rsg/" + region + "/" + vault
-	err = os.MkdirAll(workingDirPath, 0700)
+	workingDirPath := getRsgDirPath() + "/" + region + "/" + vault
+	err := os.MkdirAll(workingDirPath, 0700)
 	utils.ExitIfError(err)
 	glacierClient := glacier.New(awsutils.Session, &aws.Config{Region: aws.String(region)})
+	return newRestorationContext(glacierClient, workingDirPath, region, vault, optionsValue)
+}
+
+// Restoration context without aws client using the mapping file given in options or a local copy of the mapping file,
+// nil if there is no local copy for the region and vault given in options or if there are several copies and region
+// and vault are not given.
+func CreateLocalRestorationContext(optionsValue options.Options) *RestorationContext {
+	if optionsValue.MappingFile != "" {
+		if !utils.Exists(optionsValue.MappingFile) {
+			utils.ExitIfError(errors.New(fmt.Sprintf("Mapping file not found: %s", optionsValue.MappingFile)))
+		}
+		outputs.Printfln(outputs.OptionalInfo, "Use mapping file %s", optionsValue.MappingFile)
+		return newRestorationContext(nil, mappingFileWorkingDirPath(optionsValue), optionsValue.Region, optionsValue.Vault, optionsValue)
+	}
+	if optionsValue.RefreshMappingFile != nil && *optionsValue.RefreshMappingFile {
+		return nil
+	}
+	workingDirPaths := findLocalMappingCopies(getRsgDirPath(), optionsValue.Region, optionsValue.Vault)
+	if len(workingDirPaths) != 1 {
+		outputs.Printfln(outputs.Verbose, "Local mapping files found: %v", workingDirPaths)
+		return nil
+	}
+	vault := filepath.Base(workingDirPaths[0])
+	region := filepath.Base(filepath.Dir(workingDirPaths[0]))
+	outputs.Printfln(outputs.OptionalInfo, "Use local mapping file of %s:%s", region, vault)
+	return newRestorationContext(nil, workingDirPaths[0], region, vault, optionsValue)
+}
+
+// Working directory of region and vault given in options, or else a temporary directory, nothing is written into the
+// directory of the mapping file given by option
+func mappingFileWorkingDirPath(optionsValue options.Options) string {
+	if optionsValue.Region != "" && optionsValue.Vault != "" {
+		workingDirPath := getRsgDirPath() + "/" + optionsValue.Region + "/" + optionsValue.Vault
+		utils.ExitIfError(os.MkdirAll(workingDirPath, 0700))
+		return workingDirPath
+	}
+	workingDirPath, err := ioutil.TempDir("", "rsg")
+	utils.ExitIfError(err)
+	outputs.Printfln(outputs.Verbose, "Working directory without region and vault: %s", workingDirPath)
+	return workingDirPath
+}
+
+// Working directories (<rsg dir>/<region>/<vault>) with a mapping file, filtered by region and vault if not empty
+func findLocalMappingCopies(rsgDirPath, region, vault string) []string {
+	regionPattern, vaultPattern := region, vault
+	if regionPattern == "" {
+		regionPattern = "*"
+	}
+	if vaultPattern == "" {
+		vaultPattern = "*"
+	}
+	mappingFilePaths, err := filepath.Glob(rsgDirPath + "/" + regionPattern + "/" + vaultPattern + "/mapping.sqllite")
+	utils.ExitIfError(err)
+	workingDirPaths := []string{}
+	for _, mappingFilePath := range mappingFilePaths {
+		workingDirPaths = append(workingDirPaths, filepath.Dir(mappingFilePath))
+	}
+	return workingDirPaths
+}
+
+func getRsgDirPath() string {
+	usr, err := user.Current()
+	utils.ExitIfError(err)
+	return usr.HomeDir + "/.rsg"
+}
+
+func newRestorationContext(glacierClient glacieriface.GlacierAPI, workingDirPath, region, vault string, optionsValue options.Options) *RestorationContext {
 	cache := ReadCache(workingDirPath);
 	return &RestorationContext{GlacierClient: glacierClient,
 		WorkingDirPath: workingDirPath,
+		MappingFilePath: optionsValue.MappingFile,
 		Region: region,
 		Vault: vault,
 		MappingVault: vault + "_mapping",
@@ -57,6 +161,34 @@ func CreateRestorationContext(region, vault string, optionsValue options.Options
 			RefreshMappingFile: optionsValue.RefreshMappingFile,
 			KeepFiles: optionsValue.KeepFiles,
 			InfoMessage: optionsValue.InfoMessage,
+			ChecksumManifest: optionsValue.ChecksumManifest,
+			CommandArgs: optionsValue.CommandArgs,
+			FullText: optionsValue.FullText,
+			Extensions: optionsValue.Extensions,
+			Shares: optionsValue.Shares,
+			MinSize: optionsValue.MinSize,
+			MaxSize: optionsValue.MaxSize,
+			Top: optionsValue.Top,
+			FileVersion: optionsValue.FileVersion,
+			AllVersions: optionsValue.AllVersions,
+			MappingMaxAge: optionsValue.MappingMaxAge,
+			MappingArchive: optionsValue.MappingArchive,
+			MappingInventory: optionsValue.MappingInventory,
+			DryRun: optionsValue.DryRun,
+			MinAge: optionsValue.MinAge,
+			Sample: optionsValue.Sample,
+			MaxBytes: optionsValue.MaxBytes,
+			Stratify: optionsValue.Stratify,
+			DownloadStreams: optionsValue.DownloadStreams,
+			MaxRate: optionsValue.MaxRate,
+			Schedule: optionsValue.Schedule,
+			MaxBytesByHour: optionsValue.MaxBytesByHour,
+			MaxBytesByDay: optionsValue.MaxBytesByDay,
+			MaxBytesByMonth: optionsValue.MaxBytesByMonth,
+			MaxCostByDay: optionsValue.MaxCostByDay,
+			MaxCostByMonth: optionsValue.MaxCostByMonth,
+			Speed: optionsValue.Speed,
+			SpeedTestUrl: optionsValue.SpeedTestUrl,
 		},
 	}
 }
@@ -81,5 +213,8 @@ func (restorationContext *RestorationContext) WriteCache() {
 }
 
 func (restorationContext *RestorationContext) GetMappingFilePath() string {
+	if restorationContext.MappingFilePath != "" {
+		return restorationContext.MappingFilePath
+	}
 	return restorationContext.WorkingDirPath + "/mapping.sqllite"
 }
\ No newline at end of file
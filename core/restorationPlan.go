@@ -0,0 +1,132 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"rsg/outputs"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Restoration without aws: plan computes what a restoration would retrieve, verify checks the files restored in the
+// destination directory against the mapping file (and the checksum manifest if it exists).
+// Files are the ones of a restoration with the same options (filters, version).
+
+// Files to restore: filters, selection of the browser, archives and version
+func newRestorationFilter(restorationContext *RestorationContext) MappingFilter {
+	filter := NewMappingFilter(restorationContext.Options.Filters)
+	filter.Paths = restorationContext.Options.Selection
+	filter.ArchiveIds = restorationContext.Options.ArchiveIds
+	if restorationContext.Options.FileVersion == "" {
+		restorationContext.Options.FileVersion = LatestVersion
+	}
+	if !restorationContext.Options.AllVersions {
+		filter.Version = restorationContext.Options.FileVersion
+	}
+	return filter
+}
+
+// Iterates files to restore with their path in destination directory
+func iterateFilesToRestore(restorationContext *RestorationContext, fn func(path string, mappingEntry *MappingEntry)) {
+	mappingStore := InitMappingStore(restorationContext.GetMappingFilePath())
+	defer mappingStore.Close()
+	versionsByPath, err := mappingStore.Versions(MappingFilter{})
+	utils.ExitIfError(err)
+	filter := newRestorationFilter(restorationContext)
+	utils.ExitIfError(checkVersionKey(versionsByPath, filter.Version))
+	fileIterator, err := mappingStore.Files(filter)
+	utils.ExitIfError(err)
+	defer fileIterator.Close()
+	for fileIterator.Next() {
+		mappingEntry := fileIterator.Entry()
+		path := mappingEntry.Path()
+		if _, ok := versionsByPath[path]; ok && restorationContext.Options.AllVersions {
+			path = versionPath(path, mappingEntry.Key)
+		}
+		fn(path, mappingEntry)
+	}
+	utils.ExitIfError(fileIterator.Err())
+}
+
+// Files and archives to retrieve, files already in destination directory are not retrieved again
+func PlanRestoration(restorationContext *RestorationContext) {
+	toRestore := newSizeStats()
+	restored := newSizeStats()
+	iterateFilesToRestore(restorationContext, func(path string, mappingEntry *MappingEntry) {
+		if restorationContext.DestinationDirPath != "" && utils.Exists(restorationContext.DestinationDirPath + "/" + path) {
+			restored.add(mappingEntry)
+		} else {
+			toRestore.add(mappingEntry)
+		}
+	})
+	if restored.files > 0 {
+		outputs.Printfln(outputs.Info, "%d file(s) already restored in %s (%s)", restored.files, restorationContext.DestinationDirPath, bytefmt.ByteSize(restored.size))
+	}
+	cost := float64(toRestore.retrievalSize) / float64(utils.S_1GB) * (RetrievalCostByGB + TransferCostByGB)
+	outputs.Printfln(outputs.Info, "%d file(s) to restore (%s), %d archive(s) to retrieve (%s), estimated cost $%.2f",
+		toRestore.files, bytefmt.ByteSize(toRestore.size), len(toRestore.archiveIds), bytefmt.ByteSize(toRestore.retrievalSize), cost)
+}
+
+type verification struct {
+	verified      int
+	missing       int
+	wrongSize     int
+	wrongChecksum int
+}
+
+func (verification verification) failures() int {
+	return verification.missing + verification.wrongSize + verification.wrongChecksum
+}
+
+// Exits with an error if a file is missing or differs
+func VerifyRestoration(restorationContext *RestorationContext) {
+	if restorationContext.DestinationDirPath == "" {
+		utils.ExitIfError(errors.New("Destination directory is required to verify a restoration"))
+	}
+	result := verifyRestoredFiles(restorationContext)
+	outputs.Printfln(outputs.Info, "%d file(s) verified, %d missing, %d with wrong size, %d with wrong checksum",
+		result.verified, result.missing, result.wrongSize, result.wrongChecksum)
+	if result.failures() > 0 {
+		utils.ExitIfError(errors.New(fmt.Sprintf("%d file(s) are not restored correctly in %s", result.failures(), restorationContext.DestinationDirPath)))
+	}
+}
+
+func verifyRestoredFiles(restorationContext *RestorationContext) verification {
+	result := verification{}
+	checksumManifest := newChecksumManifest(restorationContext.DestinationDirPath)
+	iterateFilesToRestore(restorationContext, func(path string, mappingEntry *MappingEntry) {
+		filePath := restorationContext.DestinationDirPath + "/" + path
+		stat, err := os.Stat(filePath)
+		switch {
+		case err != nil:
+			outputs.Printfln(outputs.Info, "missing\t%s", path)
+			result.missing++
+		case uint64(stat.Size()) != mappingEntry.FileSize:
+			outputs.Printfln(outputs.Info, "size\t%s\t%s instead of %s", path, bytefmt.ByteSize(uint64(stat.Size())), bytefmt.ByteSize(mappingEntry.FileSize))
+			result.wrongSize++
+		default:
+			if manifestEntry, ok := checksumManifest.entryByPath[path]; ok && manifestEntry.Sha256 != computeFileSha256(filePath) {
+				outputs.Printfln(outputs.Info, "checksum\t%s", path)
+				result.wrongChecksum++
+			} else {
+				outputs.Printfln(outputs.Verbose, "ok\t%s", path)
+				result.verified++
+			}
+		}
+	})
+	return result
+}
+
+func computeFileSha256(filePath string) string {
+	file, err := os.Open(filePath)
+	utils.ExitIfError(err)
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	utils.ExitIfError(err)
+	return hex.EncodeToString(hash.Sum(nil))
+}
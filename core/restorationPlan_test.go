@@ -0,0 +1,102 @@
+package core
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/stretchr/testify/assert"
+	"rsg/options"
+)
+
+func initRestorationPlanTest(t *testing.T) *RestorationContext {
+	restorationContext := DefaultRestorationContext(nil)
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", restorationContext.GetMappingFilePath())
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/copy/file1.txt', 'archiveId1', 5);")
+	db.Close()
+	os.MkdirAll(restorationContext.DestinationDirPath + "/share/data/copy", 0700)
+	return restorationContext
+}
+
+func TestPlanRestoration_skip_files_already_restored(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initRestorationPlanTest(t)
+	ioutil.WriteFile(restorationContext.DestinationDirPath + "/share/data/file2.txt", []byte("hello world!"), 0600)
+	buffer.Reset()
+
+	// When
+	PlanRestoration(restorationContext)
+
+	// Then
+	assert.Equal(t, "1 file(s) already restored in ../../testtmp/dest (12B)\n" +
+		"2 file(s) to restore (10B), 1 archive(s) to retrieve (5B), estimated cost $0.00\n", string(buffer.Bytes()))
+}
+
+func TestVerifyRestoredFiles_missing_and_wrong_size(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	restorationContext := initRestorationPlanTest(t)
+	ioutil.WriteFile(restorationContext.DestinationDirPath + "/share/data/file1.txt", []byte("hello"), 0600)
+	ioutil.WriteFile(restorationContext.DestinationDirPath + "/share/data/file2.txt", []byte("hello"), 0600)
+	buffer.Reset()
+
+	// When
+	result := verifyRestoredFiles(restorationContext)
+
+	// Then
+	assert.Equal(t, verification{verified: 1, missing: 1, wrongSize: 1}, result)
+	assert.Contains(t, string(buffer.Bytes()), "missing\tshare/data/copy/file1.txt\n")
+	assert.Contains(t, string(buffer.Bytes()), "size\tshare/data/file2.txt\t5B instead of 12B\n")
+}
+
+func TestVerifyRestoredFiles_wrong_checksum(t *testing.T) {
+	// Given
+	CommonInitTest()
+	restorationContext := initRestorationPlanTest(t)
+	restorationContext.Options.Filters = []string{"data/file1.txt"}
+	ioutil.WriteFile(restorationContext.DestinationDirPath + "/share/data/file1.txt", []byte("hallo"), 0600)
+	ioutil.WriteFile(restorationContext.DestinationDirPath + "/" + checksumManifestJsonFileName,
+		[]byte("[{\"share\":\"share\",\"path\":\"data/file1.txt\",\"size\":5,\"archiveId\":\"archiveId1\",\"sha256\":\"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824\"}]"), 0600)
+
+	// When
+	result := verifyRestoredFiles(restorationContext)
+
+	// Then
+	assert.Equal(t, verification{wrongChecksum: 1}, result)
+}
+
+func TestCreateLocalRestorationContext_with_mapping_file(t *testing.T) {
+	// Given
+	CommonInitTest()
+	createMappingFromFixture(t, "mapping_dsm5.sqllite", "../../testtmp/copy.sqllite").Close()
+
+	// When
+	restorationContext := CreateLocalRestorationContext(options.Options{MappingFile: "../../testtmp/copy.sqllite", Vault: "vault"})
+
+	// Then
+	assert.Nil(t, restorationContext.GlacierClient)
+	assert.Equal(t, "../../testtmp/copy.sqllite", restorationContext.GetMappingFilePath())
+	assert.Equal(t, "vault_mapping", restorationContext.MappingVault)
+	assert.NotEqual(t, "../../testtmp", filepath.Clean(restorationContext.WorkingDirPath))
+	os.RemoveAll(restorationContext.WorkingDirPath)
+}
+
+func TestReadOnlyDataSourceName_mapping_file_is_not_written(t *testing.T) {
+	// Given
+	CommonInitTest()
+	createMappingFromFixture(t, "mapping_dsm5.sqllite", "../../testtmp/copy#1.sqllite").Close()
+
+	// When
+	db, _ := sql.Open("sqlite3", readOnlyDataSourceName("../../testtmp/copy#1.sqllite"))
+	defer db.Close()
+	var count int
+	errSelect := db.QueryRow("SELECT count(*) FROM `file_info_tb`").Scan(&count)
+	_, errInsert := db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'data/file3.txt', 'archiveId3', 1);")
+
+	// Then
+	assert.Nil(t, errSelect)
+	assert.Equal(t, 2, count)
+	assert.NotNil(t, errInsert)
+}
@@ -0,0 +1,249 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"time"
+	"rsg/outputs"
+	"rsg/utils"
+)
+
+// Budgets of retrieval: maximum bytes retrieved by hour, day or month and maximum cost by day or month (UTC calendar
+// periods). Every retrieval job started is appended to a ledger shared by all vaults and runs, jobs are started only
+// within what is left of the budgets and wait the next period when they are spent.
+// The ledger is locked by a lock file from the check of what is left to the append of a reservation of the bytes to
+// retrieve, so that runs in parallel cannot spend the same allowance. The lock is released before the job is started,
+// the reservation is then replaced by the job started or by an empty entry if no job is started. A reservation of a
+// run which stopped before is kept as spent.
+
+const retrievalLedgerFileName = "retrieval-ledger.jsonl"
+
+// a lock older than this was left by a run which stopped while holding it
+const retrievalLedgerLockTimeout = 2 * time.Minute
+const retrievalLedgerLockRetryDelay = 100 * time.Millisecond
+
+// Tier of the retrieval jobs started by rsg
+const retrievalTier = "Standard"
+
+// Retrieval prices by GB of each tier by region, RetrievalCostByGB is used for other regions
+var retrievalPricesByRegion = map[string]map[string]float64{
+	"us-east-1":      {"Expedited": 0.03, "Standard": 0.01, "Bulk": 0.0025},
+	"us-east-2":      {"Expedited": 0.03, "Standard": 0.01, "Bulk": 0.0025},
+	"us-west-2":      {"Expedited": 0.03, "Standard": 0.01, "Bulk": 0.0025},
+	"eu-west-1":      {"Expedited": 0.03, "Standard": 0.01, "Bulk": 0.0025},
+	"us-west-1":      {"Expedited": 0.036, "Standard": 0.012, "Bulk": 0.003},
+	"eu-central-1":   {"Expedited": 0.036, "Standard": 0.012, "Bulk": 0.003},
+	"ap-northeast-1": {"Expedited": 0.036, "Standard": 0.012, "Bulk": 0.003},
+	"ap-northeast-2": {"Expedited": 0.036, "Standard": 0.012, "Bulk": 0.003},
+	"ap-southeast-2": {"Expedited": 0.036, "Standard": 0.012, "Bulk": 0.003},
+	"ap-south-1":     {"Expedited": 0.036, "Standard": 0.012, "Bulk": 0.003},
+}
+
+var budgetPeriods = []string{"hour", "day", "month"}
+
+const budgetUnlimited = uint64(math.MaxUint64)
+
+// path of the ledger, in rsg directory if empty
+var retrievalLedgerFilePath = ""
+
+type retrievalLedgerEntry struct {
+	Date        time.Time
+	Region      string
+	Vault       string
+	JobId       string // empty for a reservation
+	Size        uint64
+	Cost        float64
+	Reservation string `json:",omitempty"` // an entry replaces the previous entry of the same reservation
+}
+
+type retrievalBudget struct {
+	maxBytesByPeriod map[string]uint64
+	maxCostByPeriod  map[string]float64
+	costByGB         float64
+}
+
+// Retrieval and data transfer out price by GB in the region
+func retrievalCostByGB(region string) float64 {
+	if price, ok := retrievalPricesByRegion[region][retrievalTier]; ok {
+		return price + TransferCostByGB
+	}
+	return RetrievalCostByGB + TransferCostByGB
+}
+
+// nil if there is no budget
+func newRetrievalBudget(restorationContext *RestorationContext) *retrievalBudget {
+	budget := &retrievalBudget{maxBytesByPeriod: make(map[string]uint64), maxCostByPeriod: make(map[string]float64),
+		costByGB: retrievalCostByGB(restorationContext.Region)}
+	for period, maxBytes := range map[string]uint64{"hour": restorationContext.Options.MaxBytesByHour,
+		"day": restorationContext.Options.MaxBytesByDay,
+		"month": restorationContext.Options.MaxBytesByMonth} {
+		if maxBytes > 0 {
+			budget.maxBytesByPeriod[period] = maxBytes
+		}
+	}
+	for period, maxCost := range map[string]float64{"day": restorationContext.Options.MaxCostByDay,
+		"month": restorationContext.Options.MaxCostByMonth} {
+		if maxCost > 0 {
+			budget.maxCostByPeriod[period] = maxCost
+		}
+	}
+	if len(budget.maxBytesByPeriod) == 0 && len(budget.maxCostByPeriod) == 0 {
+		return nil
+	}
+	return budget
+}
+
+// Start and end of the UTC calendar period containing the date
+func periodBounds(period string, date time.Time) (time.Time, time.Time) {
+	date = date.UTC()
+	switch period {
+	case "hour":
+		start := date.Truncate(time.Hour)
+		return start, start.Add(time.Hour)
+	case "day":
+		start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1)
+	}
+	start := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// Bytes which can still be retrieved at the date and end of the period of the tightest budget, unlimited without
+// budget
+func (budget *retrievalBudget) allowance(entries []retrievalLedgerEntry, date time.Time) (uint64, time.Time) {
+	allowance := budgetUnlimited
+	resetDate := time.Time{}
+	if budget == nil {
+		return allowance, resetDate
+	}
+	for _, period := range budgetPeriods {
+		start, end := periodBounds(period, date)
+		usedSize, usedCost := uint64(0), 0.0
+		for _, entry := range entries {
+			if !entry.Date.Before(start) {
+				usedSize += entry.Size
+				usedCost += entry.Cost
+			}
+		}
+		if maxBytes, ok := budget.maxBytesByPeriod[period]; ok {
+			left := uint64(0)
+			if usedSize < maxBytes {
+				left = maxBytes - usedSize
+			}
+			if left < allowance {
+				allowance, resetDate = left, end
+			}
+		}
+		if maxCost, ok := budget.maxCostByPeriod[period]; ok {
+			left := uint64(0)
+			if usedCost < maxCost {
+				left = uint64((maxCost - usedCost) / budget.costByGB * float64(utils.S_1GB))
+			}
+			if left < allowance {
+				allowance, resetDate = left, end
+			}
+		}
+	}
+	return allowance, resetDate
+}
+
+func getRetrievalLedgerFilePath() string {
+	if retrievalLedgerFilePath != "" {
+		return retrievalLedgerFilePath
+	}
+	return getRsgDirPath() + "/" + retrievalLedgerFileName
+}
+
+// Takes the exclusive lock of the ledger, waiting for other runs to release it, returns the function releasing it
+func lockRetrievalLedger() func() {
+	lockFilePath := getRetrievalLedgerFilePath() + ".lock"
+	for {
+		lockFile, err := os.OpenFile(lockFilePath, os.O_CREATE | os.O_EXCL | os.O_WRONLY, 0600)
+		if err == nil {
+			utils.ExitIfError(lockFile.Close())
+			return func() {
+				utils.ExitIfError(os.Remove(lockFilePath))
+			}
+		}
+		if !os.IsExist(err) {
+			utils.ExitIfError(err)
+		}
+		if stat, err := os.Stat(lockFilePath); err == nil && time.Since(stat.ModTime()) > retrievalLedgerLockTimeout {
+			removeStaleRetrievalLedgerLock(lockFilePath, stat.ModTime())
+			continue
+		}
+		time.Sleep(retrievalLedgerLockRetryDelay)
+	}
+}
+
+// The lock is renamed before its removal, so that only one run removes it. A lock taken by another run after the check
+// of its date is put back.
+func removeStaleRetrievalLedgerLock(lockFilePath string, modTime time.Time) {
+	staleLockFilePath := lockFilePath + "." + strconv.Itoa(os.Getpid()) + ".stale"
+	if err := os.Rename(lockFilePath, staleLockFilePath); err != nil {
+		return
+	}
+	defer os.Remove(staleLockFilePath)
+	if stat, err := os.Stat(staleLockFilePath); err == nil && !stat.ModTime().Equal(modTime) {
+		os.Link(staleLockFilePath, lockFilePath)
+		return
+	}
+	outputs.Printfln(outputs.Warning, "Remove lock of retrieval ledger left since %v: %s", modTime, lockFilePath)
+}
+
+// Id of a reservation of bytes in the ledger, unique between runs
+func newRetrievalReservation() string {
+	return strconv.Itoa(os.Getpid()) + "-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+func appendRetrievalLedgerEntry(entry retrievalLedgerEntry) {
+	content, err := json.Marshal(entry)
+	utils.ExitIfError(err)
+	ledger, err := os.OpenFile(getRetrievalLedgerFilePath(), os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0600)
+	utils.ExitIfError(err)
+	defer utils.CheckingClose(ledger, &err)
+	_, err = ledger.Write(append(content, '\n'))
+	utils.ExitIfError(err)
+}
+
+// Jobs and reservations of the ledger, oldest first
+func readRetrievalLedger() []retrievalLedgerEntry {
+	entries := []retrievalLedgerEntry{}
+	content, err := ioutil.ReadFile(getRetrievalLedgerFilePath())
+	if err != nil {
+		return entries
+	}
+	indexByReservation := make(map[string]int)
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for decoder.More() {
+		entry := retrievalLedgerEntry{}
+		utils.ExitIfError(decoder.Decode(&entry))
+		if index, ok := indexByReservation[entry.Reservation]; ok {
+			entries[index] = entry
+			continue
+		}
+		if entry.Reservation != "" {
+			indexByReservation[entry.Reservation] = len(entries)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Entries without the reservation
+func withoutReservation(entries []retrievalLedgerEntry, reservation string) []retrievalLedgerEntry {
+	if reservation == "" {
+		return entries
+	}
+	otherEntries := []retrievalLedgerEntry{}
+	for _, entry := range entries {
+		if entry.Reservation != reservation {
+			otherEntries = append(otherEntries, entry)
+		}
+	}
+	return otherEntries
+}
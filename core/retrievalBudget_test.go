@@ -0,0 +1,237 @@
+package core
+
+import (
+	"container/list"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"rsg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRetrievalBudget_allowance_of_tightest_period(t *testing.T) {
+	// Given
+	budget := &retrievalBudget{maxBytesByPeriod: map[string]uint64{"hour": 10 * utils.S_1GB, "day": 12 * utils.S_1GB},
+		maxCostByPeriod: map[string]float64{}, costByGB: 0.1}
+	date := time.Date(2016, 10, 20, 15, 30, 0, 0, time.UTC)
+	entries := []retrievalLedgerEntry{
+		{Date: time.Date(2016, 10, 19, 23, 0, 0, 0, time.UTC), Size: 50 * utils.S_1GB},
+		{Date: time.Date(2016, 10, 20, 10, 0, 0, 0, time.UTC), Size: 5 * utils.S_1GB},
+		{Date: time.Date(2016, 10, 20, 15, 10, 0, 0, time.UTC), Size: 1 * utils.S_1GB},
+	}
+
+	// When
+	allowance, resetDate := budget.allowance(entries, date)
+
+	// Then
+	assert.Equal(t, uint64(6 * utils.S_1GB), allowance)
+	assert.Equal(t, time.Date(2016, 10, 21, 0, 0, 0, 0, time.UTC), resetDate)
+}
+
+func TestRetrievalBudget_allowance_of_cost(t *testing.T) {
+	// Given
+	budget := &retrievalBudget{maxBytesByPeriod: map[string]uint64{},
+		maxCostByPeriod: map[string]float64{"month": 5}, costByGB: 0.1}
+	date := time.Date(2016, 10, 20, 15, 30, 0, 0, time.UTC)
+	entries := []retrievalLedgerEntry{
+		{Date: time.Date(2016, 9, 30, 10, 0, 0, 0, time.UTC), Size: 100 * utils.S_1GB, Cost: 10},
+		{Date: time.Date(2016, 10, 2, 10, 0, 0, 0, time.UTC), Size: 30 * utils.S_1GB, Cost: 3},
+	}
+
+	// When
+	allowance, resetDate := budget.allowance(entries, date)
+
+	// Then
+	assert.InDelta(t, float64(20 * utils.S_1GB), float64(allowance), float64(utils.S_1MB))
+	assert.Equal(t, time.Date(2016, 11, 1, 0, 0, 0, 0, time.UTC), resetDate)
+}
+
+func TestRetrievalBudget_spent(t *testing.T) {
+	// Given
+	budget := &retrievalBudget{maxBytesByPeriod: map[string]uint64{"hour": utils.S_1GB},
+		maxCostByPeriod: map[string]float64{}, costByGB: 0.1}
+	date := time.Date(2016, 10, 20, 15, 30, 0, 0, time.UTC)
+	entries := []retrievalLedgerEntry{{Date: time.Date(2016, 10, 20, 15, 0, 0, 0, time.UTC), Size: 2 * utils.S_1GB}}
+
+	// When
+	allowance, resetDate := budget.allowance(entries, date)
+
+	// Then
+	assert.Equal(t, uint64(0), allowance)
+	assert.Equal(t, time.Date(2016, 10, 20, 16, 0, 0, 0, time.UTC), resetDate)
+}
+
+func TestNewRetrievalBudget_without_budget(t *testing.T) {
+	// Given
+	CommonInitTest()
+	_, restorationContext := InitTestWithGlacier()
+
+	// When
+	budget := newRetrievalBudget(restorationContext)
+
+	// Then
+	assert.Nil(t, budget)
+}
+
+func TestRetrievalCostByGB(t *testing.T) {
+	assert.InDelta(t, 0.01 + TransferCostByGB, retrievalCostByGB("us-east-1"), 0.0001)
+	assert.InDelta(t, 0.012 + TransferCostByGB, retrievalCostByGB("eu-central-1"), 0.0001)
+	assert.InDelta(t, RetrievalCostByGB + TransferCostByGB, retrievalCostByGB("region"), 0.0001)
+}
+
+func TestStartArchiveRetrievingJobs_within_budget(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	restorationContext.Options.MaxBytesByHour = 4 * utils.S_1MB
+	appendRetrievalLedgerEntry(retrievalLedgerEntry{Date: time.Now().UTC(), Region: "region", Vault: "other_vault", JobId: "jobId0", Size: 3 * utils.S_1MB})
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: 4 * utils.S_1MB,
+		archivePartRetrievalListMaxSize: 10,
+		archivePartRetrieveList: list.New(),
+		unwrittenSizeByArchiveId: make(map[string]uint64),
+		uncompletedRetrieve: &archiveRetrieve{archiveId: "archiveId1", size: 4 * utils.S_1MB, endByteIndex: 4 * utils.S_1MB},
+		budget: newRetrievalBudget(restorationContext),
+	}
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-1048575", "jobId1").Once()
+
+	// When
+	result := downloadContext.startArchiveRetrievingJobs()
+
+	// Then
+	assert.Equal(t, ALLOWANCE_SPENT, result)
+	assert.Equal(t, 1, downloadContext.archivePartRetrieveList.Len())
+	assert.Equal(t, uint64(utils.S_1MB), downloadContext.uncompletedRetrieve.nextByteIndexToRetrieve)
+	_, nextHour := periodBounds("hour", time.Now())
+	assert.Equal(t, nextHour, downloadContext.allowanceResetDate)
+	entries := readRetrievalLedger()
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "jobId1", entries[1].JobId)
+	assert.Equal(t, uint64(utils.S_1MB), entries[1].Size)
+	assert.Equal(t, "vault", entries[1].Vault)
+}
+
+func TestLockRetrievalLedger_budgets_in_parallel(t *testing.T) {
+	// Given
+	CommonInitTest()
+	budget := &retrievalBudget{maxBytesByPeriod: map[string]uint64{"hour": 4 * utils.S_1MB},
+		maxCostByPeriod: map[string]float64{}, costByGB: 0.1}
+	startJobWithinBudget := func(jobId string, done chan bool) {
+		unlock := lockRetrievalLedger()
+		defer unlock()
+		if allowance, _ := budget.allowance(readRetrievalLedger(), time.Now()); allowance >= 3 * utils.S_1MB {
+			time.Sleep(50 * time.Millisecond)
+			appendRetrievalLedgerEntry(retrievalLedgerEntry{Date: time.Now().UTC(), Region: "region", Vault: "vault", JobId: jobId, Size: 3 * utils.S_1MB})
+		}
+		done <- true
+	}
+
+	// When
+	done := make(chan bool)
+	go startJobWithinBudget("jobId1", done)
+	go startJobWithinBudget("jobId2", done)
+	<-done
+	<-done
+
+	// Then
+	assert.Equal(t, 1, len(readRetrievalLedger()))
+	assert.False(t, utils.Exists(getRetrievalLedgerFilePath() + ".lock"))
+}
+
+func TestStartArchiveRetrievingJobs_reservation_released_when_no_job_is_started(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	restorationContext.Options.MaxBytesByHour = 4 * utils.S_1MB
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: 4 * utils.S_1MB,
+		archivePartRetrievalListMaxSize: 10,
+		archivePartRetrieveList: list.New(),
+		unwrittenSizeByArchiveId: make(map[string]uint64),
+		uncompletedRetrieve: &archiveRetrieve{archiveId: "archiveId1", size: utils.S_1MB, endByteIndex: utils.S_1MB},
+		budget: newRetrievalBudget(restorationContext),
+	}
+	var reservedDuringJobStart []retrievalLedgerEntry
+	lockedDuringJobStart := true
+	mockStartPartialRetrieveJobWithError(glacierMock, restorationContext.Vault, "archiveId1", "0-1048575", errors.New("ResourceNotFoundException")).Run(func(mock.Arguments) {
+		reservedDuringJobStart = readRetrievalLedger()
+		lockedDuringJobStart = utils.Exists(getRetrievalLedgerFilePath() + ".lock")
+	}).Once()
+
+	// When
+	result := downloadContext.startArchivePartRetrieveJob(downloadContext.uncompletedRetrieve)
+
+	// Then
+	assert.Equal(t, SKIPPED, result)
+	assert.False(t, lockedDuringJobStart)
+	assert.Equal(t, 1, len(reservedDuringJobStart))
+	assert.Equal(t, uint64(utils.S_1MB), reservedDuringJobStart[0].Size)
+	assert.Equal(t, "", reservedDuringJobStart[0].JobId)
+	entries := readRetrievalLedger()
+	assert.Equal(t, 1, len(entries))
+	assert.Equal(t, reservedDuringJobStart[0].Reservation, entries[0].Reservation)
+	assert.Equal(t, uint64(0), entries[0].Size)
+}
+
+func TestReadRetrievalLedger_reservation_replaced_by_job(t *testing.T) {
+	// Given
+	CommonInitTest()
+	appendRetrievalLedgerEntry(retrievalLedgerEntry{Region: "region", Vault: "vault", Size: 3 * utils.S_1MB, Reservation: "1-1"})
+	appendRetrievalLedgerEntry(retrievalLedgerEntry{Region: "region", Vault: "other_vault", Size: 2 * utils.S_1MB, Reservation: "2-1"})
+	appendRetrievalLedgerEntry(retrievalLedgerEntry{Region: "region", Vault: "vault", JobId: "jobId1", Size: utils.S_1MB, Reservation: "1-1"})
+
+	// When
+	entries := readRetrievalLedger()
+
+	// Then
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "jobId1", entries[0].JobId)
+	assert.Equal(t, uint64(utils.S_1MB), entries[0].Size)
+	assert.Equal(t, "2-1", entries[1].Reservation)
+	assert.Equal(t, 1, len(withoutReservation(entries, "2-1")))
+}
+
+func TestLockRetrievalLedger_remove_stale_lock(t *testing.T) {
+	// Given
+	CommonInitTest()
+	lockFilePath := getRetrievalLedgerFilePath() + ".lock"
+	ioutil.WriteFile(lockFilePath, []byte{}, 0600)
+	staleDate := time.Now().Add(-2 * retrievalLedgerLockTimeout)
+	os.Chtimes(lockFilePath, staleDate, staleDate)
+
+	// When
+	unlock := lockRetrievalLedger()
+	stat, err := os.Stat(lockFilePath)
+	unlock()
+
+	// Then
+	assert.Nil(t, err)
+	assert.True(t, time.Since(stat.ModTime()) < retrievalLedgerLockTimeout)
+	assert.False(t, utils.Exists(lockFilePath))
+	staleLocks, _ := filepath.Glob(lockFilePath + ".*")
+	assert.Empty(t, staleLocks)
+}
+
+func TestRemoveStaleRetrievalLedgerLock_lock_taken_after_check_is_put_back(t *testing.T) {
+	// Given
+	CommonInitTest()
+	lockFilePath := getRetrievalLedgerFilePath() + ".lock"
+	ioutil.WriteFile(lockFilePath, []byte{}, 0600)
+	staleDate := time.Now().Add(-2 * retrievalLedgerLockTimeout)
+
+	// When
+	removeStaleRetrievalLedgerLock(lockFilePath, staleDate)
+
+	// Then
+	assert.True(t, utils.Exists(lockFilePath))
+	staleLocks, _ := filepath.Glob(lockFilePath + ".*")
+	assert.Empty(t, staleLocks)
+}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"rsg/awsutils"
+	"rsg/inputs"
+	"rsg/outputs"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// With the BytesPerHour strategy, jobs are sized to fit the rate of the policy: the bytes of the jobs started by rsg in
+// the region during the last 4 hours (from the retrieval ledger) are deducted from 4 hours of rate. Jobs of other
+// tools are unknown, aws can still reject a job, the rejection is then explained with the policy.
+
+func describeRetrievalPolicy(policy awsutils.DataRetrievalPolicy) string {
+	switch policy.Strategy {
+	case awsutils.FreeTierStrategy:
+		return "FreeTier: retrievals are free, jobs beyond the free allowance of the day are rejected"
+	case awsutils.BytesPerHourStrategy:
+		return fmt.Sprintf("BytesPerHour: retrievals are limited to %s/hour, they are charged beyond the free allowance", bytefmt.ByteSize(policy.BytesPerHour))
+	case awsutils.NoneStrategy:
+		return "None: retrievals are not limited, they are charged beyond the free allowance"
+	}
+	return policy.Strategy
+}
+
+// Bytes of the jobs started by rsg in the region during the retrieval rate window before the date, and date at which
+// the oldest of them leaves the window
+func retrievedInRateWindow(entries []retrievalLedgerEntry, region string, date time.Time) (uint64, time.Time) {
+	size := uint64(0)
+	leaveDate := time.Time{}
+	for _, entry := range entries {
+		if entry.Region == region && entry.Date.After(date.Add(-awsutils.RetrievalRateWindow)) {
+			size += entry.Size
+			if leaveDate.IsZero() || entry.Date.Add(awsutils.RetrievalRateWindow).Before(leaveDate) {
+				leaveDate = entry.Date.Add(awsutils.RetrievalRateWindow)
+			}
+		}
+	}
+	return size, leaveDate
+}
+
+// Bytes which can be retrieved at the date within the rate of BytesPerHour strategy, and date at which more bytes are
+// allowed. Unlimited with other strategies, aws is the only judge of the free tier.
+func policyAllowance(policy awsutils.DataRetrievalPolicy, entries []retrievalLedgerEntry, region string, date time.Time) (uint64, time.Time) {
+	if policy.Strategy != awsutils.BytesPerHourStrategy {
+		return budgetUnlimited, time.Time{}
+	}
+	maxSize := policy.BytesPerHour * uint64(awsutils.RetrievalRateWindow / time.Hour)
+	size, leaveDate := retrievedInRateWindow(entries, region, date)
+	if size >= maxSize {
+		return 0, leaveDate
+	}
+	return maxSize - size, leaveDate
+}
+
+// Date at which a job rejected by the policy at the date can be accepted: when the oldest job of rsg leaves the rate
+// window or when the window has passed for BytesPerHour strategy, next UTC day for the free tier
+func policyRejectionRetryDate(policy awsutils.DataRetrievalPolicy, entries []retrievalLedgerEntry, region string, date time.Time) time.Time {
+	retryDate := date.Add(awsutils.RetrievalRateWindow)
+	switch policy.Strategy {
+	case awsutils.FreeTierStrategy:
+		_, retryDate = periodBounds("day", date)
+	case awsutils.BytesPerHourStrategy:
+		if _, leaveDate := retrievedInRateWindow(entries, region, date); !leaveDate.IsZero() {
+			retryDate = leaveDate
+		}
+	}
+	return retryDate
+}
+
+func explainPolicyRejection(policy awsutils.DataRetrievalPolicy, entries []retrievalLedgerEntry, region string, sizeToRetrieve uint64, date time.Time) string {
+	explanation := fmt.Sprintf("Retrieval of %s rejected by the data retrieval policy %s", bytefmt.ByteSize(sizeToRetrieve), policy.Strategy)
+	switch policy.Strategy {
+	case awsutils.FreeTierStrategy:
+		explanation += ": the free allowance of the day is spent, jobs are accepted again when it is renewed"
+	case awsutils.BytesPerHourStrategy:
+		size, _ := retrievedInRateWindow(entries, region, date)
+		explanation += fmt.Sprintf(": the rate of %s/hour allows %s over %v, %s retrieved by rsg in this window, the rest by other jobs of the account",
+			bytefmt.ByteSize(policy.BytesPerHour),
+			bytefmt.ByteSize(policy.BytesPerHour * uint64(awsutils.RetrievalRateWindow / time.Hour)),
+			awsutils.RetrievalRateWindow,
+			bytefmt.ByteSize(size))
+	}
+	return explanation + " (change it with 'rsg policy set')"
+}
+
+func DisplayRetrievalPolicy(restorationContext *RestorationContext) {
+	policy := awsutils.GetDataRetrievalPolicy(restorationContext.GlacierClient)
+	outputs.Printfln(outputs.Info, "Data retrieval policy of region %s: %s", restorationContext.Region, describeRetrievalPolicy(policy))
+}
+
+// Arguments: set FreeTier|None|BytesPerHour <rate by hour>, the change is confirmed by the user
+func SetRetrievalPolicy(restorationContext *RestorationContext, args []string) {
+	policy, err := parseRetrievalPolicy(args)
+	utils.ExitIfError(err)
+	current := awsutils.GetDataRetrievalPolicy(restorationContext.GlacierClient)
+	outputs.Printfln(outputs.Info, "Current data retrieval policy of region %s: %s", restorationContext.Region, describeRetrievalPolicy(current))
+	outputs.Printfln(outputs.Info, "New data retrieval policy: %s", describeRetrievalPolicy(policy))
+	if !inputs.QueryYesOrNo(fmt.Sprintf("Change data retrieval policy of the account in region %s ? It applies to all vaults and users", restorationContext.Region), false) {
+		return
+	}
+	utils.ExitIfError(awsutils.SetDataRetrievalPolicy(restorationContext.GlacierClient, policy))
+	outputs.Printfln(outputs.Info, "Data retrieval policy changed to %s", policy.Strategy)
+}
+
+func parseRetrievalPolicy(args []string) (awsutils.DataRetrievalPolicy, error) {
+	usage := errors.New("Usage: rsg policy set FreeTier|None|BytesPerHour <rate by hour, ex: 10G>")
+	if len(args) < 2 || args[0] != "set" {
+		return awsutils.DataRetrievalPolicy{}, usage
+	}
+	for _, strategy := range []string{awsutils.FreeTierStrategy, awsutils.NoneStrategy, awsutils.BytesPerHourStrategy} {
+		if !strings.EqualFold(args[1], strategy) {
+			continue
+		}
+		if strategy != awsutils.BytesPerHourStrategy {
+			if len(args) > 2 {
+				return awsutils.DataRetrievalPolicy{}, usage
+			}
+			return awsutils.DataRetrievalPolicy{Strategy: strategy}, nil
+		}
+		if len(args) != 3 {
+			return awsutils.DataRetrievalPolicy{}, usage
+		}
+		bytesPerHour, err := bytefmt.ToBytes(args[2])
+		if err != nil || bytesPerHour == 0 {
+			return awsutils.DataRetrievalPolicy{}, errors.New(fmt.Sprintf("Invalid rate by hour: %s (ex: 10G)", args[2]))
+		}
+		return awsutils.DataRetrievalPolicy{Strategy: strategy, BytesPerHour: bytesPerHour}, nil
+	}
+	return awsutils.DataRetrievalPolicy{}, usage
+}
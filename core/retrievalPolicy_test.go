@@ -0,0 +1,198 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"errors"
+	"testing"
+	"time"
+	"rsg/awsutils"
+	"rsg/consts"
+	"rsg/inputs"
+	"rsg/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/glacier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func mockSetDataRetrievalPolicy(glacierMock *GlacierMock, rule *glacier.DataRetrievalRule) *mock.Call {
+	input := &glacier.SetDataRetrievalPolicyInput{
+		AccountId: aws.String(awsutils.AccountId),
+		Policy: &glacier.DataRetrievalPolicy{Rules: []*glacier.DataRetrievalRule{rule}},
+	}
+	return glacierMock.On("SetDataRetrievalPolicy", input).Return(&glacier.SetDataRetrievalPolicyOutput{}, nil)
+}
+
+func TestPolicyAllowance_bytes_per_hour(t *testing.T) {
+	// Given
+	policy := awsutils.DataRetrievalPolicy{Strategy: awsutils.BytesPerHourStrategy, BytesPerHour: utils.S_1GB}
+	date := time.Date(2016, 10, 20, 15, 0, 0, 0, time.UTC)
+	entries := []retrievalLedgerEntry{
+		{Date: time.Date(2016, 10, 20, 10, 0, 0, 0, time.UTC), Region: "region", Size: 3 * utils.S_1GB},
+		{Date: time.Date(2016, 10, 20, 12, 0, 0, 0, time.UTC), Region: "region", Size: utils.S_1GB},
+		{Date: time.Date(2016, 10, 20, 13, 0, 0, 0, time.UTC), Region: "region", Size: 2 * utils.S_1GB},
+		{Date: time.Date(2016, 10, 20, 14, 0, 0, 0, time.UTC), Region: "other-region", Size: 5 * utils.S_1GB},
+	}
+
+	// When
+	allowance, leaveDate := policyAllowance(policy, entries, "region", date)
+
+	// Then
+	assert.Equal(t, uint64(utils.S_1GB), allowance)
+	assert.Equal(t, time.Date(2016, 10, 20, 16, 0, 0, 0, time.UTC), leaveDate)
+}
+
+func TestPolicyAllowance_other_strategies(t *testing.T) {
+	// Given
+	entries := []retrievalLedgerEntry{{Date: time.Now(), Region: "region", Size: utils.S_1GB}}
+
+	// When
+	freeTierAllowance, _ := policyAllowance(awsutils.DataRetrievalPolicy{Strategy: awsutils.FreeTierStrategy}, entries, "region", time.Now())
+	noneAllowance, _ := policyAllowance(awsutils.DataRetrievalPolicy{Strategy: awsutils.NoneStrategy}, entries, "region", time.Now())
+
+	// Then
+	assert.Equal(t, budgetUnlimited, freeTierAllowance)
+	assert.Equal(t, budgetUnlimited, noneAllowance)
+}
+
+func TestExplainPolicyRejection_bytes_per_hour(t *testing.T) {
+	// Given
+	policy := awsutils.DataRetrievalPolicy{Strategy: awsutils.BytesPerHourStrategy, BytesPerHour: utils.S_1GB}
+	date := time.Date(2016, 10, 20, 15, 0, 0, 0, time.UTC)
+	entries := []retrievalLedgerEntry{{Date: time.Date(2016, 10, 20, 13, 0, 0, 0, time.UTC), Region: "region", Size: 3 * utils.S_1GB}}
+
+	// When
+	explanation := explainPolicyRejection(policy, entries, "region", 2 * utils.S_1GB, date)
+
+	// Then
+	assert.Equal(t, "Retrieval of 2G rejected by the data retrieval policy BytesPerHour: the rate of 1G/hour allows 4G over 4h0m0s, " +
+		"3G retrieved by rsg in this window, the rest by other jobs of the account (change it with 'rsg policy set')", explanation)
+}
+
+func TestParseRetrievalPolicy(t *testing.T) {
+	policy, err := parseRetrievalPolicy([]string{"set", "bytesperhour", "10G"})
+	assert.Nil(t, err)
+	assert.Equal(t, awsutils.DataRetrievalPolicy{Strategy: awsutils.BytesPerHourStrategy, BytesPerHour: 10 * utils.S_1GB}, policy)
+
+	policy, err = parseRetrievalPolicy([]string{"set", "FreeTier"})
+	assert.Nil(t, err)
+	assert.Equal(t, awsutils.DataRetrievalPolicy{Strategy: awsutils.FreeTierStrategy}, policy)
+
+	_, err = parseRetrievalPolicy([]string{"set", "BytesPerHour"})
+	assert.NotNil(t, err)
+	_, err = parseRetrievalPolicy([]string{"set", "None", "10G"})
+	assert.NotNil(t, err)
+	_, err = parseRetrievalPolicy([]string{"get"})
+	assert.NotNil(t, err)
+}
+
+func TestSetRetrievalPolicy_confirmed(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	inputs.StdinReader = bufio.NewReader(bytes.NewReader([]byte("y" + consts.LINE_BREAK)))
+	mockSetDataRetrievalPolicy(glacierMock, &glacier.DataRetrievalRule{Strategy: aws.String("BytesPerHour"), BytesPerHour: aws.Int64(int64(utils.S_1GB))})
+
+	// When
+	SetRetrievalPolicy(restorationContext, []string{"set", "BytesPerHour", "1G"})
+
+	// Then
+	glacierMock.AssertNumberOfCalls(t, "SetDataRetrievalPolicy", 1)
+}
+
+func TestSetRetrievalPolicy_not_confirmed(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	inputs.StdinReader = bufio.NewReader(bytes.NewReader([]byte(consts.LINE_BREAK)))
+
+	// When
+	SetRetrievalPolicy(restorationContext, []string{"set", "None"})
+
+	// Then
+	glacierMock.AssertNotCalled(t, "SetDataRetrievalPolicy", mock.Anything)
+}
+
+func TestStartArchiveRetrievingJobs_within_rate_of_policy(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	jobDate := time.Now().UTC().Add(-time.Hour)
+	appendRetrievalLedgerEntry(retrievalLedgerEntry{Date: jobDate, Region: "region", Vault: "other_vault", JobId: "jobId0", Size: 2 * utils.S_1MB})
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: 4 * utils.S_1MB,
+		archivePartRetrievalListMaxSize: 10,
+		archivePartRetrieveList: list.New(),
+		unwrittenSizeByArchiveId: make(map[string]uint64),
+		uncompletedRetrieve: &archiveRetrieve{archiveId: "archiveId1", size: 4 * utils.S_1MB, endByteIndex: 4 * utils.S_1MB},
+		policy: awsutils.DataRetrievalPolicy{Strategy: awsutils.BytesPerHourStrategy, BytesPerHour: utils.S_1MB},
+	}
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-2097151", "jobId1").Once()
+
+	// When
+	result := downloadContext.startArchiveRetrievingJobs()
+
+	// Then
+	assert.Equal(t, ALLOWANCE_SPENT, result)
+	assert.Equal(t, uint64(2 * utils.S_1MB), downloadContext.uncompletedRetrieve.nextByteIndexToRetrieve)
+	assert.Equal(t, "rate of data retrieval policy", downloadContext.allowanceLimit)
+	assert.Equal(t, jobDate.Add(awsutils.RetrievalRateWindow).Unix(), downloadContext.allowanceResetDate.Unix())
+}
+
+func TestStartArchiveRetrievingJobs_rejected_by_policy_with_smaller_job(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: 4 * utils.S_1MB,
+		archivePartRetrievalListMaxSize: 1,
+		archivePartRetrieveList: list.New(),
+		unwrittenSizeByArchiveId: make(map[string]uint64),
+		uncompletedRetrieve: &archiveRetrieve{archiveId: "archiveId1", size: 4 * utils.S_1MB, endByteIndex: 4 * utils.S_1MB},
+	}
+	mockStartPartialRetrieveJobWithError(glacierMock, restorationContext.Vault, "archiveId1", "0-4194303", errors.New("PolicyEnforcedException: over the free tier")).Once()
+	mockStartPartialRetrieveJob(glacierMock, restorationContext.Vault, "archiveId1", "0-2097151", "jobId1").Once()
+
+	// When
+	result := downloadContext.startArchiveRetrievingJobs()
+
+	// Then
+	assert.Equal(t, STARTED, result)
+	assert.Equal(t, uint64(2 * utils.S_1MB), downloadContext.uncompletedRetrieve.nextByteIndexToRetrieve)
+	assert.Equal(t, "jobId1", downloadContext.archivePartRetrieveList.Front().Value.(*archivePartRetrieve).jobId)
+}
+
+func TestStartArchiveRetrievingJobs_rejected_by_free_tier_until_next_day(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	downloadContext := DownloadContext{
+		restorationContext: restorationContext,
+		speedInBytesBySec: 1,
+		archivesRetrievalMaxSize: 2 * utils.S_1MB,
+		archivePartRetrievalListMaxSize: 10,
+		archivePartRetrieveList: list.New(),
+		unwrittenSizeByArchiveId: make(map[string]uint64),
+		uncompletedRetrieve: &archiveRetrieve{archiveId: "archiveId1", size: 4 * utils.S_1MB, endByteIndex: 4 * utils.S_1MB},
+	}
+	rejection := errors.New("PolicyEnforcedException: over the free tier")
+	mockStartPartialRetrieveJobWithError(glacierMock, restorationContext.Vault, "archiveId1", "0-2097151", rejection).Once()
+	mockStartPartialRetrieveJobWithError(glacierMock, restorationContext.Vault, "archiveId1", "0-1048575", rejection).Once()
+
+	// When
+	result := downloadContext.startArchiveRetrievingJobs()
+
+	// Then
+	assert.Equal(t, ALLOWANCE_SPENT, result)
+	assert.Equal(t, uint64(0), downloadContext.uncompletedRetrieve.nextByteIndexToRetrieve)
+	assert.Equal(t, "data retrieval policy", downloadContext.allowanceLimit)
+	_, nextDay := periodBounds("day", time.Now())
+	assert.Equal(t, nextDay, downloadContext.allowanceResetDate)
+	glacierMock.AssertExpectations(t)
+}
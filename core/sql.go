@@ -2,68 +2,361 @@ package core
 
 import (
 	"database/sql"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+	"errors"
+	"fmt"
+	"rsg/awsutils"
 	"rsg/outputs"
-	"rsg/utils"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // Sql interactions with mapping file
 
-func InitDb(file string) *sql.DB {
-	db, err := sql.Open("sqlite3", file)
-	utils.ExitIfError(err)
-	return db
+type sqliteMappingStore struct {
+	db                    *sql.DB
+	schema                *MappingSchema
+	fullText              bool      // true if the full text table of the mapping index is available
+	pathsForArchiveStmt   *sql.Stmt // prepared on first use then reused
+	entriesForArchiveStmt *sql.Stmt // prepared on first use then reused
 }
 
-func GetFiles(db *sql.DB, filters []string) *sql.Rows {
-	where := buildWhereFromFilters(filters)
-	sqlQuery := "SELECT basePath FROM file_info_tb " + where + " ORDER BY basePath"
-	rows, err := db.Query(sqlQuery)
-	utils.ExitIfError(err)
-	return rows
+// The mapping file is never written, sqlite opens it read-only
+func readOnlyDataSourceName(file string) string {
+	return "file:" + strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(file) + "?mode=ro"
 }
 
-func GetArchives(db *sql.DB, filters []string) *sql.Rows {
-	where := buildWhereFromFilters(filters)
-	sqlQuery := "SELECT DISTINCT archiveId, fileSize FROM file_info_tb " + where + " ORDER BY key"
+// The mapping index is used instead of the mapping file if it is up to date
+func OpenMappingStore(file string) (MappingStore, error) {
+	fileToOpen := file
+	indexUpToDate, fullText := mappingIndexIsUpToDate(file)
+	if indexUpToDate {
+		fileToOpen = mappingIndexFilePath(file)
+		outputs.Printfln(outputs.Verbose, "Use mapping index %s", fileToOpen)
+	}
+	db, err := sql.Open("sqlite3", readOnlyDataSourceName(fileToOpen))
+	if err != nil {
+		return nil, err
+	}
+	schema, err := DetectMappingSchema(db)
+	if err != nil {
+		db.Close()
+		return nil, errors.New(fmt.Sprintf("%v (mapping file %s)", err, fileToOpen))
+	}
+	return &sqliteMappingStore{db: db, schema: schema, fullText: fullText}, nil
+}
+
+func (store *sqliteMappingStore) table() string {
+	return quoteIdentifier(store.schema.Table)
+}
+
+func (store *sqliteMappingStore) Files(filter MappingFilter) (MappingEntryIterator, error) {
+	sqlQuery := "SELECT " + store.schema.entryColumns() + " FROM " + store.table() + " " + store.buildWhere(filter) + " ORDER BY " + quoteIdentifier(store.schema.BasePath) + ", " + quoteIdentifier(store.schema.Key)
+	outputs.Printfln(outputs.Verbose, "Query mapping file for files: %v", sqlQuery)
+	rows, err := store.db.Query(sqlQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteMappingEntryIterator{rows: rows}, nil
+}
+
+func (store *sqliteMappingStore) Archives(filter MappingFilter) (ArchiveIterator, error) {
+	sqlQuery := "SELECT DISTINCT " + quoteIdentifier(store.schema.ArchiveId) + ", " + quoteIdentifier(store.schema.FileSize) + " FROM " + store.table() + " " + store.buildWhere(filter) + " ORDER BY " + quoteIdentifier(store.schema.Key)
 	outputs.Printfln(outputs.Verbose, "Query mapping file for archives: %v", sqlQuery)
-	rows, err := db.Query(sqlQuery)
-	utils.ExitIfError(err)
-	return rows
-}
-
-func GetPaths(db *sql.DB, archiveId string) *sql.Rows {
-	stmt, err := db.Prepare("SELECT DISTINCT shareName || '/' || basePath FROM file_info_tb WHERE archiveId = ?")
-	utils.ExitIfError(err)
-	defer stmt.Close()
-	rows, err := stmt.Query(archiveId)
-	utils.ExitIfError(err)
-	return rows
-}
-
-func GetTotalSize(db *sql.DB, filters []string) uint64 {
-	where := buildWhereFromFilters(filters)
-	//row := db.QueryRow("SELECT sum(fileSize) FROM file_info_tb " + where + "GROUP BY archiveId")
-	row := db.QueryRow("SELECT sum(t.fileSize) FROM (SELECT fileSize FROM file_info_tb " + where + " GROUP BY archiveId) t")
-	var totalSize uint64
-	err := row.Scan(&totalSize)
-	utils.ExitIfError(err)
-	return totalSize
-}
-
-func buildWhereFromFilters(filters []string) string {
-	where := ""
-	if len(filters) > 0 {
-		where = "WHERE "
-		for i, filter := range filters {
-			filter = strings.Replace(filter, "*", "%", -1)
-			filter = strings.Replace(filter, "?", "_", -1)
-			if i > 0 {
-				where += " OR "
-			}
-			where += "basepath LIKE '" + filter + "'"
-		}
-	}
-	return where
+	rows, err := store.db.Query(sqlQuery)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteArchiveIterator{rows: rows}, nil
+}
+
+func (store *sqliteMappingStore) PathsForArchive(archiveId string) ([]string, error) {
+	if store.pathsForArchiveStmt == nil {
+		stmt, err := store.db.Prepare("SELECT DISTINCT " + store.schema.pathExpression() + " FROM " + store.table() + " WHERE " + quoteIdentifier(store.schema.ArchiveId) + " = ?")
+		if err != nil {
+			return nil, err
+		}
+		store.pathsForArchiveStmt = stmt
+	}
+	rows, err := store.pathsForArchiveStmt.Query(archiveId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	paths := []string{}
+	for rows.Next() {
+		var path string
+		if err = rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+func (store *sqliteMappingStore) EntriesForArchive(archiveId string) ([]*MappingEntry, error) {
+	if store.entriesForArchiveStmt == nil {
+		stmt, err := store.db.Prepare("SELECT " + store.schema.entryColumns() + " FROM " + store.table() + " WHERE " + quoteIdentifier(store.schema.ArchiveId) + " = ? ORDER BY " + quoteIdentifier(store.schema.Key))
+		if err != nil {
+			return nil, err
+		}
+		store.entriesForArchiveStmt = stmt
+	}
+	rows, err := store.entriesForArchiveStmt.Query(archiveId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []*MappingEntry{}
+	for rows.Next() {
+		mappingEntry, err := scanMappingEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, mappingEntry)
+	}
+	return entries, rows.Err()
+}
+
+func (store *sqliteMappingStore) Versions(filter MappingFilter) (map[string][]*MappingEntry, error) {
+	filter.Version = ""
+	pathColumns := quoteIdentifier(store.schema.ShareName) + ", " + quoteIdentifier(store.schema.BasePath)
+	sqlQuery := "SELECT " + store.schema.entryColumns() + " FROM " + store.table() + " JOIN (SELECT " + pathColumns +
+		" FROM " + store.table() + " " + store.buildWhere(filter) + " GROUP BY " + pathColumns +
+		" HAVING count(DISTINCT " + quoteIdentifier(store.schema.ArchiveId) + ") > 1) versionPaths USING (" + pathColumns + ")" +
+		" ORDER BY " + quoteIdentifier(store.schema.Key)
+	outputs.Printfln(outputs.Verbose, "Query mapping file for versions: %v", sqlQuery)
+	rows, err := store.db.Query(sqlQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versionsByPath := make(map[string][]*MappingEntry)
+	for rows.Next() {
+		mappingEntry, err := scanMappingEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		versionsByPath[mappingEntry.Path()] = append(versionsByPath[mappingEntry.Path()], mappingEntry)
+	}
+	return versionsByPath, rows.Err()
+}
+
+func (store *sqliteMappingStore) TotalSize(filter MappingFilter) (uint64, error) {
+	row := store.db.QueryRow("SELECT sum(t.fileSize) FROM (SELECT " + quoteIdentifier(store.schema.FileSize) + " AS fileSize FROM " + store.table() + " " + store.buildWhere(filter) + " GROUP BY " + quoteIdentifier(store.schema.ArchiveId) + ") t")
+	var totalSize sql.NullInt64
+	if err := row.Scan(&totalSize); err != nil {
+		return 0, err
+	}
+	return uint64(totalSize.Int64), nil
+}
+
+// The base path of files in a directory is in a range (the character after '/' is '0'), the range uses the index on
+// share name and base path of the mapping index
+func (store *sqliteMappingStore) Directory(path string) ([]DirectoryEntry, error) {
+	shareName, basePath, fileSize := quoteIdentifier(store.schema.ShareName), quoteIdentifier(store.schema.BasePath), quoteIdentifier(store.schema.FileSize)
+	sqlQuery := "SELECT " + shareName + ", 1, sum(" + fileSize + ") FROM " + store.table() + " GROUP BY " + shareName + " ORDER BY " + shareName
+	if path != "" {
+		share, prefix := path, ""
+		if index := strings.Index(path, "/"); index >= 0 {
+			share, prefix = path[:index], path[index + 1:] + "/"
+		}
+		conditions := shareName + " = " + quoteString(share)
+		if prefix != "" {
+			conditions += " AND " + basePath + " >= " + quoteString(prefix) + " AND " + basePath + " < " + quoteString(strings.TrimSuffix(prefix, "/") + "0")
+		}
+		sqlQuery = "SELECT CASE WHEN instr(rest, '/') > 0 THEN substr(rest, 1, instr(rest, '/') - 1) ELSE rest END AS name," +
+			" max(instr(rest, '/') > 0), sum(size) FROM (SELECT " + fmt.Sprintf("substr(%s, %d)", basePath, utf8.RuneCountInString(prefix) + 1) +
+			" AS rest, " + fileSize + " AS size FROM " + store.table() + " WHERE " + conditions + ") GROUP BY name ORDER BY name"
+	}
+	outputs.Printfln(outputs.Verbose, "Query mapping file for directory: %v", sqlQuery)
+	rows, err := store.db.Query(sqlQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []DirectoryEntry{}
+	for rows.Next() {
+		var name sql.NullString
+		var dir bool
+		var size sql.NullInt64
+		if err = rows.Scan(&name, &dir, &size); err != nil {
+			return nil, err
+		}
+		entries = append(entries, DirectoryEntry{Name: name.String, Dir: dir, Size: uint64(size.Int64)})
+	}
+	return entries, rows.Err()
+}
+
+func (store *sqliteMappingStore) HasFileMetadata() bool {
+	return store.schema.Mtime != "" || store.schema.Ctime != "" || store.schema.Mode != ""
+}
+
+func (store *sqliteMappingStore) HasFullText() bool {
+	return store.fullText
+}
+
+func (store *sqliteMappingStore) Close() error {
+	for _, stmt := range []*sql.Stmt{store.pathsForArchiveStmt, store.entriesForArchiveStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+	return store.db.Close()
+}
+
+func (store *sqliteMappingStore) buildWhere(filter MappingFilter) string {
+	conditions := []string{}
+	if len(filter.Patterns) > 0 {
+		patternConditions := []string{}
+		for _, pattern := range filter.Patterns {
+			pattern = strings.Replace(pattern, "*", "%", -1)
+			pattern = strings.Replace(pattern, "?", "_", -1)
+			patternConditions = append(patternConditions, quoteIdentifier(store.schema.BasePath) + " LIKE " + quoteString(pattern))
+		}
+		conditions = append(conditions, "(" + strings.Join(patternConditions, " OR ") + ")")
+	}
+	if filter.FullText && len(filter.Terms) > 0 {
+		if store.fullText {
+			conditions = append(conditions, quoteIdentifier(store.schema.Key) + " IN (SELECT rowid FROM " + mappingIndexFullTextTable + " WHERE " + mappingIndexFullTextTable + " MATCH " + quoteString(fullTextQuery(filter.Terms)) + ")")
+		}
+	}
+	if !filter.FullText || !store.fullText {
+		for _, term := range filter.Terms {
+			conditions = append(conditions, store.schema.pathExpression() + " LIKE " + quoteString("%" + escapeLike(term) + "%") + " ESCAPE '\\'")
+		}
+	}
+	if len(filter.Extensions) > 0 {
+		extensionConditions := []string{}
+		for _, extension := range filter.Extensions {
+			extensionConditions = append(extensionConditions, quoteIdentifier(store.schema.BasePath) + " LIKE " + quoteString("%." + escapeLike(extension)) + " ESCAPE '\\'")
+		}
+		conditions = append(conditions, "(" + strings.Join(extensionConditions, " OR ") + ")")
+	}
+	if len(filter.Shares) > 0 {
+		shares := []string{}
+		for _, share := range filter.Shares {
+			shares = append(shares, quoteString(share))
+		}
+		conditions = append(conditions, quoteIdentifier(store.schema.ShareName) + " IN (" + strings.Join(shares, ", ") + ")")
+	}
+	if len(filter.Paths) > 0 {
+		pathConditions := []string{}
+		for _, path := range filter.Paths {
+			pathConditions = append(pathConditions, store.schema.pathExpression() + " = " + quoteString(path),
+				fmt.Sprintf("substr(%s, 1, %d) = %s", store.schema.pathExpression(), utf8.RuneCountInString(path) + 1, quoteString(path + "/")))
+		}
+		conditions = append(conditions, "(" + strings.Join(pathConditions, " OR ") + ")")
+	}
+	if len(filter.ArchiveIds) > 0 {
+		archiveIds := []string{}
+		for _, archiveId := range filter.ArchiveIds {
+			archiveIds = append(archiveIds, quoteString(archiveId))
+		}
+		conditions = append(conditions, quoteIdentifier(store.schema.ArchiveId) + " IN (" + strings.Join(archiveIds, ", ") + ")")
+	}
+	if filter.Version != "" {
+		key := quoteIdentifier(store.schema.Key)
+		selectedKey := "max(" + key + ")"
+		if filter.Version == OldestVersion {
+			selectedKey = "min(" + key + ")"
+		} else if filter.Version != LatestVersion {
+			versionKey, _ := strconv.ParseInt(filter.Version, 10, 64)
+			selectedKey = fmt.Sprintf("CASE WHEN sum(%s = %d) > 0 THEN %d ELSE max(%s) END", key, versionKey, versionKey, key)
+		}
+		conditions = append(conditions, key + " IN (SELECT " + selectedKey + " FROM " + store.table() +
+			" GROUP BY " + quoteIdentifier(store.schema.ShareName) + ", " + quoteIdentifier(store.schema.BasePath) + ")")
+	}
+	if filter.MinSize > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s >= %d", quoteIdentifier(store.schema.FileSize), filter.MinSize))
+	}
+	if filter.MaxSize > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s <= %d", quoteIdentifier(store.schema.FileSize), filter.MaxSize))
+	}
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+func quoteString(value string) string {
+	return "'" + strings.Replace(value, "'", "''", -1) + "'"
+}
+
+// Escape wildcards of LIKE, used with ESCAPE '\'
+func escapeLike(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "%", "\\%", -1)
+	return strings.Replace(value, "_", "\\_", -1)
+}
+
+// Each term is a prefix of a word of the path, words are separated by '/', '.', spaces...
+func fullTextQuery(terms []string) string {
+	phrases := []string{}
+	for _, term := range terms {
+		phrases = append(phrases, "\"" + strings.Replace(term, "\"", "\"\"", -1) + "\"*")
+	}
+	return strings.Join(phrases, " AND ")
+}
+
+type sqliteMappingEntryIterator struct {
+	rows  *sql.Rows
+	entry *MappingEntry
+	err   error
+}
+
+func (iterator *sqliteMappingEntryIterator) Next() bool {
+	if iterator.err != nil || !iterator.rows.Next() {
+		return false
+	}
+	iterator.entry, iterator.err = scanMappingEntry(iterator.rows)
+	return iterator.err == nil
+}
+
+func (iterator *sqliteMappingEntryIterator) Entry() *MappingEntry {
+	return iterator.entry
+}
+
+func (iterator *sqliteMappingEntryIterator) Err() error {
+	if iterator.err != nil {
+		return iterator.err
+	}
+	return iterator.rows.Err()
+}
+
+func (iterator *sqliteMappingEntryIterator) Close() error {
+	return iterator.rows.Close()
+}
+
+type sqliteArchiveIterator struct {
+	rows    *sql.Rows
+	archive awsutils.Archive
+	err     error
+}
+
+func (iterator *sqliteArchiveIterator) Next() bool {
+	if iterator.err != nil || !iterator.rows.Next() {
+		return false
+	}
+	var archiveId sql.NullString
+	var size sql.NullInt64
+	iterator.err = iterator.rows.Scan(&archiveId, &size)
+	iterator.archive = awsutils.Archive{ArchiveId: archiveId.String, Size: uint64(size.Int64)}
+	return iterator.err == nil
+}
+
+func (iterator *sqliteArchiveIterator) Archive() awsutils.Archive {
+	return iterator.archive
+}
+
+func (iterator *sqliteArchiveIterator) Err() error {
+	if iterator.err != nil {
+		return iterator.err
+	}
+	return iterator.rows.Err()
+}
+
+func (iterator *sqliteArchiveIterator) Close() error {
+	return iterator.rows.Close()
 }
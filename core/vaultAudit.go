@@ -0,0 +1,113 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"rsg/awsutils"
+	"rsg/outputs"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Audit of the vault: the archives of the mapping file are compared with the archives of a full inventory of the
+// vault, to know that a backup is restorable before it is needed.
+// Archives of empty files are not checked, they are not stored in the vault.
+
+type archiveSizeMismatch struct {
+	archiveId   string
+	mappingSize uint64
+	vaultSize   uint64
+}
+
+type auditResult struct {
+	mappingArchives int
+	missing         []awsutils.Archive          // referenced by the mapping file, not in the vault
+	orphans         []awsutils.InventoryArchive // in the vault, not referenced by the mapping file, oldest first
+	sizeMismatches  []archiveSizeMismatch
+}
+
+func (result *auditResult) orphansSize() uint64 {
+	size := uint64(0)
+	for _, orphan := range result.orphans {
+		size += orphan.Size
+	}
+	return size
+}
+
+// Exits with an error if archives of the mapping file are missing or have a wrong size
+func AuditVault(restorationContext *RestorationContext) {
+	vaultInventory := InventoryDataVault(restorationContext)
+	mappingStore := InitMappingStore(restorationContext.GetMappingFilePath())
+	defer mappingStore.Close()
+	result, err := reconcileVaultInventory(mappingStore, vaultInventory)
+	utils.ExitIfError(err)
+
+	for _, archive := range result.missing {
+		paths, err := mappingStore.PathsForArchive(archive.ArchiveId)
+		utils.ExitIfError(err)
+		outputs.Printfln(outputs.Info, "missing\t%s\t%s\t%s", archive.ArchiveId, bytefmt.ByteSize(archive.Size), describePaths(paths))
+	}
+	for _, mismatch := range result.sizeMismatches {
+		outputs.Printfln(outputs.Info, "size\t%s\t%s in mapping, %s in vault", mismatch.archiveId, bytefmt.ByteSize(mismatch.mappingSize), bytefmt.ByteSize(mismatch.vaultSize))
+	}
+	for _, orphan := range result.orphans {
+		outputs.Printfln(outputs.Info, "orphan\t%s\t%s\t%s", orphan.ArchiveId, bytefmt.ByteSize(orphan.Size), orphan.CreationDate)
+	}
+	outputs.Printfln(outputs.Info, "%d archive(s) in mapping, %d archive(s) in vault (inventory of %s): %d missing, %d with wrong size, %d orphaned (%s)",
+		result.mappingArchives, len(vaultInventory.ArchiveList), vaultInventory.InventoryDate,
+		len(result.missing), len(result.sizeMismatches), len(result.orphans), bytefmt.ByteSize(result.orphansSize()))
+	if len(result.orphans) > 0 {
+		outputs.Println(outputs.OptionalInfo, "Orphaned archives can be deleted by prune command")
+	}
+	if len(result.missing) > 0 || len(result.sizeMismatches) > 0 {
+		utils.ExitIfError(errors.New(fmt.Sprintf("Backup is not fully restorable: %d archive(s) missing or with wrong size", len(result.missing) + len(result.sizeMismatches))))
+	}
+}
+
+func reconcileVaultInventory(mappingStore MappingStore, vaultInventory *awsutils.VaultInventory) (*auditResult, error) {
+	inventoryArchiveById := make(map[string]awsutils.InventoryArchive)
+	for _, inventoryArchive := range vaultInventory.ArchiveList {
+		inventoryArchiveById[inventoryArchive.ArchiveId] = inventoryArchive
+	}
+	archiveIterator, err := mappingStore.Archives(MappingFilter{})
+	if err != nil {
+		return nil, err
+	}
+	defer archiveIterator.Close()
+	result := &auditResult{}
+	referenced := make(map[string]bool)
+	for archiveIterator.Next() {
+		archive := archiveIterator.Archive()
+		referenced[archive.ArchiveId] = true
+		result.mappingArchives++
+		inventoryArchive, inVault := inventoryArchiveById[archive.ArchiveId]
+		switch {
+		case !inVault && archive.Size > 0:
+			result.missing = append(result.missing, archive)
+		case inVault && inventoryArchive.Size != archive.Size:
+			result.sizeMismatches = append(result.sizeMismatches, archiveSizeMismatch{archive.ArchiveId, archive.Size, inventoryArchive.Size})
+		}
+	}
+	if err = archiveIterator.Err(); err != nil {
+		return nil, err
+	}
+	for _, inventoryArchive := range vaultInventory.ArchiveList {
+		if !referenced[inventoryArchive.ArchiveId] {
+			result.orphans = append(result.orphans, inventoryArchive)
+		}
+	}
+	sort.Stable(sort.Reverse(byCreationDateDesc(result.orphans)))
+	return result, nil
+}
+
+// First path and number of other paths
+func describePaths(paths []string) string {
+	switch len(paths) {
+	case 0:
+		return ""
+	case 1:
+		return paths[0]
+	}
+	return fmt.Sprintf("%s and %d other path(s)", paths[0], len(paths) - 1)
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"testing"
+	"rsg/awsutils"
+	"rsg/consts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcileVaultInventory(t *testing.T) {
+	// Given
+	CommonInitTest()
+	db := createMappingFromFixture(t, "mapping_dsm5.sqllite", "../../testtmp/cache/mapping.sqllite")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'empty.txt', 'archiveId3', 0);")
+	db.Exec("INSERT INTO `file_info_tb` (shareName, basePath, archiveID, fileSize) VALUES ('share', 'lost.txt', 'archiveId4', 7);")
+	db.Close()
+	mappingStore := InitMappingStore("../../testtmp/cache/mapping.sqllite")
+	defer mappingStore.Close()
+	vaultInventory := &awsutils.VaultInventory{InventoryDate: "2016-10-20T10:00:00Z", ArchiveList: []awsutils.InventoryArchive{
+		{ArchiveId: "orphanB", CreationDate: "2016-10-02T10:00:00Z", Size: 3},
+		{ArchiveId: "archiveId1", CreationDate: "2016-09-01T10:00:00Z", Size: 5},
+		{ArchiveId: "archiveId2", CreationDate: "2016-09-01T10:00:00Z", Size: 13},
+		{ArchiveId: "orphanA", CreationDate: "2016-09-01T10:00:00Z", Size: 4},
+	}}
+
+	// When
+	result, err := reconcileVaultInventory(mappingStore, vaultInventory)
+
+	// Then
+	assert.Nil(t, err)
+	assert.Equal(t, 4, result.mappingArchives)
+	assert.Equal(t, []awsutils.Archive{{ArchiveId: "archiveId4", Size: 7}}, result.missing)
+	assert.Equal(t, []archiveSizeMismatch{{"archiveId2", 12, 13}}, result.sizeMismatches)
+	assert.Equal(t, []awsutils.InventoryArchive{vaultInventory.ArchiveList[3], vaultInventory.ArchiveList[0]}, result.orphans)
+	assert.Equal(t, uint64(7), result.orphansSize())
+}
+
+func TestAuditVault_restorable_backup(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := InitTestWithGlacier()
+	createMappingFromFixture(t, "mapping_dsm5.sqllite", restorationContext.GetMappingFilePath()).Close()
+
+	mockStartMappingJobInventory(glacierMock, restorationContext.Vault)
+	mockDescribeJob(glacierMock, "inventoryMappingJobId", restorationContext.Vault, true)
+	mockOutputJob(glacierMock, "inventoryMappingJobId", restorationContext.Vault, []byte("{\"InventoryDate\":\"2016-10-20T10:00:00Z\",\"ArchiveList\":[" +
+		"{\"ArchiveId\":\"archiveId1\",\"CreationDate\":\"2016-09-01T10:00:00Z\",\"Size\":5}," +
+		"{\"ArchiveId\":\"archiveId2\",\"CreationDate\":\"2016-09-01T10:00:00Z\",\"Size\":12}," +
+		"{\"ArchiveId\":\"orphanA\",\"CreationDate\":\"2016-09-02T10:00:00Z\",\"Size\":1024}]}"))
+
+	// When
+	AuditVault(restorationContext)
+
+	// Then
+	assert.Equal(t, "Job to inventory vault has started (can last up to 4 hours): inventoryMappingJobId" + consts.LINE_BREAK +
+		"Job has finished: inventoryMappingJobId" + consts.LINE_BREAK +
+		"orphan\torphanA\t1K\t2016-09-02T10:00:00Z" + consts.LINE_BREAK +
+		"2 archive(s) in mapping, 3 archive(s) in vault (inventory of 2016-10-20T10:00:00Z): 0 missing, 0 with wrong size, 1 orphaned (1K)" + consts.LINE_BREAK +
+		"Orphaned archives can be deleted by prune command" + consts.LINE_BREAK,
+		string(buffer.Bytes()))
+	vaultInventory := readVaultInventory(restorationContext)
+	assert.Equal(t, "2016-10-20T10:00:00Z", vaultInventory.InventoryDate)
+	assert.Equal(t, 3, len(vaultInventory.ArchiveList))
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"rsg/awsutils"
+	"rsg/outputs"
+	"rsg/utils"
+)
+
+// Inventory of a vault, done by an aws job (can last up to 4 hours). A page of the inventory gives a marker when
+// there are more archives, the next page is retrieved by a new job from this marker.
+// The last inventory of the vault is kept in the working directory.
+
+func (restorationContext *RestorationContext) GetInventoryFilePath() string {
+	return restorationContext.WorkingDirPath + "/inventory.json"
+}
+
+// Archives of all inventory pages of the vault, from the job of the first page
+func inventoryVault(restorationContext *RestorationContext, vault, jobId string, jobCompleted bool) *awsutils.VaultInventory {
+	vaultInventory := &awsutils.VaultInventory{}
+	for {
+		if !jobCompleted {
+			awsutils.WaitJobIsCompleted(restorationContext.GlacierClient, vault, jobId)
+			outputs.Printfln(outputs.OptionalInfo, "Job has finished: %s", jobId)
+		}
+		page, marker := awsutils.GetInventoryFromJob(restorationContext.GlacierClient, vault, jobId)
+		if vaultInventory.InventoryDate == "" {
+			vaultInventory.InventoryDate = page.InventoryDate
+		}
+		vaultInventory.ArchiveList = append(vaultInventory.ArchiveList, page.ArchiveList...)
+		if marker == nil {
+			return vaultInventory
+		}
+		jobId = awsutils.StartInventoryJob(restorationContext.GlacierClient, vault, marker)
+		jobCompleted = false
+		outputs.Printfln(outputs.OptionalInfo, "Job to get next archives of vault %s has started (can last up to 4 hours): %s", vault, jobId)
+	}
+}
+
+// Inventory of the vault (not the mapping vault) from the job found at startup or from a new job, kept in the
+// working directory
+func InventoryDataVault(restorationContext *RestorationContext) *awsutils.VaultInventory {
+	jobId, jobCompleted := checkInventoryOrStartNewJob(restorationContext)
+	vaultInventory := inventoryVault(restorationContext, restorationContext.Vault, jobId, jobCompleted)
+	writeVaultInventory(restorationContext, vaultInventory)
+	return vaultInventory
+}
+
+func checkInventoryOrStartNewJob(restorationContext *RestorationContext) (string, bool) {
+	jobId := awsutils.JobIdsAtStartup.InventoryJobId
+	if jobId != "" {
+		outputs.Printfln(outputs.Verbose, "Vault inventory job id found : %s", jobId)
+		jobCompleted, err := awsutils.JobIsCompleted(restorationContext.GlacierClient, restorationContext.Vault, jobId)
+		if err == nil {
+			if !jobCompleted {
+				outputs.Printfln(outputs.OptionalInfo, "Job to inventory vault is in progress (can last up to 4 hours): %s", jobId)
+			}
+			return jobId, jobCompleted
+		} else if !strings.Contains(err.Error(), "The job ID was not found") {
+			utils.ExitIfError(err)
+		}
+		outputs.Println(outputs.Warning, "Inventory job cached for vault was not found")
+	}
+	jobId = awsutils.StartInventoryJob(restorationContext.GlacierClient, restorationContext.Vault, nil)
+	outputs.Printfln(outputs.OptionalInfo, "Job to inventory vault has started (can last up to 4 hours): %s", jobId)
+	return jobId, false
+}
+
+func writeVaultInventory(restorationContext *RestorationContext, vaultInventory *awsutils.VaultInventory) {
+	content, err := json.Marshal(vaultInventory)
+	utils.ExitIfError(err)
+	err = ioutil.WriteFile(restorationContext.GetInventoryFilePath(), content, 0600)
+	utils.ExitIfError(err)
+}
+
+// Last inventory of the vault, nil if there is none
+func readVaultInventory(restorationContext *RestorationContext) *awsutils.VaultInventory {
+	content, err := ioutil.ReadFile(restorationContext.GetInventoryFilePath())
+	if err != nil {
+		return nil
+	}
+	vaultInventory := &awsutils.VaultInventory{}
+	err = json.Unmarshal(content, vaultInventory)
+	utils.ExitIfError(err)
+	return vaultInventory
+}
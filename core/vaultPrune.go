@@ -0,0 +1,139 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"rsg/awsutils"
+	"rsg/inputs"
+	"rsg/outputs"
+	"rsg/utils"
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Deletion of orphaned archives of the vault (found by the last audit, referenced by no row of the mapping file).
+// Safeguards:
+// - dry run by default
+// - archives younger than a minimum age are kept (a backup in progress could reference them soon)
+// - the mapping archive must be created after the inventory, else archives uploaded after it are orphans
+// - deletions are confirmed by the user with their number and size
+// - each deletion is written into a journal before and after the aws call
+
+const pruneJournalFileName = "prune-journal.log"
+
+func (restorationContext *RestorationContext) GetPruneJournalFilePath() string {
+	return restorationContext.WorkingDirPath + "/" + pruneJournalFileName
+}
+
+func PruneVault(restorationContext *RestorationContext) {
+	if restorationContext.MappingFilePath != "" {
+		utils.ExitIfError(errors.New("Prune needs the mapping file downloaded from the mapping vault, not a mapping file given by option"))
+	}
+	vaultInventory := readVaultInventory(restorationContext)
+	if vaultInventory == nil {
+		utils.ExitIfError(errors.New("No inventory of the vault, run audit before prune"))
+	}
+	utils.ExitIfError(checkMappingIsNewerThanInventory(restorationContext, vaultInventory))
+
+	mappingStore := InitMappingStore(restorationContext.GetMappingFilePath())
+	result, err := reconcileVaultInventory(mappingStore, vaultInventory)
+	mappingStore.Close()
+	utils.ExitIfError(err)
+	archivesToDelete, youngArchives := selectArchivesToPrune(result.orphans, restorationContext.Options.MinAge, time.Now())
+	size := uint64(0)
+	for _, archive := range archivesToDelete {
+		outputs.Printfln(outputs.Info, "orphan\t%s\t%s\t%s", archive.ArchiveId, bytefmt.ByteSize(archive.Size), archive.CreationDate)
+		size += archive.Size
+	}
+	if youngArchives > 0 {
+		outputs.Printfln(outputs.OptionalInfo, "%d orphaned archive(s) younger than %v are kept", youngArchives, restorationContext.Options.MinAge)
+	}
+	outputs.Printfln(outputs.Info, "%d archive(s) to delete (%s)", len(archivesToDelete), bytefmt.ByteSize(size))
+	if len(archivesToDelete) == 0 {
+		return
+	}
+	if restorationContext.Options.DryRun {
+		outputs.Println(outputs.Info, "Dry run, no archive deleted (use --dry-run=false to delete them)")
+		return
+	}
+	if !inputs.QueryYesOrNo(fmt.Sprintf("Delete %d archive(s) (%s) from vault %s ? It cannot be undone", len(archivesToDelete), bytefmt.ByteSize(size), restorationContext.Vault), false) {
+		return
+	}
+	deleted := deleteArchives(restorationContext, archivesToDelete)
+	removeArchivesFromInventory(restorationContext, vaultInventory, deleted)
+	outputs.Printfln(outputs.Info, "%d archive(s) deleted, journal: %s", len(deleted), restorationContext.GetPruneJournalFilePath())
+}
+
+// Compares the creation date of the mapping archive of the local mapping file with the date of the inventory
+func checkMappingIsNewerThanInventory(restorationContext *RestorationContext, vaultInventory *awsutils.VaultInventory) error {
+	inventoryDate, err := time.Parse(time.RFC3339, vaultInventory.InventoryDate)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Invalid date of inventory: %s", vaultInventory.InventoryDate))
+	}
+	mappingArchive := restorationContext.RegionVaultCache.LocalMappingArchive
+	if mappingArchive == nil || mappingArchive.CreationDate == "" {
+		return errors.New("Mapping archive of the local mapping file is unknown, download mapping file again (--refresh-mapping-file) before prune")
+	}
+	creationDate, err := time.Parse(time.RFC3339, mappingArchive.CreationDate)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Invalid creation date of mapping archive %s: %s", mappingArchive.ArchiveId, mappingArchive.CreationDate))
+	}
+	if creationDate.Before(inventoryDate) {
+		return errors.New(fmt.Sprintf("Mapping archive (%s) is older than inventory of the vault (%s), refresh mapping file before prune",
+			mappingArchive.CreationDate, vaultInventory.InventoryDate))
+	}
+	return nil
+}
+
+// Orphans created before now - minAge, and number of younger orphans. Orphans without valid creation date are kept.
+func selectArchivesToPrune(orphans []awsutils.InventoryArchive, minAge time.Duration, now time.Time) ([]awsutils.InventoryArchive, int) {
+	archivesToDelete := []awsutils.InventoryArchive{}
+	youngArchives := 0
+	for _, orphan := range orphans {
+		creationDate, err := time.Parse(time.RFC3339, orphan.CreationDate)
+		if err != nil || now.Sub(creationDate) < minAge {
+			youngArchives++
+		} else {
+			archivesToDelete = append(archivesToDelete, orphan)
+		}
+	}
+	return archivesToDelete, youngArchives
+}
+
+// Ids of deleted archives
+func deleteArchives(restorationContext *RestorationContext, archives []awsutils.InventoryArchive) map[string]bool {
+	journal, err := os.OpenFile(restorationContext.GetPruneJournalFilePath(), os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0600)
+	utils.ExitIfError(err)
+	defer utils.CheckingClose(journal, &err)
+	deleted := make(map[string]bool)
+	for _, archive := range archives {
+		writeJournal(journal, "delete", archive, "")
+		if err := awsutils.DeleteArchive(restorationContext.GlacierClient, restorationContext.Vault, archive.ArchiveId); err != nil {
+			writeJournal(journal, "failed", archive, err.Error())
+			outputs.Printfln(outputs.Warning, "Cannot delete archive %s: %v", archive.ArchiveId, err)
+			continue
+		}
+		writeJournal(journal, "deleted", archive, "")
+		deleted[archive.ArchiveId] = true
+	}
+	return deleted
+}
+
+func writeJournal(journal *os.File, action string, archive awsutils.InventoryArchive, message string) {
+	_, err := fmt.Fprintf(journal, "%s\t%s\t%s\t%d\t%s\t%s\n", time.Now().UTC().Format(time.RFC3339), action, archive.ArchiveId, archive.Size, archive.CreationDate, message)
+	utils.ExitIfError(err)
+	utils.ExitIfError(journal.Sync())
+}
+
+// Deleted archives are no more orphans of the next prune
+func removeArchivesFromInventory(restorationContext *RestorationContext, vaultInventory *awsutils.VaultInventory, archiveIds map[string]bool) {
+	archives := []awsutils.InventoryArchive{}
+	for _, archive := range vaultInventory.ArchiveList {
+		if !archiveIds[archive.ArchiveId] {
+			archives = append(archives, archive)
+		}
+	}
+	vaultInventory.ArchiveList = archives
+	writeVaultInventory(restorationContext, vaultInventory)
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+	"rsg/awsutils"
+	"rsg/consts"
+	"rsg/inputs"
+	"rsg/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/glacier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func initPruneTest(t *testing.T) (*GlacierMock, *RestorationContext) {
+	glacierMock, restorationContext := InitTestWithGlacier()
+	createMappingFromFixture(t, "mapping_dsm5.sqllite", restorationContext.GetMappingFilePath()).Close()
+	writeVaultInventory(restorationContext, &awsutils.VaultInventory{InventoryDate: "2016-10-20T10:00:00Z", ArchiveList: []awsutils.InventoryArchive{
+		{ArchiveId: "archiveId1", CreationDate: "2016-09-01T10:00:00Z", Size: 5},
+		{ArchiveId: "archiveId2", CreationDate: "2016-09-01T10:00:00Z", Size: 12},
+		{ArchiveId: "orphanA", CreationDate: "2016-09-02T10:00:00Z", Size: 1024},
+	}})
+	restorationContext.RegionVaultCache.LocalMappingArchive = &awsutils.InventoryArchive{ArchiveId: "mappingArchiveId", CreationDate: "2016-10-20T12:00:00Z"}
+	restorationContext.Options.MinAge = 90 * 24 * time.Hour
+	return glacierMock, restorationContext
+}
+
+func mockDeleteArchive(glacierMock *GlacierMock, vault, archiveId string) *mock.Call {
+	params := &glacier.DeleteArchiveInput{
+		AccountId: aws.String(awsutils.AccountId),
+		ArchiveId: aws.String(archiveId),
+		VaultName: aws.String(vault),
+	}
+	return glacierMock.On("DeleteArchive", params).Return(&glacier.DeleteArchiveOutput{}, nil)
+}
+
+func TestSelectArchivesToPrune_keep_young_archives(t *testing.T) {
+	// Given
+	now := time.Date(2016, 10, 20, 10, 0, 0, 0, time.UTC)
+	orphans := []awsutils.InventoryArchive{
+		{ArchiveId: "old", CreationDate: "2016-07-01T10:00:00Z"},
+		{ArchiveId: "young", CreationDate: "2016-10-01T10:00:00Z"},
+		{ArchiveId: "unknown", CreationDate: ""},
+	}
+
+	// When
+	archivesToDelete, youngArchives := selectArchivesToPrune(orphans, 90 * 24 * time.Hour, now)
+
+	// Then
+	assert.Equal(t, []awsutils.InventoryArchive{orphans[0]}, archivesToDelete)
+	assert.Equal(t, 2, youngArchives)
+}
+
+func TestCheckMappingIsNewerThanInventory(t *testing.T) {
+	// Given
+	CommonInitTest()
+	_, restorationContext := InitTestWithGlacier()
+	restorationContext.RegionVaultCache.LocalMappingArchive = &awsutils.InventoryArchive{ArchiveId: "mappingArchiveId", CreationDate: "2016-10-19T10:00:00Z"}
+	// the local mapping file is newer than both inventories, only the creation date of its archive matters
+	ioutil.WriteFile(restorationContext.GetMappingFilePath(), []byte("hello !"), 0600)
+
+	// When
+	errOlder := checkMappingIsNewerThanInventory(restorationContext, &awsutils.VaultInventory{InventoryDate: "2016-10-20T10:00:00Z"})
+	errNewer := checkMappingIsNewerThanInventory(restorationContext, &awsutils.VaultInventory{InventoryDate: "2016-10-18T10:00:00Z"})
+
+	// Then
+	assert.EqualError(t, errOlder, "Mapping archive (2016-10-19T10:00:00Z) is older than inventory of the vault (2016-10-20T10:00:00Z), refresh mapping file before prune")
+	assert.Nil(t, errNewer)
+}
+
+func TestCheckMappingIsNewerThanInventory_local_mapping_archive_unknown(t *testing.T) {
+	// Given
+	CommonInitTest()
+	_, restorationContext := InitTestWithGlacier()
+	// an inventory of mapping vault doesn't tell which archive the local mapping file comes from
+	restorationContext.RegionVaultCache.MappingArchives = []awsutils.InventoryArchive{
+		{ArchiveId: "mappingArchiveId2", CreationDate: "2016-10-21T10:00:00Z"},
+		{ArchiveId: "mappingArchiveId1", CreationDate: "2016-10-19T10:00:00Z"},
+	}
+
+	// When
+	err := checkMappingIsNewerThanInventory(restorationContext, &awsutils.VaultInventory{InventoryDate: "2016-10-20T10:00:00Z"})
+	restorationContext.RegionVaultCache.LocalMappingArchive = &awsutils.InventoryArchive{ArchiveId: "mappingArchiveId2"}
+	errWithoutCreationDate := checkMappingIsNewerThanInventory(restorationContext, &awsutils.VaultInventory{InventoryDate: "2016-10-20T10:00:00Z"})
+
+	// Then
+	assert.EqualError(t, err, "Mapping archive of the local mapping file is unknown, download mapping file again (--refresh-mapping-file) before prune")
+	assert.EqualError(t, errWithoutCreationDate, "Mapping archive of the local mapping file is unknown, download mapping file again (--refresh-mapping-file) before prune")
+}
+
+func TestPruneVault_dry_run(t *testing.T) {
+	// Given
+	buffer := CommonInitTest()
+	glacierMock, restorationContext := initPruneTest(t)
+	restorationContext.Options.DryRun = true
+
+	// When
+	PruneVault(restorationContext)
+
+	// Then
+	glacierMock.AssertNotCalled(t, "DeleteArchive", mock.Anything)
+	assert.Equal(t, "orphan\torphanA\t1K\t2016-09-02T10:00:00Z" + consts.LINE_BREAK +
+		"1 archive(s) to delete (1K)" + consts.LINE_BREAK +
+		"Dry run, no archive deleted (use --dry-run=false to delete them)" + consts.LINE_BREAK, string(buffer.Bytes()))
+	assert.False(t, utils.Exists(restorationContext.GetPruneJournalFilePath()))
+}
+
+func TestPruneVault_delete_confirmed(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := initPruneTest(t)
+	inputs.StdinReader = bufio.NewReader(bytes.NewReader([]byte("y" + consts.LINE_BREAK)))
+	mockDeleteArchive(glacierMock, restorationContext.Vault, "orphanA")
+
+	// When
+	PruneVault(restorationContext)
+
+	// Then
+	glacierMock.AssertNumberOfCalls(t, "DeleteArchive", 1)
+	journal, _ := ioutil.ReadFile(restorationContext.GetPruneJournalFilePath())
+	lines := strings.Split(strings.TrimSuffix(string(journal), "\n"), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[0], "\tdelete\torphanA\t1024\t2016-09-02T10:00:00Z\t")
+	assert.Contains(t, lines[1], "\tdeleted\torphanA\t1024\t2016-09-02T10:00:00Z\t")
+	assert.Equal(t, 2, len(readVaultInventory(restorationContext).ArchiveList))
+}
+
+func TestPruneVault_delete_not_confirmed(t *testing.T) {
+	// Given
+	CommonInitTest()
+	glacierMock, restorationContext := initPruneTest(t)
+	inputs.StdinReader = bufio.NewReader(bytes.NewReader([]byte(consts.LINE_BREAK)))
+
+	// When
+	PruneVault(restorationContext)
+
+	// Then
+	glacierMock.AssertNotCalled(t, "DeleteArchive", mock.Anything)
+	assert.Equal(t, 3, len(readVaultInventory(restorationContext).ArchiveList))
+}
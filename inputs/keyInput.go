@@ -0,0 +1,51 @@
+package inputs
+
+import (
+	"rsg/utils"
+)
+
+// Keys typed in a terminal in raw mode (see StartRawMode), arrows are read from their escape sequences
+
+type Key int
+
+const (
+	KeyRune Key = iota
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyEnter
+	KeyBackspace
+)
+
+// Returns the key and, if the key is KeyRune, the character typed
+func ReadKey() (Key, rune) {
+	r, _, err := StdinReader.ReadRune()
+	utils.ExitIfError(err)
+	switch r {
+	case '\r', '\n':
+		return KeyEnter, r
+	case '\b', 127:
+		return KeyBackspace, r
+	case 27:
+		next, _, err := StdinReader.ReadRune()
+		utils.ExitIfError(err)
+		if next != '[' && next != 'O' {
+			return KeyRune, next
+		}
+		code, _, err := StdinReader.ReadRune()
+		utils.ExitIfError(err)
+		switch code {
+		case 'A':
+			return KeyUp, code
+		case 'B':
+			return KeyDown, code
+		case 'C':
+			return KeyRight, code
+		case 'D':
+			return KeyLeft, code
+		}
+		return KeyRune, 0
+	}
+	return KeyRune, r
+}
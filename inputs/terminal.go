@@ -0,0 +1,40 @@
+package inputs
+
+import (
+	"os"
+	"os/signal"
+	"rsg/outputs"
+)
+
+// Keys are read one by one, without echo, from the terminal in raw mode. Raw mode is only used when keys are read
+// from the standard input (not when StdinReader is replaced) and if the terminal supports it.
+
+var osStdinReader = StdinReader
+
+// Returns the function restoring the terminal, the terminal is also restored on interrupt
+func StartRawMode() func() {
+	if StdinReader != osStdinReader {
+		return func() {}
+	}
+	restore, err := enterRawMode()
+	if err != nil {
+		outputs.Printfln(outputs.Verbose, "Raw mode of terminal is not available: %v", err)
+		return func() {}
+	}
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	done := make(chan bool)
+	go func() {
+		select {
+		case <-interrupts:
+			restore()
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(interrupts)
+		close(done)
+		restore()
+	}
+}
@@ -0,0 +1,30 @@
+// +build !windows
+
+package inputs
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Line buffering and echo are disabled with stty, the previous settings are restored
+func enterRawMode() (func(), error) {
+	settings, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	return func() {
+		stty(strings.TrimSpace(settings))
+	}, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return string(output), err
+}
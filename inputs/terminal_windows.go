@@ -0,0 +1,47 @@
+package inputs
+
+import (
+	"os"
+	"syscall"
+)
+
+// Line input and echo of the console are disabled, virtual terminal sequences are enabled for arrows and escape codes
+// of outputs
+
+const (
+	enableLineInput                 = 0x0002
+	enableEchoInput                 = 0x0004
+	enableVirtualTerminalInput      = 0x0200
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+var setConsoleModeProc = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+func enterRawMode() (func(), error) {
+	stdin, stdout := syscall.Handle(os.Stdin.Fd()), syscall.Handle(os.Stdout.Fd())
+	var inMode, outMode uint32
+	if err := syscall.GetConsoleMode(stdin, &inMode); err != nil {
+		return nil, err
+	}
+	if err := syscall.GetConsoleMode(stdout, &outMode); err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(stdin, inMode &^ (enableLineInput | enableEchoInput) | enableVirtualTerminalInput); err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(stdout, outMode | enableVirtualTerminalProcessing); err != nil {
+		setConsoleMode(stdin, inMode)
+		return nil, err
+	}
+	return func() {
+		setConsoleMode(stdin, inMode)
+		setConsoleMode(stdout, outMode)
+	}, nil
+}
+
+func setConsoleMode(handle syscall.Handle, mode uint32) error {
+	if result, _, err := setConsoleModeProc.Call(uintptr(handle), uintptr(mode)); result == 0 {
+		return err
+	}
+	return nil
+}
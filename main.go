@@ -6,6 +6,8 @@ import (
 	"rsg/awsutils"
 	"rsg/utils"
 	"rsg/options"
+	"errors"
+	"fmt"
 )
 
 const version = "0.0.1-SNAPSHOT"
@@ -18,10 +20,109 @@ func main() {
 		outputs.Printfln(outputs.Info, "Version %v (%v)", version, date)
 		return
 	}
+	switch options.Command {
+	case "":
+		restore(options)
+	case "find":
+		restorationContext := loadLocalOrDownloadMappingFile(options)
+		core.FindFiles(restorationContext)
+	case "du":
+		restorationContext := loadLocalOrDownloadMappingFile(options)
+		core.DiskUsage(restorationContext)
+	case "stats":
+		restorationContext := loadLocalOrDownloadMappingFile(options)
+		core.MappingStats(restorationContext)
+	case "snapshots":
+		restorationContext := loadLocalOrDownloadMappingFile(options)
+		core.ListMappingSnapshots(restorationContext)
+	case "mapping-archives":
+		restorationContext := loadLocalOrDownloadMappingFile(options)
+		core.ListMappingArchives(restorationContext)
+	case "diff":
+		restorationContext := loadLocalOrDownloadMappingFile(options)
+		core.DiffMappings(restorationContext)
+	case "plan":
+		restorationContext := loadLocalOrDownloadMappingFile(options)
+		core.PlanRestoration(restorationContext)
+	case "verify":
+		restorationContext := loadLocalOrDownloadMappingFile(options)
+		core.VerifyRestoration(restorationContext)
+	case "audit":
+		restorationContext := createRestorationContext(options)
+		awsutils.LoadJobIdsAtStartup(restorationContext.GlacierClient, restorationContext.MappingVault, restorationContext.Vault)
+		core.DownloadMappingArchive(restorationContext)
+		core.IndexMappingFileIfNecessary(restorationContext)
+		core.AuditVault(restorationContext)
+	case "prune":
+		restorationContext := createRestorationContext(options)
+		awsutils.LoadJobIdsAtStartup(restorationContext.GlacierClient, restorationContext.MappingVault, restorationContext.Vault)
+		core.DownloadMappingArchive(restorationContext)
+		core.IndexMappingFileIfNecessary(restorationContext)
+		core.PruneVault(restorationContext)
+	case "drill":
+		if len(options.CommandArgs) > 0 && options.CommandArgs[0] == "history" {
+			restorationContext := loadLocalOrDownloadMappingFile(options)
+			core.DisplayDrillHistory(restorationContext)
+			return
+		}
+		restorationContext := createRestorationContext(options)
+		awsutils.LoadJobIdsAtStartup(restorationContext.GlacierClient, restorationContext.MappingVault, restorationContext.Vault)
+		core.DownloadMappingArchive(restorationContext)
+		core.IndexMappingFileIfNecessary(restorationContext)
+		core.RestorabilityDrill(restorationContext)
+	case "policy":
+		restorationContext := createRestorationContext(options)
+		if len(options.CommandArgs) == 0 {
+			core.DisplayRetrievalPolicy(restorationContext)
+			return
+		}
+		core.SetRetrievalPolicy(restorationContext, options.CommandArgs)
+	case "browse":
+		restorationContext := loadLocalOrDownloadMappingFile(options)
+		selection := core.BrowseFiles(restorationContext)
+		if selection != nil {
+			if restorationContext.GlacierClient == nil {
+				options.Region, options.Vault = restorationContext.Region, restorationContext.Vault
+				restorationContext = createRestorationContext(options)
+				awsutils.LoadJobIdsAtStartup(restorationContext.GlacierClient, restorationContext.MappingVault, restorationContext.Vault)
+			}
+			restorationContext.Options.Selection = selection
+			err := core.CheckDestinationDirectory(restorationContext)
+			utils.ExitIfError(err)
+			core.DownloadArchives(restorationContext)
+		}
+	default:
+		utils.ExitIfError(errors.New(fmt.Sprintf("Unknown command: %s", options.Command)))
+	}
+}
+
+func createRestorationContext(options options.Options) *core.RestorationContext {
 	core.DisplayInfoAboutCosts(options)
 	awsutils.LoadAccountSession(options.AwsId, options.AwsSecret)
 	region, vaultName := core.SelectRegionVault(options.Region, options.Vault)
-	restorationContext := core.CreateRestorationContext(region, vaultName, options)
+	return core.CreateRestorationContext(region, vaultName, options)
+}
+
+// Mapping file is downloaded only if there is no local copy
+func loadLocalOrDownloadMappingFile(options options.Options) *core.RestorationContext {
+	restorationContext := core.CreateLocalRestorationContext(options)
+	if restorationContext == nil {
+		restorationContext = createRestorationContext(options)
+		awsutils.LoadJobIdsAtStartup(restorationContext.GlacierClient, restorationContext.MappingVault, restorationContext.Vault)
+		core.DownloadMappingArchive(restorationContext)
+	}
+	core.IndexMappingFileIfNecessary(restorationContext)
+	return restorationContext
+}
+
+func restore(options options.Options) {
+	if options.List && !options.ListJobs {
+		restorationContext := loadLocalOrDownloadMappingFile(options)
+		core.QueryFiltersIfNecessary(restorationContext)
+		core.ListArchives(restorationContext)
+		return
+	}
+	restorationContext := createRestorationContext(options)
 
 	if options.ListJobs {
 		core.ListJobs(restorationContext)
@@ -29,16 +130,10 @@ func main() {
 	} else {
 		awsutils.LoadJobIdsAtStartup(restorationContext.GlacierClient, restorationContext.MappingVault, restorationContext.Vault)
 		core.DownloadMappingArchive(restorationContext)
+		core.IndexMappingFileIfNecessary(restorationContext)
 		core.QueryFiltersIfNecessary(restorationContext)
-		if options.List {
-			core.ListArchives(restorationContext)
-		} else {
-			err := core.CheckDestinationDirectory(restorationContext)
-			utils.ExitIfError(err)
-			core.DownloadArchives(restorationContext)
-		}
+		err := core.CheckDestinationDirectory(restorationContext)
+		utils.ExitIfError(err)
+		core.DownloadArchives(restorationContext)
 	}
-
 }
-
-
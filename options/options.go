@@ -3,6 +3,13 @@ package options
 import (
 	flag "github.com/spf13/pflag"
 	"rsg/outputs"
+	"rsg/utils"
+	"strconv"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"code.cloudfoundry.org/bytefmt"
 )
 
 type Options struct {
@@ -18,7 +25,37 @@ type Options struct {
 	InfoMessage        bool
 	RefreshMappingFile *bool
 	KeepFiles          *bool
-	Version          bool
+	Version            bool
+	FileVersion        string
+	AllVersions        bool
+	ChecksumManifest   bool
+	Command            string   // first argument, restore if empty
+	CommandArgs        []string // next arguments
+	Extensions         []string
+	Shares             []string
+	MinSize            uint64
+	MaxSize            uint64
+	FullText           bool
+	Top                int
+	MappingMaxAge      time.Duration
+	MappingArchive     string
+	MappingInventory   bool
+	MappingFile        string
+	DryRun             bool
+	MinAge             time.Duration
+	Sample             int
+	MaxBytes           uint64
+	Stratify           bool
+	DownloadStreams    int
+	MaxRate            uint64
+	Schedule           string
+	MaxBytesByHour     uint64
+	MaxBytesByDay      uint64
+	MaxBytesByMonth    uint64
+	MaxCostByDay       float64
+	MaxCostByMonth     float64
+	Speed              uint64
+	SpeedTestUrl       string
 }
 
 func ParseOptions() Options {
@@ -35,10 +72,58 @@ func ParseOptions() Options {
 	flag.BoolVar(&options.ListJobs, "list-jobs", false, "list aws jobs")
 	flag.BoolVar(&options.InfoMessage, "info-messages", true, "display information messages")
 	flag.BoolVar(&options.Version, "version", false, "display version")
+	flag.StringVar(&options.FileVersion, "file-version", "latest", "version of files stored several times: latest, oldest or key of the version")
+	flag.BoolVar(&options.AllVersions, "all-versions", false, "restore all versions of files side by side, suffixed by the key of the version")
+	flag.BoolVar(&options.ChecksumManifest, "checksum-manifest", false, "write sha256 manifests of restored files into destination directory")
+	flag.StringSliceVar(&options.Extensions, "ext", []string{}, "find files with extension(s)")
+	flag.StringSliceVar(&options.Shares, "share", []string{}, "find files of share(s)")
+	minSize := flag.String("min-size", "", "find files bigger than size (ex: 10M)")
+	maxSize := flag.String("max-size", "", "find files smaller than size (ex: 1G)")
+	flag.BoolVar(&options.FullText, "full-text", false, "find files with full text search on path segments (word prefixes)")
+	flag.IntVar(&options.Top, "top", 10, "number of largest files displayed by stats")
+	mappingMaxAge := flag.String("mapping-max-age", "", "refresh mapping file when it is older than this age (ex: 12h, 7d), instead of when mapping vault changes")
+	flag.StringVar(&options.MappingFile, "mapping-file", "", "path to a local mapping file, used instead of downloading it (list, find, du, plan and verify run without aws)")
+	flag.StringVar(&options.MappingArchive, "mapping-archive", "latest", "archive of mapping vault to download if it has several: latest, choose, archive id or creation date")
+	flag.BoolVar(&options.MappingInventory, "mapping-inventory", false, "refresh mapping file when an inventory of mapping vault (free, up to 4 hours) finds a newer mapping archive, instead of when mapping vault changes")
+	flag.BoolVar(&options.DryRun, "dry-run", true, "display archives deleted by prune without deleting them, --dry-run=false to delete them")
+	minAge := flag.String("min-age", "90d", "minimum age of archives deleted by prune (aws charges deletion of archives younger than 90 days)")
+	flag.IntVar(&options.Sample, "sample", 10, "number of archives retrieved by drill")
+	maxBytes := flag.String("max-bytes", "", "maximum size of archives retrieved by drill (ex: 1G)")
+	flag.BoolVar(&options.Stratify, "stratify", false, "sample archives of drill across shares and sizes")
+	flag.IntVar(&options.DownloadStreams, "download-streams", 4, "number of parallel downloads of a retrieved range")
+	maxRate := flag.String("max-rate", "", "maximum download rate by second, shared by all downloads (ex: 2M)")
+	flag.StringVar(&options.Schedule, "schedule", "", "rates and pauses of retrieval jobs by time window, separated by ';' (ex: \"20:00-07:00=unlimited;weekdays=no-jobs\")")
+	budgetHour := flag.String("budget-hour", "", "maximum bytes retrieved by hour, by all runs (ex: 10G)")
+	budgetDay := flag.String("budget-day", "", "maximum bytes retrieved by day, by all runs (ex: 100G)")
+	budgetMonth := flag.String("budget-month", "", "maximum bytes retrieved by month, by all runs (ex: 1T)")
+	flag.Float64Var(&options.MaxCostByDay, "budget-cost-day", 0, "maximum cost in dollars of retrievals by day, by all runs")
+	flag.Float64Var(&options.MaxCostByMonth, "budget-cost-month", 0, "maximum cost in dollars of retrievals by month, by all runs")
+	speed := flag.String("speed", "", "download speed by second used to size retrieval jobs, instead of estimating it (ex: 10M)")
+	flag.StringVar(&options.SpeedTestUrl, "speed-test-url", "", "url downloaded to test download speed when there is no speed of previous downloads")
 	options.RefreshMappingFile = flag.Bool("refresh-mapping-file", false, "enable or disable refresh of mapping file")
 	options.KeepFiles = flag.Bool("keep-files", true, "enable or disable keep existing files")
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		options.Command = flag.Arg(0)
+		options.CommandArgs = flag.Args()[1:]
+	}
+	options.MinSize = parseSizeOption("min-size", *minSize)
+	options.MaxSize = parseSizeOption("max-size", *maxSize)
+	options.MaxBytes = parseSizeOption("max-bytes", *maxBytes)
+	options.MaxBytesByHour = parseSizeOption("budget-hour", *budgetHour)
+	options.MaxBytesByDay = parseSizeOption("budget-day", *budgetDay)
+	options.MaxBytesByMonth = parseSizeOption("budget-month", *budgetMonth)
+	options.Speed = parseSizeOption("speed", strings.TrimSuffix(*speed, "/s"))
+	options.MaxRate = parseSizeOption("max-rate", strings.TrimSuffix(*maxRate, "/s"))
+	options.MappingMaxAge = parseDurationOption("mapping-max-age", *mappingMaxAge)
+	options.MinAge = parseDurationOption("min-age", *minAge)
+	if options.FileVersion != "latest" && options.FileVersion != "oldest" {
+		if _, err := strconv.ParseInt(options.FileVersion, 10, 64); err != nil {
+			utils.ExitIfError(errors.New(fmt.Sprintf("Invalid file version: %s (latest, oldest or key of the version)", options.FileVersion)))
+		}
+	}
+
 	if !flag.Lookup("refresh-mapping-file").Changed {
 		options.RefreshMappingFile = nil
 	}
@@ -57,16 +142,38 @@ func ParseOptions() Options {
 
 	outputs.VerboseFlag = options.Verbose
 	outputs.OptionalInfoFlag = options.InfoMessage
+	outputs.Printfln(outputs.Verbose, "Options all-versions: %v", options.AllVersions)
 	outputs.Printfln(outputs.Verbose, "Options aws-id: %v", awsIdTruncated)
 	outputs.Printfln(outputs.Verbose, "Options aws-secret: %v", awsSecretTruncated)
+	outputs.Printfln(outputs.Verbose, "Options command: %v %v", options.Command, options.CommandArgs)
+	outputs.Printfln(outputs.Verbose, "Options budget-cost-day: %v", options.MaxCostByDay)
+	outputs.Printfln(outputs.Verbose, "Options budget-cost-month: %v", options.MaxCostByMonth)
+	outputs.Printfln(outputs.Verbose, "Options budget-day: %v", options.MaxBytesByDay)
+	outputs.Printfln(outputs.Verbose, "Options budget-hour: %v", options.MaxBytesByHour)
+	outputs.Printfln(outputs.Verbose, "Options budget-month: %v", options.MaxBytesByMonth)
+	outputs.Printfln(outputs.Verbose, "Options checksum-manifest: %v", options.ChecksumManifest)
 	outputs.Printfln(outputs.Verbose, "Options destination: %v", options.Dest)
+	outputs.Printfln(outputs.Verbose, "Options download-streams: %v", options.DownloadStreams)
+	outputs.Printfln(outputs.Verbose, "Options dry-run: %v", options.DryRun)
+	outputs.Printfln(outputs.Verbose, "Options ext: %v", options.Extensions)
+	outputs.Printfln(outputs.Verbose, "Options file-version: %v", options.FileVersion)
 	outputs.Printfln(outputs.Verbose, "Options filters: %v", options.Filters)
+	outputs.Printfln(outputs.Verbose, "Options full-text: %v", options.FullText)
 	if options.KeepFiles != nil {
 		outputs.Printfln(outputs.Verbose, "Options keep-files: %v ", *options.KeepFiles)
 	} else {
 		outputs.Println(outputs.Verbose, "Options keep-files: nil", )
 	}
 	outputs.Printfln(outputs.Verbose, "Options list: %v", options.List)
+	outputs.Printfln(outputs.Verbose, "Options mapping-archive: %v", options.MappingArchive)
+	outputs.Printfln(outputs.Verbose, "Options mapping-file: %v", options.MappingFile)
+	outputs.Printfln(outputs.Verbose, "Options mapping-inventory: %v", options.MappingInventory)
+	outputs.Printfln(outputs.Verbose, "Options mapping-max-age: %v", options.MappingMaxAge)
+	outputs.Printfln(outputs.Verbose, "Options max-bytes: %v", options.MaxBytes)
+	outputs.Printfln(outputs.Verbose, "Options max-rate: %v", options.MaxRate)
+	outputs.Printfln(outputs.Verbose, "Options max-size: %v", options.MaxSize)
+	outputs.Printfln(outputs.Verbose, "Options min-age: %v", options.MinAge)
+	outputs.Printfln(outputs.Verbose, "Options min-size: %v", options.MinSize)
 	outputs.Printfln(outputs.Verbose, "Options list jobs: %v", options.ListJobs)
 	outputs.Printfln(outputs.Verbose, "Options info-messages: %v", options.InfoMessage)
 	if options.RefreshMappingFile != nil {
@@ -75,8 +182,47 @@ func ParseOptions() Options {
 		outputs.Println(outputs.Verbose, "Options refresh-mapping-file: nil", )
 	}
 	outputs.Printfln(outputs.Verbose, "Options region: %v", options.Region)
+	outputs.Printfln(outputs.Verbose, "Options sample: %v", options.Sample)
+	outputs.Printfln(outputs.Verbose, "Options schedule: %v", options.Schedule)
+	outputs.Printfln(outputs.Verbose, "Options share: %v", options.Shares)
+	outputs.Printfln(outputs.Verbose, "Options speed: %v", options.Speed)
+	outputs.Printfln(outputs.Verbose, "Options speed-test-url: %v", options.SpeedTestUrl)
+	outputs.Printfln(outputs.Verbose, "Options stratify: %v", options.Stratify)
+	outputs.Printfln(outputs.Verbose, "Options top: %v", options.Top)
 	outputs.Printfln(outputs.Verbose, "Options vault: %v", options.Vault)
 	outputs.Printfln(outputs.Verbose, "Options verbose: %v", options.Verbose)
 	outputs.Printfln(outputs.Verbose, "Options version: %v", options.Version)
 	return options
-}
\ No newline at end of file
+}
+
+// Size in bytes or with unit (K, M, G...), 0 if empty
+func parseSizeOption(name, value string) uint64 {
+	if value == "" {
+		return 0
+	}
+	if size, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return size
+	}
+	size, err := bytefmt.ToBytes(value)
+	if err != nil {
+		utils.ExitIfError(errors.New(fmt.Sprintf("Invalid %s: %s (%v)", name, value, err)))
+	}
+	return size
+}
+
+// Go duration (ex: 90m, 12h) or number of days (ex: 7d), 0 if empty
+func parseDurationOption(name, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.ParseUint(strings.TrimSuffix(value, "d"), 10, 32); err == nil {
+			return time.Duration(days) * 24 * time.Hour
+		}
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		utils.ExitIfError(errors.New(fmt.Sprintf("Invalid %s: %s (%v)", name, value, err)))
+	}
+	return duration
+}
@@ -2,6 +2,7 @@ package outputs
 
 import (
 	"os"
+	"sync"
 	"io"
 	"fmt"
 	"rsg/consts"
@@ -17,6 +18,9 @@ const (
 
 type Level int
 
+// messages can be printed by concurrent downloads
+var printMutex sync.Mutex
+
 var (
 	VerboseFlag bool = false
 	OptionalInfoFlag bool = true
@@ -79,5 +83,7 @@ func print(level Level, toPrint string) {
 		writer = errorWriter
 		toPrint = "ERROR: " + toPrint;
 	}
+	printMutex.Lock()
+	defer printMutex.Unlock()
 	fmt.Fprint(writer, toPrint)
 }
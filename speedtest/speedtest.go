@@ -1,51 +1,37 @@
 package speedtest
 
 import (
-	"rsg/outputs"
-	"net/http"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
-	"regexp"
+	"net/http"
 	"time"
-	"errors"
+	"rsg/outputs"
 )
 
-func SpeedTest() (uint64, error) {
-	resp, err := http.Get("https://golang.org/dl/")
+// Downloads the content of the url and returns the download speed in bytes by second
+func SpeedTest(url string) (uint64, error) {
+	outputs.Printfln(outputs.Verbose, "Start download speed test on %v", url)
+	start := time.Now()
+	resp, err := http.Get(url)
 	if err != nil {
 		return 0, err
 	}
-	content, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(fmt.Sprintf("Download of %s failed: %s", url, resp.Status))
+	}
+	downloadSize, err := io.Copy(ioutil.Discard, resp.Body)
 	if err != nil {
 		return 0, err
 	}
-	re := regexp.MustCompile("href=\"(.+src.tar.gz)\"")
-	submatches := re.FindStringSubmatch(string(content))
-	if len(submatches) > 1 {
-		link := submatches[1]
-		if link != "" {
-			outputs.Printfln(outputs.Verbose, "Start download speed test on %v", link)
-			resp, err = http.Get(link)
-			if err != nil {
-				return 0, err
-			}
-			start := time.Now()
-			content, err = ioutil.ReadAll(resp.Body)
-			if err != nil {
-				return 0, err
-			}
-			downloadSize := uint64(len(content))
-			downloadDuration := time.Since(start)
-			if downloadDuration.Seconds() == 0 {
-				return downloadSize, nil;
-			} else {
-				return downloadSize / uint64(downloadDuration.Seconds()), nil
-			}
-		}
+	downloadDuration := time.Since(start)
+	if downloadSize == 0 {
+		return 0, errors.New(fmt.Sprintf("Content of %s is empty", url))
 	}
-	return 0, errors.New("No test link found")
+	if downloadDuration.Seconds() == 0 {
+		return uint64(downloadSize), nil
+	}
+	return uint64(float64(downloadSize) / downloadDuration.Seconds()), nil
 }
-
-
-
-
-